@@ -1,10 +1,14 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"log" // Keep for os.Getenv as a fallback if rootCurrentClientId isn't populated by PersistentPreRun
+	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"text/template"
@@ -151,17 +155,35 @@ func callsCmdToolCallRespond() *cobra.Command {
 			callinfo := allcalls[callIndex]
 			toolname := callinfo["name"].(string)
 			toolparams := callinfo["payload"].(map[string]any)
-			result, err := tools.RunTool(false, toolname, toolparams)
+			// Changes are reviewed hunk by hunk if asked to (or configured to
+			// be for this tool)
+			config := loadToolConfig()
+			stdin := bufio.NewReader(os.Stdin)
+			ctx := tools.WithCommandConfirmer(context.Background(), tools.NewTerminalConfirmer(stdin, os.Stdout))
+			if review, _ := cmd.Flags().GetBool("review"); review || slices.Contains(config.Review, toolname) {
+				reviewer := tools.NewTerminalReviewer(stdin, os.Stdout)
+				reviewer.Color = useColor(cmd)
+				ctx = tools.WithHunkReviewer(ctx, reviewer)
+			}
+			// Mutating tools only run once the user approves them (unless
+			// configured otherwise or their hunks are being reviewed)
+			registry := newToolRegistryFor(config)
+			approvals, err := config.ApprovalPolicy()
+			if err != nil {
+				log.Fatal(err)
+			}
+			registry.Approvals = approvals
+			ctx = tools.WithToolApprover(ctx, tools.NewTerminalApprover(stdin, os.Stdout))
+			env, err := registry.RunToolContext(ctx, false, toolname, toolparams)
 			if err != nil {
-				// TODO - Should we send the error back?
 				log.Printf("error running tool '%s': %v", toolname, err)
 				return
 			}
 
-			// We have the result so now send it back!
+			// We have the result (or the failure) so now send it back!
 
 			dryrun, _ := cmd.Flags().GetBool("dryrun")
-			value := result.(string)
+			value := env.String()
 			valueEscaped, err := json.Marshal(value)
 			if err != nil {
 				panic(err)
@@ -232,6 +254,8 @@ func callsCmdToolCallRespond() *cobra.Command {
 		},
 	}
 	out.Flags().BoolP("dryrun", "d", false, "Whether to just set the content in the field or also induce a 'submit' after the value is set")
+	out.Flags().BoolP("review", "r", false, "Review each hunk of the changes the tool makes (accepting, rejecting or editing them) before they are applied")
+	out.Flags().Bool("no-color", false, "Disable colored output when reviewing changes")
 	return out
 }
 
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/panyam/vibrant/tools"
+	"github.com/spf13/cobra"
+)
+
+var changesCmd = &cobra.Command{
+	Use:   "changes <subcommand>",
+	Short: "Commands to inspect and undo the changes made by tools",
+	Long: `Changes group of commands works with the journal of changes made by the file tools
+(write_file, edit_file, rename_file and apply_file_diff).  The prior state of every file
+they change is saved under .vibrant/journal in the project root so changes can be undone
+whether or not the project is a git repository or has uncommitted work.`,
+}
+
+var changesListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Lists the journaled changes (most recent last)",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		entries, err := openJournal().Entries()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(entries) == 0 {
+			log.Printf("No changes")
+			return
+		}
+		for _, entry := range entries {
+			switch entry.Kind {
+			case tools.JournalCheckpoint:
+				fmt.Printf("%4s  %s  --- checkpoint %s ---\n", "", entry.Time.Format("2006-01-02 15:04:05"), entry.Name)
+			case tools.JournalChange:
+				var files []string
+				for _, file := range entry.Files {
+					files = append(files, fileChangeStatus(file)+" "+file.Path)
+				}
+				line := fmt.Sprintf("%4d  %s  %-16s %s", entry.ID, entry.Time.Format("2006-01-02 15:04:05"), entry.Tool, strings.Join(files, ", "))
+				if entry.Undone {
+					line += "  (undone)"
+				}
+				fmt.Println(line)
+			}
+		}
+	},
+}
+
+// fileChangeStatus returns A, D or M for added, deleted and modified files.
+func fileChangeStatus(file *tools.FileChange) string {
+	switch {
+	case file.Before == "":
+		return "A"
+	case file.After == "":
+		return "D"
+	}
+	return "M"
+}
+
+var changesDiffCmd = &cobra.Command{
+	Use:   "diff [ID]",
+	Short: "Shows the diff of a change (the most recent one by default)",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		journal := openJournal()
+		var change *tools.JournalEntry
+		if len(args) > 0 {
+			id, err := strconv.Atoi(args[0])
+			if err != nil {
+				log.Fatalf("invalid change id: %s", args[0])
+			}
+			if change, err = journal.Get(id); err != nil {
+				log.Fatal(err)
+			}
+		} else {
+			changes, err := journal.Changes()
+			if err != nil {
+				log.Fatal(err)
+			}
+			if len(changes) == 0 {
+				log.Fatal("No changes to show")
+			}
+			change = changes[0]
+		}
+		diff, err := journal.Diff(change)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Print(diff)
+	},
+}
+
+var changesUndoCmd = &cobra.Command{
+	Use:   "undo [N]",
+	Short: "Undoes the last N changes (1 by default)",
+	Long: `Undoes the last N changes that have not been undone yet, putting every file they touched
+back into its prior state.  Undoing stops at a change to a file that has been modified
+since (by something other than the tools) unless --force is given.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		n := 1
+		if len(args) > 0 {
+			val, err := strconv.Atoi(args[0])
+			if err != nil || val < 1 {
+				log.Fatalf("invalid number of changes: %s", args[0])
+			}
+			n = val
+		}
+		force, _ := cmd.Flags().GetBool("force")
+		undone, err := openJournal().Undo(n, force)
+		printUndone(undone)
+		if err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+var changesCheckpointCmd = &cobra.Command{
+	Use:   "checkpoint NAME",
+	Short: "Marks the current state so it can be restored later",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if _, err := openJournal().Checkpoint(args[0]); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Created checkpoint %s", args[0])
+	},
+}
+
+var changesRestoreCmd = &cobra.Command{
+	Use:   "restore NAME",
+	Short: "Undoes every change made since a checkpoint",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		force, _ := cmd.Flags().GetBool("force")
+		undone, err := openJournal().Restore(args[0], force)
+		printUndone(undone)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(undone) == 0 {
+			log.Printf("No changes since checkpoint %s", args[0])
+		}
+	},
+}
+
+// openJournal opens the change journal of the configured workspace.
+func openJournal() *tools.Journal {
+	workspace, err := loadToolConfig().NewWorkspace()
+	if err != nil {
+		log.Fatalf("Error setting up workspace: %v", err)
+	}
+	return tools.NewJournal(workspace.JournalDir())
+}
+
+func printUndone(undone []*tools.JournalEntry) {
+	for _, change := range undone {
+		var paths []string
+		for _, file := range change.Files {
+			paths = append(paths, file.Path)
+		}
+		fmt.Printf("Undid change %d (%s): %s\n", change.ID, change.Tool, strings.Join(paths, ", "))
+	}
+}
+
+func init() {
+	AddCommand(changesCmd)
+	changesCmd.AddCommand(changesListCmd)
+	changesCmd.AddCommand(changesDiffCmd)
+	changesCmd.AddCommand(changesUndoCmd)
+	changesCmd.AddCommand(changesCheckpointCmd)
+	changesCmd.AddCommand(changesRestoreCmd)
+	changesUndoCmd.Flags().BoolP("force", "f", false, "Undo even if the files have been modified since")
+	changesRestoreCmd.Flags().BoolP("force", "f", false, "Restore even if the files have been modified since")
+}
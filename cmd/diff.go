@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/panyam/vibrant/tools"
+	"github.com/spf13/cobra"
+)
+
+var diffCmd = &cobra.Command{
+	Use:   "diff <subcommand>",
+	Short: "Commands to inspect how patches are applied",
+	Long:  `Diff group of commands shows how the fuzzy patch matcher (used by apply_file_diff) interprets patches`,
+}
+
+var diffExplainCmd = &cobra.Command{
+	Use:   "explain <file> [patch]",
+	Short: "Shows how a patch would be aligned against a file without changing it",
+	Long: `Shows how each hunk of a patch (in any of the formats apply_file_diff accepts) is
+aligned against a file - which lines of the patch were found, which were missing,
+how confident the matcher is and whether the hunk would be applied cleanly, fuzzily
+or as a conflict.  Nothing is written.
+
+The patch is read from the given file ('-' for stdin).  If it is omitted it is read
+from stdin (or the clipboard with --from-clipboard).
+
+The --format flag selects how the alignment is shown:
+
+	diff          - A colored unified diff annotated with how each line was matched
+	side-by-side  - The file's lines next to the patch lines they were aligned with
+	json          - The trace of alignment operations of each hunk
+	html          - A standalone HTML report (redirect it to a file)`,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		format, _ := cmd.Flags().GetString("format")
+		render, ok := tools.AlignmentRenderers[format]
+		if !ok {
+			log.Fatalf("Unknown format %q - expected one of %s", format, strings.Join(tools.AlignmentRendererNames(), ", "))
+		}
+		profile, _ := cmd.Flags().GetString("profile")
+		costs, err := tools.CostProfile(profile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		minConfidence, _ := cmd.Flags().GetFloat64("min-confidence")
+
+		contents, err := os.ReadFile(args[0])
+		if err != nil {
+			log.Fatalf("Error reading %s: %v", args[0], err)
+		}
+		var patch string
+		if len(args) > 1 && args[1] != "-" {
+			data, err := os.ReadFile(args[1])
+			if err != nil {
+				log.Fatalf("Error reading patch %s: %v", args[1], err)
+			}
+			patch = string(data)
+		} else if patch, err = tools.GetInputFromUserOrClipboard(rootFromClipboard, "Patch"); err != nil {
+			log.Fatalf("Error reading patch: %v", err)
+		}
+
+		explanation, err := tools.ExplainPatch(args[0], string(contents), patch, costs, minConfidence)
+		if err != nil {
+			log.Fatalf("Error explaining patch: %v", err)
+		}
+		opts := tools.RenderOptions{Color: useColor(cmd), Width: terminalWidth(cmd)}
+		if err := render(os.Stdout, explanation, opts); err != nil {
+			log.Fatalf("Error rendering explanation: %v", err)
+		}
+	},
+}
+
+// useColor returns true if output should be colored - unless --no-color or
+// NO_COLOR is set only when writing to a terminal.
+func useColor(cmd *cobra.Command) bool {
+	if noColor, _ := cmd.Flags().GetBool("no-color"); noColor || os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	info, err := os.Stdout.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// terminalWidth returns the --width flag or else $COLUMNS (if set).
+func terminalWidth(cmd *cobra.Command) int {
+	if width, _ := cmd.Flags().GetInt("width"); width > 0 {
+		return width
+	}
+	width, _ := strconv.Atoi(os.Getenv("COLUMNS"))
+	return width
+}
+
+func init() {
+	AddCommand(diffCmd)
+	diffCmd.AddCommand(diffExplainCmd)
+	diffExplainCmd.Flags().StringP("format", "f", "diff", fmt.Sprintf("How to show the alignment.  One of %s", strings.Join(tools.AlignmentRendererNames(), ", ")))
+	diffExplainCmd.Flags().String("profile", tools.ProfileStrict, fmt.Sprintf("Cost profile to match lines with.  One of %s", strings.Join(tools.CostProfileNames(), ", ")))
+	diffExplainCmd.Flags().Float64("min-confidence", tools.DefaultMinConfidence, "Hunks matching with a confidence below this are shown as conflicts")
+	diffExplainCmd.Flags().Int("width", 0, "Width of the side-by-side view (default $COLUMNS or 160)")
+	diffExplainCmd.Flags().Bool("no-color", false, "Disable colored output")
+}
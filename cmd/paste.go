@@ -74,6 +74,9 @@ If text data is on the clipboard, it will be used directly if it's a data URL.`,
 				finalDataURL = dataURLFlag
 			} else if rootFromClipboard || (filePathFlag == "" && dataURLFlag == "") { // Use clipboard if explicitly requested or as default
 				dataSource = "clipboard"
+				if err := clipboard.Init(); err != nil {
+					log.Fatalf("Error initializing clipboard: %v", err)
+				}
 				// Try reading image data first
 				imgBytes := clipboard.Read(clipboard.FmtImage)
 				if len(imgBytes) > 0 {
@@ -4,7 +4,10 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
 
+	"github.com/panyam/vibrant/tools"
 	"github.com/spf13/cobra"
 )
 
@@ -17,6 +20,9 @@ const DEFAULT_VIBRANT_HOST = "localhost:9999"
 var rootCurrentClientId string
 var rootVibrantHost string
 var rootFromClipboard bool
+var rootToolRoots []string
+var rootConfigPath string
+var rootToolSession string
 
 // var dslFilePath string // This was from your original root.go, kept for context
 
@@ -55,7 +61,18 @@ var rootCmd = &cobra.Command{
 }
 
 func Execute() {
-	if err := rootCmd.Execute(); err != nil {
+	// Background processes started by tools must not outlive us
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		closeToolRegistries()
+		os.Exit(1)
+	}()
+
+	err := rootCmd.Execute()
+	closeToolRegistries()
+	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
@@ -68,6 +85,9 @@ func init() {
 	rootCmd.PersistentFlags().StringVarP(&rootCurrentClientId, "client-id", "i", os.Getenv("VIBRANT_CLIENT_ID"), "ID of the client. Default from VIBRANT_CLIENT_ID env var if set.")
 	rootCmd.PersistentFlags().StringVarP(&rootVibrantHost, "host", "", os.Getenv("VIBRANT_HOST"), fmt.Sprintf("Host to connect our client to.  Default from VIBRANT_CLIENT_ID env var if set otherwise %s.", DEFAULT_VIBRANT_HOST))
 	rootCmd.PersistentFlags().BoolVarP(&rootFromClipboard, "from-clipboard", "c", false, "Read input from clipboard instead of from stdin (where applicable).")
+	rootCmd.PersistentFlags().StringArrayVar(&rootToolRoots, "root", nil, "Project root the tools operate in.  Use name=dir (repeatable) to add named roots addressed as 'name:path'.  Default from VIBRANT_ROOT env var (a path list) if set.")
+	rootCmd.PersistentFlags().StringVar(&rootConfigPath, "config", tools.DefaultConfigPath, "Path of the vibrant config file.")
+	rootCmd.PersistentFlags().StringVar(&rootToolSession, "session", os.Getenv("VIBRANT_SESSION"), "Session the tools remember which files were read in (so writes to unread files are flagged).  Default from VIBRANT_SESSION env var if set, otherwise the client id.")
 
 	// rootCmd.PersistentFlags().StringVarP(&dslFilePath, "file", "f", "", "Path to the DSL file (required by many commands)")
 }
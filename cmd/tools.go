@@ -1,31 +1,120 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
 	// "github.com/panyam/sdl/decl"
 	// "gonum.org/v1/plot" // For actual plotting
 	// "gonum.org/v1/plot/plotter"
 	// "gonum.org/v1/plot/vg"
 
+	"github.com/panyam/vibrant/mcp"
 	"github.com/panyam/vibrant/tools"
 	"github.com/spf13/cobra"
 )
 
+// newToolRegistry creates the tool registry for the workspace configured via
+// the config file, the VIBRANT_ROOT env var and the --root flags (in
+// increasing order of precedence).
+func newToolRegistry() *tools.Registry {
+	return newToolRegistryFor(loadToolConfig())
+}
+
+// loadToolConfig loads the config file with the VIBRANT_ROOT env var and the
+// --root flags applied.
+func loadToolConfig() *tools.Config {
+	config, err := tools.LoadConfig(rootConfigPath)
+	if err != nil {
+		log.Fatalf("Error loading config: %v", err)
+	}
+	if len(rootToolRoots) > 0 {
+		config.SetRoots(rootToolRoots)
+	} else if envRoot := os.Getenv("VIBRANT_ROOT"); envRoot != "" {
+		config.SetRoots(filepath.SplitList(envRoot))
+	}
+	return config
+}
+
+// newToolRegistryFor creates the tool registry for a loaded config.
+func newToolRegistryFor(config *tools.Config) *tools.Registry {
+	workspace, err := config.NewWorkspace()
+	if err != nil {
+		log.Fatalf("Error setting up workspace: %v", err)
+	}
+	registry := tools.NewRegistry(workspace)
+	registry.Files.Session = toolSession()
+	toolRegistriesMu.Lock()
+	defer toolRegistriesMu.Unlock()
+	toolRegistries = append(toolRegistries, registry)
+	return registry
+}
+
+// toolSession returns the session the tools record the files read in (see
+// tools.FileTracker) - from --session, VIBRANT_SESSION or the client id.
+func toolSession() string {
+	if rootToolSession != "" {
+		return rootToolSession
+	}
+	return rootCurrentClientId
+}
+
+// Registries created by this run so the background processes started by
+// their tools can be stopped on exit
+var (
+	toolRegistriesMu sync.Mutex
+	toolRegistries   []*tools.Registry
+)
+
+// closeToolRegistries stops the background processes started by any tools.
+func closeToolRegistries() {
+	toolRegistriesMu.Lock()
+	defer toolRegistriesMu.Unlock()
+	for _, registry := range toolRegistries {
+		registry.Close()
+	}
+	toolRegistries = nil
+}
+
 var toolsCmd = &cobra.Command{
 	Use:   "tools <subcommand>",
 	Short: "Command group to work with and try out tools",
 	Long:  `Tools group of commands lets you list tools, try out tools, query them and even configure and inspect them`,
 	Run: func(cmd *cobra.Command, args []string) {
-		tools.PrintTools()
+		newToolRegistry().PrintTools()
 	},
 }
 
 var toolsJsonCmd = &cobra.Command{
 	Use:   "json",
 	Short: "Prints out all the tools in json format to be uploaded into the AI UX",
-	Long:  `Prints out all the tools in json format to be uploaded into the AI UX`,
+	Long: `Prints out all the tools in json format to be uploaded into the AI UX.
+
+The --format flag selects the platform the definitions are meant for:
+
+	openai     - The "tools" array of a Chat Completions/Responses request
+	anthropic  - The "tools" array of a Messages request
+	gemini     - The "tools" array of a generateContent request (and AI Studio)
+	mcp        - The result of an MCP "tools/list" call
+
+The background process tools (start_process etc) are left out as the processes they
+start would be killed when the one shot call running them returned - they are only
+served by 'vibrant tools mcp'.`,
 	// Args:  cobra.ExactArgs(3), // metric_type, system_name, analysis_name
 	Run: func(cmd *cobra.Command, args []string) {
-		tools.ToolsJson()
+		format, _ := cmd.Flags().GetString("format")
+		if err := newToolRegistry().ToolsJson(format); err != nil {
+			log.Fatal(err)
+		}
 	},
 }
 
@@ -37,7 +126,63 @@ var runToolCmd = &cobra.Command{
 	Args: cobra.MinimumNArgs(1), // metric_type, system_name, analysis_name
 	Run: func(cmd *cobra.Command, args []string) {
 		fromClipboard, _ := cmd.Flags().GetBool("from-clipboard")
-		tools.RunTool(fromClipboard, args[0], nil)
+		newToolRegistry().RunTool(fromClipboard, args[0], nil)
+	},
+}
+
+var toolsMcpCmd = &cobra.Command{
+	Use:   "mcp",
+	Short: "Serves the tools over the Model Context Protocol",
+	Long: `Serves the tools (with the same workspace and sandbox as 'tools run' and 'calls respond')
+to MCP clients.  By default the server speaks over stdio.  Use --http to serve the
+Streamable HTTP transport instead, eg --http localhost:8765 serves at http://localhost:8765/mcp
+
+HTTP clients must send the --token (or $VIBRANT_MCP_TOKEN) as a bearer token when one is
+set.  Only loopback addresses are served unless --allow-remote is passed (which needs a
+token).  The configured approvals apply but there is no terminal to ask on so tools
+needing confirmation are denied.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		// A long running session so processes can be started in the background
+		config := loadToolConfig()
+		registry := newToolRegistryFor(config)
+		registry.AddProcessTools()
+		// Without an approver calls needing confirmation are denied
+		approvals, err := config.ApprovalPolicy()
+		if err != nil {
+			log.Fatal(err)
+		}
+		registry.Approvals = approvals
+		if registry.Files.Session == "" {
+			// Each server is a session of its own
+			registry.Files.Session = fmt.Sprintf("mcp-%d-%d", os.Getpid(), time.Now().UnixNano())
+		}
+		server := mcp.NewServer(registry)
+		addr, _ := cmd.Flags().GetString("http")
+		if addr == "" {
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			if err := server.ServeStdio(ctx, os.Stdin, os.Stdout); err != nil {
+				log.Fatalf("Error serving MCP over stdio: %v", err)
+			}
+			return
+		}
+
+		server.Token, _ = cmd.Flags().GetString("token")
+		if allowRemote, _ := cmd.Flags().GetBool("allow-remote"); !mcp.IsLoopbackAddr(addr) {
+			if !allowRemote {
+				log.Fatalf("%s is not a loopback address - pass --allow-remote (with a --token) to serve other machines", addr)
+			}
+			if server.Token == "" {
+				log.Fatalf("A --token is needed to serve %s to other machines", addr)
+			}
+		}
+		path, _ := cmd.Flags().GetString("path")
+		mux := http.NewServeMux()
+		mux.Handle(path, server)
+		log.Printf("Serving MCP on http://%s%s", addr, path)
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Fatalf("Failed to start MCP server on %s: %v", addr, err)
+		}
 	},
 }
 
@@ -48,7 +193,13 @@ func init() {
 	// Else, add directly:
 	AddCommand(toolsCmd)
 	toolsCmd.AddCommand(toolsJsonCmd)
+	toolsJsonCmd.Flags().StringP("format", "f", tools.FormatGemini, fmt.Sprintf("Format to print tools in.  One of %s", strings.Join(tools.ExportFormats, ", ")))
 	toolsCmd.AddCommand(runToolCmd)
+	toolsCmd.AddCommand(toolsMcpCmd)
+	toolsMcpCmd.Flags().String("http", "", "Address (eg localhost:8765) to serve the Streamable HTTP transport on instead of stdio")
+	toolsMcpCmd.Flags().String("path", "/mcp", "Path of the MCP endpoint when serving over HTTP")
+	toolsMcpCmd.Flags().String("token", os.Getenv("VIBRANT_MCP_TOKEN"), "Bearer token HTTP clients must send (defaults to $VIBRANT_MCP_TOKEN)")
+	toolsMcpCmd.Flags().Bool("allow-remote", false, "Serve HTTP on addresses reachable from other machines (requires --token)")
 	runToolCmd.Flags().BoolP("from-clipboard", "c", false, "Read input from clipboard instead of from stdin")
 
 	/*
@@ -0,0 +1,101 @@
+package mcp
+
+import (
+	"crypto/subtle"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Largest request body accepted over HTTP
+const maxHttpMessageSize = 64 * 1024 * 1024
+
+// ServeHTTP implements the Streamable HTTP transport.  Clients POST JSON-RPC
+// messages (or batches) and receive plain JSON responses - we never stream or
+// send server initiated messages so GET is not supported.  Notifications (eg
+// cancellations) are acknowledged with a 202.
+//
+// Requests must carry the server's Token as a bearer token.  Without a Token
+// only requests addressed to a loopback host are served (see IsLoopbackAddr).
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Guard against DNS rebinding - only accept browser requests from localhost
+	if origin := r.Header.Get("Origin"); origin != "" && !isLocalOrigin(origin) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+	if s.Token != "" {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.Token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="vibrant"`)
+			http.Error(w, "missing or invalid bearer token", http.StatusUnauthorized)
+			return
+		}
+	} else if !isLoopbackHost(hostOnly(r.Host)) {
+		// Clients without an Origin (ie not browsers) could still have been
+		// pointed at us by a rebound name
+		http.Error(w, "host not allowed", http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		// handled below
+	case http.MethodDelete:
+		// We are stateless so there is no session to terminate
+		w.WriteHeader(http.StatusOK)
+		return
+	default:
+		w.Header().Set("Allow", "POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxHttpMessageSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// The request context is cancelled if the client goes away which in turn
+	// cancels any running tool call
+	resp := s.HandleBytes(r.Context(), body)
+	if resp == nil {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(resp)
+}
+
+// IsLoopbackAddr returns true if a listen address (eg localhost:8765) only
+// accepts connections from this machine.  Addresses without a host (eg :8765)
+// listen on every interface.
+func IsLoopbackAddr(addr string) bool {
+	return isLoopbackHost(hostOnly(addr))
+}
+
+func isLocalOrigin(origin string) bool {
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return isLoopbackHost(u.Hostname())
+}
+
+func isLoopbackHost(host string) bool {
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// hostOnly strips the port (if any) from a host[:port].
+func hostOnly(hostport string) string {
+	if host, _, err := net.SplitHostPort(hostport); err == nil {
+		return host
+	}
+	return strings.Trim(hostport, "[]")
+}
@@ -0,0 +1,263 @@
+// Package mcp exposes a tools.Registry as a Model Context Protocol server so
+// MCP capable clients can drive the same tools (and sandbox) the browser flow
+// uses.  Only the tools capability is implemented.
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/panyam/vibrant/tools"
+)
+
+// Protocol revisions we understand, newest first.
+var SupportedProtocolVersions = []string{"2025-06-18", "2025-03-26", "2024-11-05"}
+
+// Standard JSON-RPC error codes
+const (
+	ErrCodeParseError     = -32700
+	ErrCodeInvalidRequest = -32600
+	ErrCodeMethodNotFound = -32601
+	ErrCodeInvalidParams  = -32602
+	ErrCodeInternalError  = -32603
+)
+
+// A Message is a JSON-RPC 2.0 request, notification or response.
+type Message struct {
+	JsonRpc string          `json:"jsonrpc"`
+	Id      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+// IsNotification returns true for messages that do not expect a response.
+func (m *Message) IsNotification() bool {
+	return len(m.Id) == 0 && m.Method != ""
+}
+
+// An Error is a JSON-RPC error object.
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    any    `json:"data,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// A Server dispatches MCP messages to a tool registry.  It is transport
+// agnostic - see ServeStdio and ServeHTTP.
+type Server struct {
+	Registry *tools.Registry
+	Name     string
+	Version  string
+
+	// Bearer token HTTP clients must send (see ServeHTTP)
+	Token string
+
+	mu       sync.Mutex
+	inflight map[string]context.CancelFunc
+}
+
+func NewServer(registry *tools.Registry) *Server {
+	return &Server{
+		Registry: registry,
+		Name:     "vibrant",
+		Version:  "0.1.0",
+		inflight: map[string]context.CancelFunc{},
+	}
+}
+
+// HandleMessage processes a single incoming message and returns the response
+// to send back or nil if no response is due (notifications and cancelled
+// requests).
+func (s *Server) HandleMessage(ctx context.Context, msg *Message) *Message {
+	if msg.JsonRpc != "2.0" || msg.Method == "" {
+		if msg.Method == "" && (msg.Result != nil || msg.Error != nil) {
+			// A response to a server initiated request - we never make any
+			return nil
+		}
+		return errorResponse(msg.Id, ErrCodeInvalidRequest, "invalid JSON-RPC request", nil)
+	}
+
+	if msg.IsNotification() {
+		s.handleNotification(msg)
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	key := string(msg.Id)
+	s.mu.Lock()
+	s.inflight[key] = cancel
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.inflight, key)
+		s.mu.Unlock()
+		cancel()
+	}()
+
+	result, err := s.dispatch(ctx, msg)
+	if ctx.Err() != nil {
+		// Cancelled requests get no response
+		return nil
+	}
+	if err != nil {
+		var rpcErr *Error
+		if !errors.As(err, &rpcErr) {
+			rpcErr = &Error{Code: ErrCodeInternalError, Message: err.Error()}
+		}
+		return &Message{JsonRpc: "2.0", Id: msg.Id, Error: rpcErr}
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		return errorResponse(msg.Id, ErrCodeInternalError, err.Error(), nil)
+	}
+	return &Message{JsonRpc: "2.0", Id: msg.Id, Result: data}
+}
+
+// HandleBytes decodes a single message or a batch and returns the encoded
+// response (or nil if there is nothing to send back).
+func (s *Server) HandleBytes(ctx context.Context, data []byte) []byte {
+	var batch []*Message
+	isBatch := len(data) > 0 && data[0] == '['
+	if isBatch {
+		if err := json.Unmarshal(data, &batch); err != nil {
+			return encode(errorResponse(nil, ErrCodeParseError, err.Error(), nil))
+		}
+	} else {
+		var msg Message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return encode(errorResponse(nil, ErrCodeParseError, err.Error(), nil))
+		}
+		batch = []*Message{&msg}
+	}
+
+	// Requests in a batch run concurrently so a cancellation in the same
+	// batch (or a slow tool) does not hold up the rest
+	responses := make([]*Message, len(batch))
+	var wg sync.WaitGroup
+	for i, msg := range batch {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			responses[i] = s.HandleMessage(ctx, msg)
+		}()
+	}
+	wg.Wait()
+
+	responses = slices.DeleteFunc(responses, func(m *Message) bool { return m == nil })
+	if len(responses) == 0 {
+		return nil
+	}
+	if !isBatch {
+		return encode(responses[0])
+	}
+	return encode(responses)
+}
+
+func (s *Server) handleNotification(msg *Message) {
+	switch msg.Method {
+	case "notifications/cancelled":
+		var params struct {
+			RequestId json.RawMessage `json:"requestId"`
+			Reason    string          `json:"reason"`
+		}
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return
+		}
+		s.mu.Lock()
+		cancel := s.inflight[string(params.RequestId)]
+		s.mu.Unlock()
+		if cancel != nil {
+			log.Printf("Cancelling request %s: %s", params.RequestId, params.Reason)
+			cancel()
+		}
+	case "notifications/initialized":
+		// Nothing to do
+	}
+}
+
+func (s *Server) dispatch(ctx context.Context, msg *Message) (any, error) {
+	switch msg.Method {
+	case "initialize":
+		var params struct {
+			ProtocolVersion string `json:"protocolVersion"`
+		}
+		json.Unmarshal(msg.Params, &params)
+		version := SupportedProtocolVersions[0]
+		if slices.Contains(SupportedProtocolVersions, params.ProtocolVersion) {
+			version = params.ProtocolVersion
+		}
+		return map[string]any{
+			"protocolVersion": version,
+			"capabilities": map[string]any{
+				"tools": map[string]any{"listChanged": false},
+			},
+			"serverInfo": map[string]any{"name": s.Name, "version": s.Version},
+		}, nil
+	case "ping":
+		return map[string]any{}, nil
+	case "tools/list":
+		return tools.ExportTools(s.Registry.Tools(), tools.FormatMCP)
+	case "tools/call":
+		return s.callTool(ctx, msg.Params)
+	}
+	return nil, &Error{Code: ErrCodeMethodNotFound, Message: "method not found: " + msg.Method}
+}
+
+// callTool runs a tool.  Protocol level problems (unknown tool, malformed
+// params) are JSON-RPC errors while failures of the tool itself (including
+// invalid arguments) are reported as results with isError set so the model
+// gets to see them and correct itself.
+func (s *Server) callTool(ctx context.Context, rawParams json.RawMessage) (any, error) {
+	var params struct {
+		Name      string         `json:"name"`
+		Arguments map[string]any `json:"arguments"`
+	}
+	if err := json.Unmarshal(rawParams, &params); err != nil {
+		return nil, &Error{Code: ErrCodeInvalidParams, Message: err.Error()}
+	}
+	if _, ok := s.Registry.Get(params.Name); !ok {
+		return nil, &Error{Code: ErrCodeInvalidParams, Message: "unknown tool: " + params.Name}
+	}
+
+	env := s.Registry.Invoke(ctx, params.Name, params.Arguments)
+	var text string
+	if env.Ok {
+		text = tools.ResultString(env.Result)
+	} else {
+		text = env.Error.Message
+	}
+	content := []any{map[string]any{"type": "text", "text": text}}
+	if len(env.Warnings) > 0 {
+		content = append(content, map[string]any{"type": "text", "text": "Warnings:\n" + strings.Join(env.Warnings, "\n")})
+	}
+	return map[string]any{
+		"content": content,
+		"isError": !env.Ok,
+	}, nil
+}
+
+func errorResponse(id json.RawMessage, code int, message string, data any) *Message {
+	if len(id) == 0 {
+		id = json.RawMessage("null")
+	}
+	return &Message{JsonRpc: "2.0", Id: id, Error: &Error{Code: code, Message: message, Data: data}}
+}
+
+func encode(v any) []byte {
+	out, err := json.Marshal(v)
+	if err != nil {
+		out, _ = json.Marshal(errorResponse(nil, ErrCodeInternalError, err.Error(), nil))
+	}
+	return out
+}
@@ -0,0 +1,215 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/panyam/vibrant/tools"
+)
+
+// A tool that blocks until it is cancelled
+type blockingTool struct{ started chan bool }
+
+func (b *blockingTool) Name() string                         { return "block" }
+func (b *blockingTool) Description() string                  { return "Blocks until cancelled" }
+func (b *blockingTool) Parameters() []*tools.Parameter       { return nil }
+func (b *blockingTool) Returns() []*tools.Parameter          { return nil }
+func (b *blockingTool) Run(args map[string]any) (any, error) { return nil, nil }
+func (b *blockingTool) RunContext(ctx context.Context, args map[string]any) (any, error) {
+	b.started <- true
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func newTestServer(t *testing.T) (*Server, *blockingTool) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "hello.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	registry := tools.NewRegistry(tools.NewWorkspace(root))
+	blocker := &blockingTool{started: make(chan bool, 1)}
+	registry.Register(blocker)
+	return NewServer(registry), blocker
+}
+
+func call(t *testing.T, s *Server, request string) map[string]any {
+	t.Helper()
+	var out map[string]any
+	resp := s.HandleBytes(context.Background(), []byte(request))
+	if resp == nil {
+		return nil
+	}
+	if err := json.Unmarshal(resp, &out); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestServer_Protocol(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	resp := call(t, s, `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-03-26"}}`)
+	if resp["result"].(map[string]any)["protocolVersion"] != "2025-03-26" {
+		t.Errorf("expected the client's protocol version to be accepted, got %v", resp)
+	}
+	if resp := call(t, s, `{"jsonrpc":"2.0","method":"notifications/initialized"}`); resp != nil {
+		t.Errorf("expected no response to a notification, got %v", resp)
+	}
+
+	resp = call(t, s, `{"jsonrpc":"2.0","id":2,"method":"tools/list"}`)
+	if listed := resp["result"].(map[string]any)["tools"].([]any); len(listed) != len(s.Registry.Tools()) {
+		t.Errorf("expected %d tools, got %d", len(s.Registry.Tools()), len(listed))
+	}
+
+	resp = call(t, s, `{"jsonrpc":"2.0","id":3,"method":"tools/call","params":{"name":"read_file","arguments":{"path":"hello.txt"}}}`)
+	result := resp["result"].(map[string]any)
+	if result["isError"] != false || !strings.Contains(result["content"].([]any)[0].(map[string]any)["text"].(string), "hello") {
+		t.Errorf("unexpected read_file result: %v", result)
+	}
+
+	resp = call(t, s, `{"jsonrpc":"2.0","id":4,"method":"tools/call","params":{"name":"read_file","arguments":{"path":"../../etc/passwd"}}}`)
+	if resp["result"].(map[string]any)["isError"] != true {
+		t.Errorf("expected sandbox violations to be tool errors, got %v", resp)
+	}
+
+	resp = call(t, s, `{"jsonrpc":"2.0","id":5,"method":"tools/call","params":{"name":"nope"}}`)
+	if resp["error"].(map[string]any)["code"] != float64(ErrCodeInvalidParams) {
+		t.Errorf("expected an invalid params error for unknown tools, got %v", resp)
+	}
+
+	// Nobody is there to confirm calls so they are denied
+	s.Registry.Approvals = &tools.ApprovalPolicy{}
+	resp = call(t, s, `{"jsonrpc":"2.0","id":5,"method":"tools/call","params":{"name":"write_file","arguments":{"path":"new.txt","contents":"hi","encoding":"plain"}}}`)
+	if result := resp["result"].(map[string]any); result["isError"] != true || !strings.Contains(result["content"].([]any)[0].(map[string]any)["text"].(string), "no one is available to approve it") {
+		t.Errorf("expected calls needing confirmation to be denied, got %v", resp)
+	}
+	if _, err := os.Stat(filepath.Join(s.Registry.Workspace.Default.Root, "new.txt")); err == nil {
+		t.Errorf("expected the denied write not to happen")
+	}
+
+	resp = call(t, s, `{"jsonrpc":"2.0","id":6,"method":"resources/list"}`)
+	if resp["error"].(map[string]any)["code"] != float64(ErrCodeMethodNotFound) {
+		t.Errorf("expected method not found, got %v", resp)
+	}
+
+	resp = call(t, s, `{not json`)
+	if resp["error"].(map[string]any)["code"] != float64(ErrCodeParseError) {
+		t.Errorf("expected a parse error, got %v", resp)
+	}
+}
+
+func TestServer_Cancellation(t *testing.T) {
+	s, blocker := newTestServer(t)
+
+	done := make(chan []byte)
+	go func() {
+		done <- s.HandleBytes(context.Background(), []byte(`{"jsonrpc":"2.0","id":"slow","method":"tools/call","params":{"name":"block"}}`))
+	}()
+	<-blocker.started
+	call(t, s, `{"jsonrpc":"2.0","method":"notifications/cancelled","params":{"requestId":"slow","reason":"test"}}`)
+
+	select {
+	case resp := <-done:
+		if resp != nil {
+			t.Errorf("expected no response for a cancelled request, got %s", resp)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("tool call was not cancelled")
+	}
+}
+
+func TestServer_HTTP(t *testing.T) {
+	s, _ := newTestServer(t)
+	httpServer := httptest.NewServer(s)
+	defer httpServer.Close()
+
+	resp, err := http.Post(httpServer.URL, "application/json", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"ping"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("unexpected response to ping: %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	resp, err = http.Post(httpServer.URL, "application/json", strings.NewReader(`{"jsonrpc":"2.0","method":"notifications/initialized"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Errorf("expected 202 for notifications, got %d", resp.StatusCode)
+	}
+
+	req, _ := http.NewRequest(http.MethodPost, httpServer.URL, strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"ping"}`))
+	req.Header.Set("Origin", "https://evil.example.com")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("expected non local origins to be rejected, got %d", resp.StatusCode)
+	}
+
+	// Requests addressed to other hosts (eg via DNS rebinding) are refused
+	req, _ = http.NewRequest(http.MethodPost, httpServer.URL, strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"ping"}`))
+	req.Host = "rebound.example.com"
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("expected non local hosts to be rejected, got %d", resp.StatusCode)
+	}
+
+	// With a token every request needs it (whatever the host)
+	s.Token = "secret"
+	post := func(token, host string) int {
+		req, _ := http.NewRequest(http.MethodPost, httpServer.URL, strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"ping"}`))
+		req.Host = host
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := post("", "127.0.0.1"); code != http.StatusUnauthorized {
+		t.Errorf("expected requests without the token to be rejected, got %d", code)
+	}
+	if code := post("wrong", "127.0.0.1"); code != http.StatusUnauthorized {
+		t.Errorf("expected requests with the wrong token to be rejected, got %d", code)
+	}
+	if code := post("secret", "vibrant.example.com"); code != http.StatusOK {
+		t.Errorf("expected requests with the token to be served, got %d", code)
+	}
+}
+
+func TestIsLoopbackAddr(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"localhost:8765":    true,
+		"127.0.0.1:8765":    true,
+		"[::1]:8765":        true,
+		"app.localhost:80":  true,
+		":8765":             false,
+		"0.0.0.0:8765":      false,
+		"192.168.1.10:8765": false,
+		"example.com:8765":  false,
+	} {
+		if got := IsLoopbackAddr(addr); got != want {
+			t.Errorf("IsLoopbackAddr(%q) = %t, expected %t", addr, got, want)
+		}
+	}
+}
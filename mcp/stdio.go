@@ -0,0 +1,44 @@
+package mcp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"sync"
+)
+
+// Largest single message accepted on stdio (tool calls can carry whole files)
+const maxStdioMessageSize = 64 * 1024 * 1024
+
+// ServeStdio serves newline delimited JSON-RPC messages read from in and
+// writes responses to out until in is closed or ctx is done.  Messages are
+// handled concurrently so a long running tool call can be cancelled.
+func (s *Server) ServeStdio(ctx context.Context, in io.Reader, out io.Writer) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var writeMu sync.Mutex
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxStdioMessageSize)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		msg := append([]byte(nil), line...)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if resp := s.HandleBytes(ctx, msg); resp != nil {
+				writeMu.Lock()
+				defer writeMu.Unlock()
+				out.Write(append(resp, '\n'))
+			}
+		}()
+	}
+	return scanner.Err()
+}
@@ -1,12 +1,12 @@
 package tools
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"io"
-	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"slices"
 	"strings"
 )
 
@@ -14,26 +14,145 @@ type ApplyFileDiff struct {
 	BaseFileTool
 }
 
+// FilePatchResult reports how the patch for one file was applied.
+type FilePatchResult struct {
+	Path string `json:"path"`
+
+	// Previous path of a renamed file
+	OldPath string `json:"old_path,omitempty"`
+
+	// One of "modified", "created", "deleted", "renamed" or "unchanged" (when
+	// every hunk was rejected by the reviewer)
+	Action string        `json:"action"`
+	Hunks  []*HunkResult `json:"hunks"`
+
+	// Hash of the file after the patch (unless it was deleted)
+	Hash  string `json:"hash,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// Failed returns true if the file (or any of its hunks) could not be patched.
+func (f *FilePatchResult) Failed() bool {
+	if f.Error != "" {
+		return true
+	}
+	for _, hunk := range f.Hunks {
+		// Failed hunks a reviewer has seen were decided upon
+		if hunk.Status == HunkFailed && hunk.Decision == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// ApplyDiffResult is the result of an apply_file_diff call.
+type ApplyDiffResult struct {
+	// Whether the changes were written.  Diffs are applied all or nothing.
+	Applied bool `json:"applied"`
+
+	// Dialect the patch was written in (see DetectPatchFormat)
+	Format string             `json:"format"`
+	Files  []*FilePatchResult `json:"files"`
+}
+
+// ErrPatchFailed is returned (wrapped in a *PatchError) when any file or
+// hunk of a diff cannot be applied.
+var ErrPatchFailed = errors.New("patch could not be applied")
+
+// A PatchError carries the per file and per hunk report of a diff that
+// could not be applied.
+type PatchError struct {
+	Result *ApplyDiffResult
+}
+
+func (e *PatchError) Error() string {
+	var problems []string
+	for _, file := range e.Result.Files {
+		if file.Error != "" {
+			problems = append(problems, fmt.Sprintf("%s: %s", file.Path, file.Error))
+		}
+		for i, hunk := range file.Hunks {
+			if hunk.Status == HunkFailed && hunk.Decision == "" {
+				problems = append(problems, fmt.Sprintf("%s: hunk %d (%s): %s", file.Path, i, hunk.Header, hunk.Message))
+			}
+		}
+	}
+	return fmt.Sprintf("%v - no files were changed: %s", ErrPatchFailed, strings.Join(problems, "; "))
+}
+
+func (e *PatchError) Unwrap() error {
+	return ErrPatchFailed
+}
+
+// ReviewsHunks returns true as every hunk is shown to the HunkReviewer (if
+// any) before it is applied.
+func (r *ApplyFileDiff) ReviewsHunks() bool {
+	return true
+}
+
 func (r *ApplyFileDiff) Name() string {
 	return "apply_file_diff"
 }
 
 func (r *ApplyFileDiff) Description() string {
-	return `Applies a file diff onto an existing file and returns a success or a failure along with the contents of the file being updated incase the diff being applied is based on an older version of the file.  For this patch to be effective it would be ideal to have atleast 5 lines of context before and after each hunk of change if possible`
+	return `
+	Applies a unified diff (as produced by 'diff -u' or 'git diff') to one or more files and returns how each hunk was applied.
+
+	The diff can span multiple files (each with its own ---/+++ headers) and create ('--- /dev/null') or delete ('+++ /dev/null') files.
+	Other patch formats are detected and accepted too:
+	  - SEARCH/REPLACE blocks ('<<<<<<< SEARCH', old lines, '=======', new lines, '>>>>>>> REPLACE') each preceded by the path of the file,
+	    where an empty SEARCH section creates a new file.
+	  - '*** Begin Patch' envelopes with '*** Update File: <path>', '*** Add File: <path>' and '*** Delete File: <path>' sections.
+	  - Hunks without '@@ -a,b +c,d @@' line numbers.
+	Hunk line numbers are only used as hints - each hunk is located by its context and deleted lines, so diffs based on a slightly older version of a
+	file still apply.   For this to be effective it would be ideal to have atleast 3 lines of context before and after each hunk of change.
+
+	Each hunk is reported as 'clean', 'fuzzy' (applied though parts of it only matched approximately) or 'conflicted'.   Hunks that match the file with
+	a confidence below 'min_confidence' are not guessed at - the lines they cover are replaced with git style conflict markers
+	('<<<<<<< current', the file's lines, '=======', the hunk's new lines, '>>>>>>> patch') which must then be resolved by editing the file.
+
+	If any hunk cannot be found in its file at all no file is changed and the report of every hunk is returned in the error.   Likewise if writing
+	any of the files fails the files written before it are put back.
+
+	The user may review each hunk before it is applied.  Their decision ('accepted', 'rejected' with an optional reason, 'edited' along
+	with the hunk as they edited it, or 'skipped') is then reported with each hunk and only accepted and edited hunks are written.
+	`
 }
 
 func (r *ApplyFileDiff) Parameters() []*Parameter {
 	return []*Parameter{
+		{
+			Name:        "diff",
+			Description: "Unified diff/patch to apply.  If the diff is invalid (for example it is based on an older version of the file) then an error will be thrown",
+			Type:        "string",
+			Required:    true,
+		},
 		{
 			Name:        "path",
-			Description: "Path of the file to create or overwrite.",
+			Description: "Path of the file to patch - only needed if the diff has no ---/+++ file headers",
 			Type:        "string",
 		},
 		{
-			Name:        "diff",
-			Description: "Unix still diff/patch to apply to a file.  If the diff is invalid (for example it is based on an older version of the file) then an error will be thrown",
+			Name:        "expected_hash",
+			Description: "Hash of the file (from read_file) the diff is based on (only for single file diffs).  If the file has changed since, the diff is not applied and the current contents are returned instead.",
 			Type:        "string",
 		},
+		{
+			Name: "profile",
+			Description: "How closely the diff's context and deleted lines must match the file: 'strict' (exactly), 'indent-tolerant' (ignoring differences in " +
+				"indentation and spacing) or 'lenient' (also allowing small differences in the text of a line)",
+			Type:    "string",
+			Enum:    []any{ProfileStrict, ProfileIndentTolerant, ProfileLenient},
+			Default: ProfileStrict,
+		},
+		{
+			Name:        "min_confidence",
+			Description: "Hunks matching the file with a confidence (0-1) below this are written as conflict markers instead of being applied",
+			Type:        "number",
+			Minimum:     Ptr(0.0),
+			Maximum:     Ptr(1.0),
+			Default:     DefaultMinConfidence,
+		},
 	}
 }
 
@@ -41,152 +160,314 @@ func (r *ApplyFileDiff) Returns() []*Parameter {
 	return []*Parameter{
 		{
 			Name:        "result",
-			Description: "Error or Number of bytes written",
+			Description: "Error or the files changed along with how each hunk was applied",
 			Type:        "object",
 		},
 	}
 }
 
+// A pending change to a file computed before anything is written
+type patchedFile struct {
+	result   *FilePatchResult
+	fullpath string
+	oldpath  string // full path of a renamed file
+	content  []byte
+	delete   bool
+}
+
 func (r *ApplyFileDiff) Run(args map[string]any) (any, error) {
-	path := args["path"].(string)
-	diff := args["diff"].(string)
-	fullpath, err := r.ResolvePath(path)
+	return r.RunContext(context.Background(), args)
+}
+
+func (r *ApplyFileDiff) RunContext(ctx context.Context, args map[string]any) (any, error) {
+	result, pending, err := r.plan(ctx, args)
 	if err != nil {
 		return nil, err
 	}
 
-	// TODO - replace with stat
-
-	return r.RunWithPatch(fullpath, diff)
+	tx := r.Journal.Begin(r.Name())
+	for _, change := range pending {
+		if change.result.Action == "unchanged" {
+			continue
+		}
+		if change.oldpath != "" {
+			trackChange(ctx, tx, change.result.OldPath, change.oldpath)
+		}
+		trackChange(ctx, tx, change.result.Path, change.fullpath)
+	}
+	// Files that could not be put back after a failure are journaled too
+	defer commitChange(ctx, tx)
+	for _, change := range pending {
+		if change.result.Action == "unchanged" {
+			continue
+		}
+		if err := r.writeChange(change); err != nil {
+			// Diffs are applied all or nothing so put back the files written
+			// so far (and anything left of this one)
+			err = fmt.Errorf("%s: %w", change.result.Path, err)
+			restored, rerr := tx.Rollback()
+			for _, file := range restored {
+				if file.Before == "" {
+					r.Files.Forget(file.FullPath)
+				} else {
+					r.Files.Record(file.FullPath, file.Before)
+				}
+			}
+			if rerr != nil {
+				return nil, fmt.Errorf("%w - and the files written before it could not all be put back: %v", err, rerr)
+			}
+			return nil, err
+		}
+	}
+	result.Applied = true
+	for _, file := range result.Files {
+		for i, hunk := range file.Hunks {
+			switch hunk.Decision {
+			case DecisionReject:
+				if hunk.Reason != "" {
+					Warn(ctx, "%s: hunk %d (%s) was rejected by the user: %s", file.Path, i, hunk.Header, hunk.Reason)
+				} else {
+					Warn(ctx, "%s: hunk %d (%s) was rejected by the user", file.Path, i, hunk.Header)
+				}
+				continue
+			case DecisionSkip:
+				continue
+			}
+			switch hunk.Status {
+			case HunkFuzzy:
+				Warn(ctx, "%s: hunk %d (%s) applied approximately: %s", file.Path, i, hunk.Header, hunk.Message)
+			case HunkConflicted:
+				Warn(ctx, "%s: hunk %d (%s) only matched with %.0f%% confidence: %s - resolve the conflict by editing the file",
+					file.Path, i, hunk.Header, hunk.Confidence*100, hunk.Message)
+			}
+		}
+	}
+	return result, nil
 }
 
-func saveInputAndPatch(fullpath, diff string) (tempdir, tempInFile, tempPatchFile string, inputLines []string, diffLines []string, err error) {
-	currcontents, err := os.ReadFile(fullpath)
+// plan parses the diff and works out the changes to every file it targets
+// without writing anything.  A *PatchError is returned if any file cannot be
+// patched.
+func (r *ApplyFileDiff) plan(ctx context.Context, args map[string]any) (*ApplyDiffResult, []*patchedFile, error) {
+	diff := args["diff"].(string)
+	path, _ := args["path"].(string)
+	expectedHash, _ := args["expected_hash"].(string)
+	profile, _ := args["profile"].(string)
+	costs, err := CostProfile(profile)
 	if err != nil {
-		log.Println("Error reading file: ", err)
-		return
+		return nil, nil, err
+	}
+	minConfidence := DefaultMinConfidence
+	if val, ok := args["min_confidence"].(float64); ok {
+		minConfidence = val
 	}
 
-	tempdir, err = os.MkdirTemp("", "sampledir")
-	if err != nil {
-		panic(err)
-	}
-	tempdir = "/tmp"
-	tempInFile = filepath.Join(tempdir, "inputfile")
-	tempPatchFile = filepath.Join(tempdir, "patchfile")
-
-	log.Println("Creating Temporary Directory: ", tempdir)
-	log.Println("Temporary Input File Path: ", tempInFile)
-	log.Println("Temporary Patch File Path: ", tempPatchFile)
-
-	if err = os.WriteFile(tempInFile, currcontents, 0666); err != nil {
-		panic(err)
-	}
-	if err = os.WriteFile(tempPatchFile, []byte(diff), 0666); err != nil {
-		panic(err)
-	}
-
-	inputLines = strings.Split(string(currcontents), "\n")
-	diffLines = strings.Split(diff, "\n")
-	return
-}
-
-// The output generated by an LLM for a unix patch is very flaky.   It fails more often then not.
-// Also it is silly to blindly apply a patch.   Perhaps better to show the side by side diff and
-// have the user apply it.
-// The patch out is flaky in the following areas:
-//   - line number offsets are wrong
-//   - usually based on an "older" version of a file - ie it may not always call the read_file or even
-//     ignore your version of the file
-//
-// So the idea is there may still be enough markers in the diff to identify where a diff begins so we can
-// iterate through the original file along with the diff contents and see waht is already "common".  We may
-// not even need a full edit distance algo for this.
-func (r *ApplyFileDiff) RunWithInteractiveDiff(fullpath, diff string) (any, error) {
-	// tempDir, _, _, inputLines, diffLines, _ := saveInputAndPatch(fullpath, diff)
-	tempDir, _, tempPatchFile, inputLines, diffLines, err := saveInputAndPatch(fullpath, diff)
+	patches, format, err := ParsePatch(diff)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	outputFile := filepath.Join(tempDir, "outputfile")
-
-	ec := (&EditCosts{InLines: inputLines, DiffLines: diffLines}).Init()
-	cost := ec.Dist()
-	log.Println("Costs: ", cost)
-	outputlines := ec.ApplyPatchToInput()
-	outputbytes := []byte(strings.Join(outputlines, "\n"))
-	if err = os.WriteFile(outputFile, outputbytes, 0666); err != nil {
-		panic(err)
+	if expectedHash != "" && len(patches) > 1 {
+		return nil, nil, fmt.Errorf("expected_hash can only be used with single file diffs")
 	}
 
-	// atmost one of diff or input pointer would have reached the end - so copy the rest over
-
-	log.Println("Patch written to: ", tempPatchFile)
-	log.Println("Patched File written to: ", outputFile)
-
-	var input int
-	for input < 1 || input > 2 {
-		fmt.Println("")
-		fmt.Printf("1. Override %s with patched output (%s)\n", fullpath, outputFile)
-		fmt.Println("2. Do nothing and cancel patching")
-		fmt.Println("")
-		fmt.Print("What would you like to do? - ")
-		fmt.Scanln(&input)
+	result := &ApplyDiffResult{Format: format}
+	var pending []*patchedFile
+	for _, fp := range patches {
+		if fp.Path() == "" {
+			if path == "" {
+				return nil, nil, fmt.Errorf("the diff has no file headers - pass the file to patch as 'path'")
+			}
+			if fp.OldPath != DevNull {
+				fp.OldPath = path
+			}
+			fp.NewPath = path
+		}
+		change := r.patchFile(ctx, fp, expectedHash, costs, minConfidence)
+		result.Files = append(result.Files, change.result)
+		pending = append(pending, change)
 	}
 
-	if input == 1 {
-		if err = os.WriteFile(outputFile, outputbytes, 0666); err != nil {
-			panic(err)
+	for _, file := range result.Files {
+		if file.Failed() {
+			return nil, nil, &PatchError{Result: result}
 		}
-		log.Printf("Updated %s", fullpath)
-		return "SUCCESS", nil
 	}
-
-	return nil, nil
-
-	// now generate the stuff onto this
-	// return nil, nil
+	return result, pending, nil
 }
 
-// Tries to apply the patch by calling the unix patch command
-func (r *ApplyFileDiff) RunWithPatch(fullpath, diff string) (any, error) {
-	tempDir, tempInFile, tempPatchFile, _, _, err := saveInputAndPatch(fullpath, diff)
+// Preview returns the diff of each file as it would be patched.
+func (r *ApplyFileDiff) Preview(ctx context.Context, args map[string]any) (string, error) {
+	// Hunks are reviewed (if at all) when the diff is applied
+	_, pending, err := r.plan(context.Background(), args)
 	if err != nil {
-		return nil, err
+		return "", err
+	}
+	var out strings.Builder
+	for _, change := range pending {
+		path := change.result.Path
+		if change.delete {
+			fmt.Fprintf(&out, "Delete %s\n", path)
+			continue
+		}
+		from, srcpath := "a/"+path, change.fullpath
+		if change.oldpath != "" {
+			from, srcpath = "a/"+change.result.OldPath, change.oldpath
+			fmt.Fprintf(&out, "Rename %s to %s\n", change.result.OldPath, path)
+		}
+		original, err := os.ReadFile(srcpath)
+		if os.IsNotExist(err) {
+			from = DevNull
+		} else if err != nil {
+			return "", err
+		}
+		out.WriteString(UnifiedDiff(from, "b/"+path, string(original), string(change.content), 3))
 	}
-	outputFile := filepath.Join(tempDir, "outputfile")
+	return out.String(), nil
+}
 
-	log.Printf("Running patch -u %s -i %s", tempInFile, tempPatchFile)
-	cmd := exec.Command("patch", "-u", tempInFile, "-i", tempPatchFile, "-o", outputFile)
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		log.Println("Cannot get stderr: ", err)
-		return nil, err
+// patchFile works out the new contents of the file targeted by a FilePatch
+// without changing anything on disk.
+func (r *ApplyFileDiff) patchFile(ctx context.Context, fp *FilePatch, expectedHash string, costs *CostModel, minConfidence float64) *patchedFile {
+	change := &patchedFile{result: &FilePatchResult{Path: fp.Path(), Action: "modified", Hunks: []*HunkResult{}}}
+	fail := func(err error) *patchedFile {
+		change.result.Error = err.Error()
+		return change
 	}
-	stdout, err := cmd.StdoutPipe()
+
+	fullpath, err := r.ResolvePath(fp.Path())
 	if err != nil {
-		log.Println("Cannot get stdout: ", err)
-		return nil, err
+		return fail(err)
+	}
+	change.fullpath = fullpath
+	srcpath := fullpath
+	if fp.IsRename() {
+		change.result.Action, change.result.OldPath = "renamed", fp.OldPath
+		if srcpath, err = r.ResolvePath(fp.OldPath); err != nil {
+			return fail(err)
+		}
+		change.oldpath = srcpath
+		if _, err := os.Stat(fullpath); err == nil {
+			return fail(fmt.Errorf("cannot rename %s: destination already exists", fp.OldPath))
+		}
+	}
+
+	var original []byte
+	if fp.IsNew() {
+		change.result.Action = "created"
+		if _, err := os.Stat(fullpath); err == nil {
+			return fail(fmt.Errorf("file already exists"))
+		}
+	} else if original, err = os.ReadFile(srcpath); err != nil {
+		return fail(err)
+	}
+	if expectedHash != "" {
+		if err := r.CheckWrite(ctx, fp.Path(), srcpath, expectedHash, original, !fp.IsNew()); err != nil {
+			return fail(err)
+		}
 	}
 
-	if err = cmd.Start(); err != nil {
-		log.Fatal("Could not sart command: ", err)
+	content := string(original)
+	reviewer := HunkReviewerFrom(ctx)
+	if fp.IsDelete() {
+		change.result.Action = "deleted"
+		if content == "" || (reviewer == nil && fp.DeletesWholeFile) {
+			change.delete = true
+			return change
+		}
+		if reviewer == nil {
+			// The patch's own hunks have to remove every line of the file -
+			// a bare "+++ /dev/null" must not delete whatever is there
+			lines, results := ApplyHunks(SplitLines(content), fp.Hunks, costs, minConfidence)
+			change.result.Hunks = append(change.result.Hunks, results...)
+			if slices.ContainsFunc(results, func(h *HunkResult) bool { return h.Status == HunkConflicted || h.Status == HunkFailed }) || len(lines) > 0 {
+				return fail(fmt.Errorf("the patch deletes the file but its hunks do not remove all of its %d lines", len(SplitLines(content))))
+			}
+			change.delete = true
+			return change
+		}
+		// Have the deletion reviewed as a hunk removing every line
+		hunk := &Hunk{Lines: []string{}}
+		for _, line := range SplitLines(content) {
+			hunk.Lines = append(hunk.Lines, "-"+line)
+		}
+		trimHunk(hunk)
+		hunk.OldStart = 1
+		fp = &FilePatch{OldPath: fp.OldPath, NewPath: fp.OldPath, Hunks: []*Hunk{hunk}}
 	}
 
-	output, err := io.ReadAll(stdout)
-	errout, err := io.ReadAll(stderr)
+	var lines []string
+	var results []*HunkResult
+	if reviewer != nil {
+		if lines, results, err = reviewHunks(SplitLines(content), fp.Path(), fp.Hunks, costs, minConfidence, reviewer); err != nil {
+			return fail(err)
+		}
+	} else {
+		lines, results = ApplyHunks(SplitLines(content), fp.Hunks, costs, minConfidence)
+	}
+	change.result.Hunks = append(change.result.Hunks, results...)
+	if reviewer != nil {
+		accepted := 0
+		for _, hunk := range results {
+			if hunk.Decision == DecisionAccept || hunk.Decision == DecisionEdit {
+				accepted++
+			}
+		}
+		switch {
+		case change.result.Action == "deleted" && accepted > 0 && results[0].Decision == DecisionAccept:
+			change.delete = true
+			return change
+		case change.result.Action == "deleted" && accepted > 0:
+			// Edited to keep some of the file
+			change.result.Action = "modified"
+		case accepted == 0 && !fp.IsRename():
+			change.result.Action = "unchanged"
+			return change
+		}
+	}
 
-	if err := cmd.Wait(); err != nil {
-		log.Println("Error patching: ", err.Error(), errout)
-		log.Println("Error Output: ", string(errout))
-		log.Println("Std Output: ", string(output))
-		log.Println("Could not wait: ", err)
-		if string(errout) != "" {
-			return string(errout), nil
+	eol := "\n"
+	if strings.Contains(content, "\r\n") {
+		eol = "\r\n"
+	}
+	newContent := strings.Join(lines, eol)
+	trailingNewline := strings.HasSuffix(content, "\n") || fp.IsNew()
+	if len(fp.Hunks) > 0 {
+		last := fp.Hunks[len(fp.Hunks)-1]
+		if last.NoNewlineAtEndNew {
+			trailingNewline = false
+		} else if last.NoNewlineAtEndOld {
+			trailingNewline = true
 		}
-		return string(output), nil
 	}
+	if trailingNewline && len(lines) > 0 {
+		newContent += eol
+	}
+	change.content = []byte(newContent)
+	change.result.Hash = HashContent(change.content)
+	return change
+}
 
-	log.Println("Patch result: ", output)
-	return "SUCCESS", nil
+func (r *ApplyFileDiff) writeChange(change *patchedFile) error {
+	if change.delete {
+		if err := os.Remove(change.fullpath); err != nil {
+			return err
+		}
+		r.Files.Forget(change.fullpath)
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(change.fullpath), 0755); err != nil {
+		return err
+	}
+	if change.oldpath != "" {
+		// Renamed files keep their permissions
+		if err := os.Rename(change.oldpath, change.fullpath); err != nil {
+			return err
+		}
+		r.Files.Forget(change.oldpath)
+	}
+	if err := WriteFileAtomic(change.fullpath, change.content, 0644); err != nil {
+		return err
+	}
+	r.Files.Record(change.fullpath, change.result.Hash)
+	return nil
 }
@@ -0,0 +1,273 @@
+package tools
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// Approval modes of a tool (see ApprovalPolicy)
+const (
+	ApprovalAuto    = "auto"
+	ApprovalConfirm = "confirm"
+	ApprovalDeny    = "deny"
+)
+
+var approvalModes = []string{ApprovalAuto, ApprovalConfirm, ApprovalDeny}
+
+// ErrNotApproved is returned (wrapped in an *ApprovalError) for tool calls that
+// were denied or not approved.
+var ErrNotApproved = errors.New("tool call not approved")
+
+// An ApprovalPolicy decides which tool calls run straight away (auto), which
+// need the user's approval (confirm) and which are never run (deny).
+type ApprovalPolicy struct {
+	// Mode of individual tools by name
+	Tools map[string]string `json:"tools,omitempty"`
+
+	// Mode of the mutating tools not in Tools (defaults to confirm).
+	// Read-only tools (see ReadOnlyTool) not in Tools are always auto.
+	Default string `json:"default,omitempty"`
+}
+
+// Validate checks that every mode is known.
+func (p *ApprovalPolicy) Validate() error {
+	if p.Default != "" && !slices.Contains(approvalModes, p.Default) {
+		return fmt.Errorf("invalid default approval mode %q (expected one of %s)", p.Default, strings.Join(approvalModes, ", "))
+	}
+	for name, mode := range p.Tools {
+		if !slices.Contains(approvalModes, mode) {
+			return fmt.Errorf("%s: invalid approval mode %q (expected one of %s)", name, mode, strings.Join(approvalModes, ", "))
+		}
+	}
+	return nil
+}
+
+// Mode returns the approval mode of a tool.
+func (p *ApprovalPolicy) Mode(tool Tool) string {
+	if mode, ok := p.Tools[tool.Name()]; ok {
+		return mode
+	}
+	if IsReadOnly(tool) {
+		return ApprovalAuto
+	}
+	if p.Default == "" {
+		return ApprovalConfirm
+	}
+	return p.Default
+}
+
+// An ApprovalError is returned for tool calls that were denied by their
+// approval mode or rejected by the user.  Reason is what the user said when
+// rejecting the call so the AI can act on it.
+type ApprovalError struct {
+	Tool   string `json:"tool"`
+	Mode   string `json:"mode"`
+	Reason string `json:"reason,omitempty"`
+}
+
+func (e *ApprovalError) Error() string {
+	msg := fmt.Sprintf("%v: %s", ErrNotApproved, e.Tool)
+	if e.Mode == ApprovalDeny {
+		msg += " is not allowed to run"
+	}
+	if e.Reason != "" {
+		msg += " - " + e.Reason
+	}
+	return msg
+}
+
+func (e *ApprovalError) Unwrap() error {
+	return ErrNotApproved
+}
+
+// An Approval is the user's answer to a tool call needing approval.
+type Approval struct {
+	// One of DecisionAccept, DecisionReject or DecisionEdit
+	Decision string
+
+	// Why the call was rejected (passed back to the AI)
+	Reason string
+
+	// The edited arguments to run the tool with instead
+	Args map[string]any
+}
+
+// A ToolApprover is asked about the tool calls an ApprovalPolicy wants
+// confirmed.
+type ToolApprover interface {
+	// ApproveTool is shown the call along with a preview of what it will do
+	ApproveTool(name string, args map[string]any, preview string) (*Approval, error)
+}
+
+type toolApproverKey struct{}
+
+// WithToolApprover returns a context whose tool calls needing approval are
+// approved by approver.  Without one they are not run.
+func WithToolApprover(ctx context.Context, approver ToolApprover) context.Context {
+	return context.WithValue(ctx, toolApproverKey{}, approver)
+}
+
+// ToolApproverFrom returns the approver set with WithToolApprover (if any).
+func ToolApproverFrom(ctx context.Context) ToolApprover {
+	approver, _ := ctx.Value(toolApproverKey{}).(ToolApprover)
+	return approver
+}
+
+// PreviewCall describes what calling a tool with args will do - the tool's
+// own Preview if it has one, otherwise its arguments.
+func PreviewCall(ctx context.Context, tool Tool, args map[string]any) string {
+	var preview string
+	var err error
+	if previewer, ok := tool.(Previewer); ok {
+		if preview, err = previewer.Preview(ctx, args); err == nil && preview != "" {
+			return preview
+		}
+	}
+	encoded, _ := json.MarshalIndent(args, "", "  ")
+	preview = fmt.Sprintf("Call %s with\n\n%s\n", tool.Name(), encoded)
+	if err != nil {
+		preview += fmt.Sprintf("\nThe call is likely to fail: %v\n", err)
+	}
+	return preview
+}
+
+// approveCall applies the registry's ApprovalPolicy to a call (with validated
+// args) returning the arguments to run the tool with and the context to run
+// it in.  Calls to tools whose hunks are reviewed (see HunkReviewable) are not
+// confirmed separately.
+func (r *Registry) approveCall(ctx context.Context, tool Tool, args map[string]any) (map[string]any, context.Context, error) {
+	mode := r.Approvals.Mode(tool)
+	switch mode {
+	case ApprovalAuto:
+		return args, ctx, nil
+	case ApprovalDeny:
+		return nil, ctx, &ApprovalError{Tool: tool.Name(), Mode: mode}
+	}
+
+	if ReviewsHunks(ctx, tool) {
+		// Reviewing each hunk is approval enough
+		return args, ctx, nil
+	}
+	approver := ToolApproverFrom(ctx)
+	if approver == nil {
+		return nil, ctx, &ApprovalError{Tool: tool.Name(), Mode: mode, Reason: "the call needs approval and no one is available to approve it"}
+	}
+	for {
+		approval, err := approver.ApproveTool(tool.Name(), args, PreviewCall(ctx, tool, args))
+		if err != nil {
+			return nil, ctx, err
+		}
+		switch approval.Decision {
+		case DecisionAccept:
+			// The user has seen the command so the command policy need not ask again
+			return args, WithCommandConfirmer(ctx, approvedCommands{}), nil
+		case DecisionEdit:
+			params := tool.Parameters()
+			for _, coercion := range CoerceArgs(params, approval.Args) {
+				Warn(ctx, "argument %s", coercion)
+			}
+			ApplyDefaults(params, approval.Args)
+			if violations := ValidateArgs(params, approval.Args); len(violations) > 0 {
+				return nil, ctx, &ValidationError{Tool: tool.Name(), Violations: violations}
+			}
+			Warn(ctx, "the arguments were edited by the user before running")
+			// Have the edited call approved too
+			args = approval.Args
+		default:
+			reason := approval.Reason
+			if reason == "" {
+				reason = "the user rejected the call"
+			} else {
+				reason = "the user rejected the call: " + reason
+			}
+			return nil, ctx, &ApprovalError{Tool: tool.Name(), Mode: mode, Reason: reason}
+		}
+	}
+}
+
+// approvedCommands confirms every command (for calls the user has approved).
+type approvedCommands struct{}
+
+func (approvedCommands) ConfirmCommand(command string, decision *PolicyDecision) (bool, string, error) {
+	return true, "", nil
+}
+
+// TerminalApprover asks on a terminal whether tool calls may run.
+type TerminalApprover struct {
+	In  *bufio.Reader
+	Out io.Writer
+
+	// Opens text in an editor and returns the edited text.  Defaults to
+	// running $VISUAL or $EDITOR (or vi) on a temporary file.
+	Edit func(text string) (string, error)
+}
+
+// NewTerminalApprover creates an approver prompting on in/out.
+func NewTerminalApprover(in io.Reader, out io.Writer) *TerminalApprover {
+	return &TerminalApprover{In: bufio.NewReader(in), Out: out}
+}
+
+const approveHelp = `y - run the tool
+n - do not run the tool (optionally saying why)
+e - edit the tool's arguments before running it
+? - print help`
+
+// ApproveTool shows the preview of a call and asks whether to run it.  No
+// answer (eg stdin being closed) rejects the call.
+func (t *TerminalApprover) ApproveTool(name string, args map[string]any, preview string) (*Approval, error) {
+	fmt.Fprintf(t.Out, "\n%s wants to run:\n\n%s\n", name, strings.TrimRight(preview, "\n"))
+	for {
+		fmt.Fprint(t.Out, "\nRun it [y,n,e,?]? ")
+		answer, err := t.In.ReadString('\n')
+		if err != nil && answer == "" {
+			if err == io.EOF {
+				return &Approval{Decision: DecisionReject, Reason: "no approval was given"}, nil
+			}
+			return nil, err
+		}
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "y", "yes":
+			return &Approval{Decision: DecisionAccept}, nil
+		case "n", "no":
+			fmt.Fprint(t.Out, "Reason (optional, passed back to the AI): ")
+			reason, _ := t.In.ReadString('\n')
+			return &Approval{Decision: DecisionReject, Reason: strings.TrimSpace(reason)}, nil
+		case "e":
+			edited, err := t.editArgs(args)
+			if err != nil {
+				fmt.Fprintf(t.Out, "Could not edit the arguments: %v\n", err)
+				continue
+			}
+			return &Approval{Decision: DecisionEdit, Args: edited}, nil
+		default:
+			fmt.Fprintln(t.Out, approveHelp)
+		}
+	}
+}
+
+// editArgs lets the user edit the arguments of a call as JSON.
+func (t *TerminalApprover) editArgs(args map[string]any) (map[string]any, error) {
+	edit := t.Edit
+	if edit == nil {
+		edit = func(text string) (string, error) { return editFileInEditor("vibrant-args-*.json", text) }
+	}
+	encoded, err := json.MarshalIndent(args, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	edited, err := edit(string(encoded) + "\n")
+	if err != nil {
+		return nil, err
+	}
+	var out map[string]any
+	if err := json.Unmarshal([]byte(edited), &out); err != nil {
+		return nil, fmt.Errorf("the arguments are not a valid JSON object: %w", err)
+	}
+	return out, nil
+}
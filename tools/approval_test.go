@@ -0,0 +1,128 @@
+package tools
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+type fakeApprover struct {
+	approvals []*Approval
+	previews  []string
+}
+
+func (f *fakeApprover) ApproveTool(name string, args map[string]any, preview string) (*Approval, error) {
+	f.previews = append(f.previews, preview)
+	approval := f.approvals[0]
+	f.approvals = f.approvals[1:]
+	return approval, nil
+}
+
+type fakeReviewer struct {
+	decisions []string
+	reviewed  int
+}
+
+func (f *fakeReviewer) ReviewHunk(path string, index int, hunk *Hunk, result *HunkResult) (*HunkReview, error) {
+	decision := f.decisions[f.reviewed]
+	f.reviewed++
+	return &HunkReview{Decision: decision}, nil
+}
+
+func TestApprovals(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("one\ntwo\n"), 0644)
+	registry := NewRegistry(NewWorkspace(dir))
+	registry.Approvals = &ApprovalPolicy{Tools: map[string]string{"rename_file": ApprovalDeny}}
+	write := map[string]any{"path": "a.txt", "contents": "one\nthree\n", "encoding": "plain"}
+
+	// Read-only tools do not need approval
+	if env := registry.Invoke(t.Context(), "read_file", map[string]any{"path": "a.txt"}); !env.Ok {
+		t.Errorf("expected read_file to run without approval, got %s", env)
+	}
+	env := registry.Invoke(t.Context(), "rename_file", map[string]any{"src": "a.txt", "dest": "b.txt"})
+	if env.Ok || env.Error.Code != ErrCodeNotApproved {
+		t.Errorf("expected rename_file to be denied, got %s", env)
+	}
+	env = registry.Invoke(t.Context(), "write_file", write)
+	if env.Ok || env.Error.Code != ErrCodeNotApproved {
+		t.Errorf("expected write_file to need approval, got %s", env)
+	}
+
+	approver := &fakeApprover{approvals: []*Approval{{Decision: DecisionReject, Reason: "keep two"}}}
+	env = registry.Invoke(WithToolApprover(t.Context(), approver), "write_file", write)
+	if env.Ok || !strings.Contains(env.Error.Message, "keep two") || env.Error.Details.(*ApprovalError).Reason != "the user rejected the call: keep two" {
+		t.Errorf("expected the rejection reason to be returned, got %s", env)
+	}
+	if len(approver.previews) != 1 || !strings.Contains(approver.previews[0], "-two\n+three\n") {
+		t.Errorf("expected the diff to be previewed, got %q", approver.previews)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "a.txt")); string(data) != "one\ntwo\n" {
+		t.Errorf("rejected write changed the file: %q", data)
+	}
+
+	// Edited arguments are previewed (and approved) again before running
+	edited := map[string]any{"path": "a.txt", "contents": "one\nfour\n", "encoding": "plain"}
+	approver = &fakeApprover{approvals: []*Approval{{Decision: DecisionEdit, Args: edited}, {Decision: DecisionAccept}}}
+	env = registry.Invoke(WithToolApprover(t.Context(), approver), "write_file", write)
+	if !env.Ok || len(approver.previews) != 2 || !strings.Contains(approver.previews[1], "+four") {
+		t.Errorf("expected the edited call to run, got %s (previews %q)", env, approver.previews)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "a.txt")); string(data) != "one\nfour\n" {
+		t.Errorf("expected the edited contents to be written, got %q", data)
+	}
+
+	approver = &fakeApprover{approvals: []*Approval{{Decision: DecisionAccept}}}
+	env = registry.Invoke(WithToolApprover(t.Context(), approver), "edit_file",
+		map[string]any{"path": "a.txt", "edits": []any{map[string]any{"old_text": "four", "new_text": "five"}}})
+	if !env.Ok || !strings.Contains(approver.previews[0], "-four\n+five\n") {
+		t.Errorf("expected the edit to be previewed and run, got %s (previews %q)", env, approver.previews)
+	}
+
+	if err := (&ApprovalPolicy{Default: "sometimes"}).Validate(); err == nil {
+		t.Errorf("expected an invalid mode to fail validation")
+	}
+}
+
+func TestApprovals_HunkReview(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("one\ntwo\n"), 0644)
+	registry := NewRegistry(NewWorkspace(dir))
+	registry.Approvals = &ApprovalPolicy{}
+	reviewer := &fakeReviewer{decisions: []string{DecisionAccept}}
+
+	// Reviewing hunks only stands in for approval for tools that review them
+	approver := &fakeApprover{approvals: []*Approval{{Decision: DecisionReject}}}
+	ctx := WithToolApprover(WithHunkReviewer(t.Context(), reviewer), approver)
+	env := registry.Invoke(ctx, "write_file", map[string]any{"path": "a.txt", "contents": "three\n", "encoding": "plain"})
+	if env.Ok || env.Error.Code != ErrCodeNotApproved || len(approver.previews) != 1 {
+		t.Errorf("expected write_file to still need approval when reviewing, got %s", env)
+	}
+
+	env = registry.Invoke(ctx, "apply_file_diff", map[string]any{"diff": "--- a/a.txt\n+++ b/a.txt\n@@ -1,2 +1,2 @@\n one\n-two\n+three\n"})
+	if !env.Ok || len(approver.previews) != 1 || reviewer.reviewed != 1 {
+		t.Errorf("expected the reviewed diff to run without separate approval, got %s", env)
+	}
+}
+
+func TestApprovals_Commands(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses POSIX commands")
+	}
+	registry := NewRegistry(NewWorkspace(t.TempDir()))
+	registry.Approvals = &ApprovalPolicy{}
+
+	// An approved call is not confirmed again by the command policy
+	approver := &fakeApprover{approvals: []*Approval{{Decision: DecisionAccept}}}
+	confirmer := &fakeConfirmer{}
+	ctx := WithCommandConfirmer(WithToolApprover(t.Context(), approver), confirmer)
+	env := registry.Invoke(ctx, "run_shell_command", map[string]any{"command": "rm -r missing"})
+	if !env.Ok || len(confirmer.commands) != 0 {
+		t.Errorf("expected the approved command to run, got %s", env)
+	}
+	if !strings.Contains(approver.previews[0], "rm -r missing") || !strings.Contains(approver.previews[0], "recursive-delete") {
+		t.Errorf("expected the command and its policy decision to be previewed, got %q", approver.previews[0])
+	}
+}
@@ -0,0 +1,163 @@
+package tools
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// A Coercion records an argument that was converted to its declared type
+// before the tool was run, eg a "true" string passed for a boolean.
+type Coercion struct {
+	Path string `json:"path"`
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+func (c Coercion) String() string {
+	return fmt.Sprintf("%s: coerced %s to %s", c.Path, c.From, c.To)
+}
+
+// CoerceArgs normalizes loosely typed arguments (as LLMs tend to produce) into
+// the types declared by the parameters.  args is updated in place and every
+// conversion performed is returned.  Values that cannot be converted are left
+// as is for ValidateArgs to report.
+func CoerceArgs(params []*Parameter, args map[string]any) (coercions []Coercion) {
+	for _, param := range params {
+		value, ok := args[param.Name]
+		if !ok || value == nil {
+			continue
+		}
+		args[param.Name], coercions = coerceValue(param, param.Name, value, coercions)
+	}
+	return
+}
+
+func coerceValue(param *Parameter, path string, value any, coercions []Coercion) (any, []Coercion) {
+	if value == nil {
+		return value, coercions
+	}
+
+	out, ok := value, false
+	switch param.Type {
+	case "boolean":
+		out, ok = coerceBool(value)
+	case "integer", "number":
+		out, ok = coerceNumber(value, param.Type == "integer")
+	case "string":
+		out, ok = coerceString(value)
+	case "array":
+		out, ok = coerceArray(value)
+	case "object":
+		out, ok = coerceObject(value)
+	}
+	if ok {
+		coercions = append(coercions, Coercion{Path: path, From: jsonTypeOf(value), To: param.Type})
+	}
+
+	// Recurse into containers so nested values get the same treatment
+	if items, isArray := out.([]any); isArray && param.Items != nil {
+		for i, item := range items {
+			items[i], coercions = coerceValue(param.Items, fmt.Sprintf("%s[%d]", path, i), item, coercions)
+		}
+	}
+	if obj, isObj := out.(map[string]any); isObj && param.Type == "object" {
+		for _, child := range param.Properties {
+			if childValue, exists := obj[child.Name]; exists {
+				obj[child.Name], coercions = coerceValue(child, path+"."+child.Name, childValue, coercions)
+			}
+		}
+	}
+	return out, coercions
+}
+
+// Each of the coerceXXX functions returns the converted value and true if a
+// conversion was performed.
+
+func coerceBool(value any) (any, bool) {
+	switch v := value.(type) {
+	case string:
+		switch strings.ToLower(strings.TrimSpace(v)) {
+		case "true", "yes", "on", "1":
+			return true, true
+		case "false", "no", "off", "0", "":
+			return false, true
+		}
+	default:
+		if num, ok := toFloat(v); ok && (num == 0 || num == 1) {
+			return num == 1, true
+		}
+	}
+	return value, false
+}
+
+func coerceNumber(value any, integer bool) (any, bool) {
+	switch v := value.(type) {
+	case string:
+		num, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil || (integer && num != math.Trunc(num)) {
+			return value, false
+		}
+		return num, true
+	case bool:
+		if v {
+			return float64(1), true
+		}
+		return float64(0), true
+	}
+	return value, false
+}
+
+func coerceString(value any) (any, bool) {
+	switch v := value.(type) {
+	case string:
+		return value, false
+	case bool:
+		return strconv.FormatBool(v), true
+	default:
+		if num, ok := toFloat(v); ok {
+			return strconv.FormatFloat(num, 'f', -1, 64), true
+		}
+		// Structured values (eg JSON file contents sent as an object) are encoded back
+		if data, err := json.Marshal(v); err == nil {
+			return string(data), true
+		}
+	}
+	return value, false
+}
+
+func coerceArray(value any) (any, bool) {
+	if kind := reflect.TypeOf(value).Kind(); kind == reflect.Slice || kind == reflect.Array {
+		return value, false
+	}
+	if str, ok := value.(string); ok {
+		var out []any
+		if trimmed := strings.TrimSpace(str); strings.HasPrefix(trimmed, "[") && json.Unmarshal([]byte(trimmed), &out) == nil {
+			return out, true
+		}
+	}
+	// A single value where a list was expected
+	return []any{value}, true
+}
+
+func coerceObject(value any) (any, bool) {
+	if str, ok := value.(string); ok {
+		var out map[string]any
+		if json.Unmarshal([]byte(strings.TrimSpace(str)), &out) == nil && out != nil {
+			return out, true
+		}
+	}
+	return value, false
+}
+
+// DecodeJSONString decodes a JSON encoded string literal (eg "\"line1\\nline2\"")
+// into the raw string it represents.  ok is false if s is not a JSON string.
+func DecodeJSONString(s string) (out string, ok bool) {
+	if err := json.Unmarshal([]byte(s), &out); err != nil {
+		return s, false
+	}
+	return out, true
+}
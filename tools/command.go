@@ -0,0 +1,431 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults for commands run with ExecCommand
+const (
+	DefaultCommandTimeout   = 2 * time.Minute
+	DefaultCommandMaxOutput = 32 * 1024
+)
+
+// ErrShellSyntax is returned (wrapped) when a command run without a shell
+// uses shell syntax like pipes or redirects.
+var ErrShellSyntax = errors.New("command uses shell syntax")
+
+// CommandOptions control how ExecCommand runs a command.
+type CommandOptions struct {
+	// Directory to run the command in
+	Dir string
+
+	// Run the command with the system shell (see shellCommand) instead of
+	// splitting it into arguments with SplitCommand
+	Shell bool
+
+	// Extra environment variables ("NAME=value") overriding the inherited ones
+	Env []string
+
+	// Passed to the command's standard input
+	Stdin string
+
+	// How long the command (and everything it started) may run before being
+	// killed.  Defaults to DefaultCommandTimeout.
+	Timeout time.Duration
+
+	// Bytes of stdout and of stderr kept (half from the start and half from
+	// the end of each).  Defaults to DefaultCommandMaxOutput.
+	MaxOutput int
+}
+
+// CommandResult is the outcome of a command run with ExecCommand.
+type CommandResult struct {
+	Command string `json:"command"`
+
+	// Exit code of the command (-1 if it was killed)
+	ExitCode int    `json:"exit_code"`
+	Stdout   string `json:"stdout"`
+	Stderr   string `json:"stderr"`
+
+	// Total bytes written to stdout and stderr (including any truncated)
+	StdoutBytes int `json:"stdout_bytes"`
+	StderrBytes int `json:"stderr_bytes"`
+
+	// Whether the middle of stdout or stderr was dropped
+	Truncated bool `json:"truncated,omitempty"`
+
+	// Whether the command was killed for running longer than its timeout
+	TimedOut   bool  `json:"timed_out,omitempty"`
+	DurationMs int64 `json:"duration_ms"`
+}
+
+// ExecCommand runs a command to completion capturing its output.  A command
+// exiting with a non zero code is not an error (see CommandResult.ExitCode) -
+// errors are only returned for commands that could not be started or if ctx
+// was cancelled.  On timeout the command's whole process group is killed.
+func ExecCommand(ctx context.Context, command string, opts CommandOptions) (*CommandResult, error) {
+	var argv []string
+	if opts.Shell {
+		argv = shellCommand(command)
+	} else {
+		var err error
+		if argv, err = SplitCommand(command); err != nil {
+			return nil, err
+		}
+		if len(argv) == 0 {
+			return nil, fmt.Errorf("empty command")
+		}
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultCommandTimeout
+	}
+	if opts.MaxOutput <= 0 {
+		opts.MaxOutput = DefaultCommandMaxOutput
+	}
+
+	runCtx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+	cmd := exec.CommandContext(runCtx, argv[0], argv[1:]...)
+	cmd.Dir = opts.Dir
+	if len(opts.Env) > 0 {
+		cmd.Env = append(os.Environ(), opts.Env...)
+	}
+	cmd.Stdin = strings.NewReader(opts.Stdin)
+	stdout, stderr := NewOutputBuffer(opts.MaxOutput), NewOutputBuffer(opts.MaxOutput)
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	killProcessGroup(cmd)
+	// Children that outlive the command (and hold its pipes open) must not
+	// keep us waiting
+	cmd.WaitDelay = time.Second
+
+	start := time.Now()
+	err := cmd.Run()
+	result := &CommandResult{
+		Command:     command,
+		ExitCode:    -1,
+		Stdout:      stdout.String(),
+		Stderr:      stderr.String(),
+		StdoutBytes: stdout.Total(),
+		StderrBytes: stderr.Total(),
+		Truncated:   stdout.Truncated() || stderr.Truncated(),
+		DurationMs:  time.Since(start).Milliseconds(),
+	}
+	if cmd.ProcessState != nil {
+		result.ExitCode = cmd.ProcessState.ExitCode()
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if runCtx.Err() != nil {
+		result.TimedOut = true
+		return result, nil
+	}
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) && !errors.Is(err, exec.ErrWaitDelay) {
+		return nil, err
+	}
+	return result, nil
+}
+
+// SplitCommand splits a command line into arguments the way a POSIX shell
+// would without expanding anything: arguments are separated by unquoted
+// whitespace, single quotes preserve everything, double quotes preserve all
+// but backslash escapes of '"', '\', '$' and '`', and a backslash outside
+// quotes escapes the next character.  Unquoted shell operators (pipes,
+// redirects, command separators, subshells) return an ErrShellSyntax as
+// they need a real shell.
+func SplitCommand(command string) (args []string, err error) {
+	tokens, err := lexCommand(command)
+	if err != nil {
+		return nil, err
+	}
+	for _, token := range tokens {
+		if token.op == "\n" {
+			continue
+		} else if token.op != "" {
+			return nil, fmt.Errorf("%w (%q) - run it with shell: true", ErrShellSyntax, token.op)
+		}
+		args = append(args, token.text)
+	}
+	return args, nil
+}
+
+// A ShellCommand is one of the simple commands of a shell command line (see
+// SplitShellCommand).
+type ShellCommand struct {
+	Args []string
+
+	// Operator joining this command to the previous one ("" for the first,
+	// "|", "&&", "||", ";", "&" or "$(" / "`" for command substitutions)
+	After string
+
+	// Files the command's output is redirected to
+	Redirects []string
+}
+
+// Stands in for the output of a command substitution in the arguments of the
+// command using it
+const SubstitutionWord = "$(...)"
+
+// SplitShellCommand splits a shell command line into its simple commands
+// (those joined by pipes, command lists, subshells and command substitutions)
+// without running anything.  Quoting works like in SplitCommand.  Commands
+// inside a substitution come before the command using their output, where
+// the substitution is replaced by SubstitutionWord.
+func SplitShellCommand(command string) (out []*ShellCommand, err error) {
+	tokens, err := lexCommand(command)
+	if err != nil {
+		return nil, err
+	}
+	current := &ShellCommand{}
+	flush := func(op string) {
+		if len(current.Args) > 0 || len(current.Redirects) > 0 {
+			out = append(out, current)
+		}
+		current = &ShellCommand{After: op}
+	}
+	// Commands (and how they were opened) interrupted by subshells and
+	// substitutions
+	var outer []*ShellCommand
+	var openers []string
+	// Whether each substitution being split directly follows a word (eg
+	// "su$(echo do)") and where the last one ended so the word they make up
+	// stays whole
+	var joined []bool
+	closed := -2
+	joinsWord := func(i int) bool {
+		return tokens[i].glued && len(current.Args) > 0 && (tokens[i-1].op == "" || closed == i-1)
+	}
+	for i := 0; i < len(tokens); i++ {
+		switch op := tokens[i].op; {
+		case op == "" && closed == i-1 && joinsWord(i):
+			current.Args[len(current.Args)-1] += tokens[i].text
+		case op == "":
+			current.Args = append(current.Args, tokens[i].text)
+		case op == ">" || op == ">>" || op == "&>":
+			if i+1 < len(tokens) && tokens[i+1].op == "" {
+				i++
+				current.Redirects = append(current.Redirects, tokens[i].text)
+			}
+		case op == "<" || op == ">&":
+			// Input redirects and duplicated file descriptors do not change
+			// what is run
+			if i+1 < len(tokens) && tokens[i+1].op == "" {
+				i++
+			}
+		case op == "$(" || op == "(" || (op == "`" && (len(openers) == 0 || openers[len(openers)-1] != "`")):
+			outer, openers, joined = append(outer, current), append(openers, op), append(joined, op != "(" && joinsWord(i))
+			current = &ShellCommand{After: op}
+		case (op == ")" || op == "`") && len(outer) > 0:
+			flush("")
+			current = outer[len(outer)-1]
+			if opener := openers[len(openers)-1]; opener != "(" {
+				if joined[len(joined)-1] {
+					current.Args[len(current.Args)-1] += SubstitutionWord
+				} else {
+					current.Args = append(current.Args, SubstitutionWord)
+				}
+				closed = i
+			}
+			outer, openers, joined = outer[:len(outer)-1], openers[:len(openers)-1], joined[:len(joined)-1]
+		case op == "\n":
+			flush(";")
+		case op == "|" || op == "&&" || op == "||" || op == ";" || op == "&":
+			flush(op)
+		}
+	}
+	flush("")
+	for len(outer) > 0 {
+		// Unbalanced parentheses
+		current = outer[len(outer)-1]
+		outer = outer[:len(outer)-1]
+		flush("")
+	}
+	return out, nil
+}
+
+// A token of a command line - either a word or a shell operator
+type commandToken struct {
+	text string
+	op   string
+
+	// Whether no blank separates the token from the previous one
+	glued bool
+}
+
+// Shell operators recognized by lexCommand (longest first)
+var shellOperators = []string{"&&", "||", ">>", ">&", "&>", "$(", "|", "&", ";", "<", ">", "(", ")", "`", "\n"}
+
+// lexCommand splits a command line into words (with quotes and escapes
+// removed) and shell operators.  Newlines are returned as "\n" operators.
+func lexCommand(command string) (tokens []commandToken, err error) {
+	var arg strings.Builder
+	inArg, argGlued, blank := false, false, true
+	startArg := func() {
+		if !inArg {
+			inArg, argGlued, blank = true, !blank, false
+		}
+	}
+	endArg := func() {
+		if inArg {
+			tokens = append(tokens, commandToken{text: arg.String(), glued: argGlued})
+			arg.Reset()
+			inArg = false
+		}
+	}
+	runes := []rune(command)
+	for i := 0; i < len(runes); i++ {
+		ch := runes[i]
+		switch {
+		case ch == '\\':
+			if i+1 < len(runes) {
+				i++
+				if runes[i] != '\n' {
+					arg.WriteRune(runes[i])
+				}
+			}
+			startArg()
+		case ch == '\'':
+			for i++; i < len(runes) && runes[i] != '\''; i++ {
+				arg.WriteRune(runes[i])
+			}
+			if i >= len(runes) {
+				return nil, fmt.Errorf("unterminated single quote in %q", command)
+			}
+			startArg()
+		case ch == '"':
+			for i++; i < len(runes) && runes[i] != '"'; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) && strings.ContainsRune("\"\\$`", runes[i+1]) {
+					i++
+				}
+				arg.WriteRune(runes[i])
+			}
+			if i >= len(runes) {
+				return nil, fmt.Errorf("unterminated double quote in %q", command)
+			}
+			startArg()
+		case ch == ' ' || ch == '\t' || ch == '\r':
+			endArg()
+			blank = true
+		case ch == '\n' || strings.ContainsRune("|&;<>()`$", ch):
+			rest := string(runes[i:])
+			op := ""
+			for _, candidate := range shellOperators {
+				if strings.HasPrefix(rest, candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				// A plain '$'
+				arg.WriteRune(ch)
+				startArg()
+				continue
+			}
+			if strings.ContainsAny(op, "<>") && inArg && arg.Len() > 0 && strings.Trim(arg.String(), "0123456789") == "" {
+				// The file descriptor of a redirect (eg 2>&1) is not an argument
+				arg.Reset()
+				inArg = false
+			}
+			glued := !blank
+			endArg()
+			tokens = append(tokens, commandToken{op: op, glued: glued})
+			blank = false
+			i += len(op) - 1
+		default:
+			arg.WriteRune(ch)
+			startArg()
+		}
+	}
+	endArg()
+	return tokens, nil
+}
+
+// OutputBuffer is an io.Writer keeping the first and last max/2 bytes written
+// to it (and counting the rest) so the output of long running commands stays
+// bounded while keeping both how it started and how it ended.
+type OutputBuffer struct {
+	mu    sync.Mutex
+	max   int
+	head  []byte
+	tail  []byte // ring buffer of the last bytes once head is full
+	next  int    // where the next byte goes in tail
+	total int
+}
+
+// NewOutputBuffer creates an OutputBuffer keeping at most max bytes.
+func NewOutputBuffer(max int) *OutputBuffer {
+	return &OutputBuffer{max: max}
+}
+
+func (b *OutputBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.total += len(p)
+	data := p
+	if room := b.max/2 - len(b.head); room > 0 {
+		n := min(room, len(data))
+		b.head = append(b.head, data[:n]...)
+		data = data[n:]
+	}
+	size := b.max - b.max/2
+	if size == 0 {
+		return len(p), nil
+	}
+	if len(data) > size {
+		data = data[len(data)-size:]
+	}
+	for len(data) > 0 {
+		if len(b.tail) < size {
+			n := min(size-len(b.tail), len(data))
+			b.tail = append(b.tail, data[:n]...)
+			data = data[n:]
+			b.next = len(b.tail) % size
+			continue
+		}
+		n := copy(b.tail[b.next:], data)
+		data = data[n:]
+		b.next = (b.next + n) % size
+	}
+	return len(p), nil
+}
+
+// Total returns the number of bytes written.
+func (b *OutputBuffer) Total() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.total
+}
+
+// Truncated returns true if more bytes were written than were kept.
+func (b *OutputBuffer) Truncated() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.total > len(b.head)+len(b.tail)
+}
+
+// String returns the bytes kept with a marker in place of any that were
+// dropped.
+func (b *OutputBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var sb strings.Builder
+	sb.Write(b.head)
+	if dropped := b.total - len(b.head) - len(b.tail); dropped > 0 {
+		fmt.Fprintf(&sb, "\n... [%d bytes truncated] ...\n", dropped)
+	}
+	if len(b.tail) < b.max-b.max/2 {
+		sb.Write(b.tail)
+	} else {
+		sb.Write(b.tail[b.next:])
+		sb.Write(b.tail[:b.next])
+	}
+	return sb.String()
+}
@@ -0,0 +1,99 @@
+package tools
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSplitCommand(t *testing.T) {
+	tests := []struct {
+		command  string
+		expected []string
+		err      bool
+	}{
+		{"go test ./...", []string{"go", "test", "./..."}, false},
+		{`  grep -n "hello world"  main.go `, []string{"grep", "-n", "hello world", "main.go"}, false},
+		{`echo 'it''s' "a \"b\" \n" c\ d`, []string{"echo", "its", `a "b" \n`, "c d"}, false},
+		{`echo ''`, []string{"echo", ""}, false},
+		{`echo "a|b" 'x>y'`, []string{"echo", "a|b", "x>y"}, false},
+		{`echo "unterminated`, nil, true},
+		{`echo 'unterminated`, nil, true},
+		{"go test ./... | tail", nil, true},
+		{"make && make test", nil, true},
+		{"echo $(pwd)", nil, true},
+	}
+	for _, tc := range tests {
+		got, err := SplitCommand(tc.command)
+		if (err != nil) != tc.err || !slices.Equal(got, tc.expected) {
+			t.Errorf("%s: expected %q (error: %v), got %q (%v)", tc.command, tc.expected, tc.err, got, err)
+		}
+	}
+	if _, err := SplitCommand("ls > out"); !errors.Is(err, ErrShellSyntax) {
+		t.Errorf("expected ErrShellSyntax, got %v", err)
+	}
+}
+
+func TestOutputBuffer(t *testing.T) {
+	buf := NewOutputBuffer(10)
+	buf.Write([]byte("abc"))
+	if buf.String() != "abc" || buf.Truncated() {
+		t.Errorf("unexpected short output %q", buf.String())
+	}
+	buf.Write([]byte("defghijklmnop"))
+	buf.Write([]byte("qrstuvwxyz"))
+	if got := buf.String(); got != "abcde\n... [16 bytes truncated] ...\nvwxyz" || !buf.Truncated() || buf.Total() != 26 {
+		t.Errorf("unexpected truncated output %q", got)
+	}
+}
+
+func TestRunShellCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses POSIX commands")
+	}
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, "a.txt"), []byte("hello\n"), 0644)
+	registry := NewRegistry(NewWorkspace(root))
+	run := func(args map[string]any) (*CommandResult, *Envelope) {
+		env := registry.Invoke(t.Context(), "run_shell_command", args)
+		result, _ := env.Result.(*CommandResult)
+		return result, env
+	}
+
+	result, env := run(map[string]any{"command": "cat 'a.txt'"})
+	if result == nil || result.ExitCode != 0 || result.Stdout != "hello\n" || result.StdoutBytes != 6 {
+		t.Errorf("unexpected result %s", env)
+	}
+
+	result, env = run(map[string]any{"command": "ls missing.txt"})
+	if result == nil || result.ExitCode == 0 || result.Stderr == "" || result.Stdout != "" {
+		t.Errorf("expected a failing exit code and stderr, got %s", env)
+	}
+
+	result, env = run(map[string]any{"command": "echo $GREETING | tr a-z A-Z; cat >&2", "shell": true, "env": []any{"GREETING=hi there"}, "stdin": "from stdin"})
+	if result == nil || result.Stdout != "HI THERE\n" || result.Stderr != "from stdin" {
+		t.Errorf("unexpected shell result %s", env)
+	}
+
+	_, env = run(map[string]any{"command": "cat a.txt | wc -l"})
+	if env.Ok || !strings.Contains(env.Error.Message, "shell: true") {
+		t.Errorf("expected shell syntax to be rejected, got %s", env)
+	}
+
+	result, env = run(map[string]any{"command": "yes", "max_output_bytes": 1000, "timeout_seconds": 1})
+	if result == nil || !result.TimedOut || !result.Truncated || len(result.Stdout) > 1100 || result.StdoutBytes <= 1000 || len(env.Warnings) != 2 {
+		t.Errorf("expected a timed out and truncated result, got %s", env)
+	}
+
+	// The whole process group is killed - not just the shell
+	start := time.Now()
+	result, env = run(map[string]any{"command": "sleep 30 & sleep 30; wait", "shell": true, "timeout_seconds": 1})
+	if result == nil || !result.TimedOut || time.Since(start) > 10*time.Second {
+		t.Errorf("expected the command to be killed, got %s", env)
+	}
+}
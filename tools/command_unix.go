@@ -0,0 +1,27 @@
+//go:build !windows
+
+package tools
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// shellCommand returns the arguments running command with the system shell.
+func shellCommand(command string) []string {
+	return []string{"/bin/sh", "-c", command}
+}
+
+// killProcessGroup runs cmd in its own process group and has it (and every
+// process it started) killed when cmd's context is done.
+func killProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
+
+// terminateProcessGroup asks cmd's process group to terminate (SIGTERM).
+func terminateProcessGroup(cmd *exec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+}
@@ -0,0 +1,30 @@
+//go:build windows
+
+package tools
+
+import (
+	"os/exec"
+	"strconv"
+)
+
+// shellCommand returns the arguments running command with the system shell.
+func shellCommand(command string) []string {
+	return []string{"cmd.exe", "/C", command}
+}
+
+// killProcessGroup has cmd and the processes it started killed when cmd's
+// context is done.
+func killProcessGroup(cmd *exec.Cmd) {
+	cmd.Cancel = func() error {
+		// taskkill /T takes the whole process tree with it
+		if err := exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(cmd.Process.Pid)).Run(); err != nil {
+			return cmd.Process.Kill()
+		}
+		return nil
+	}
+}
+
+// terminateProcessGroup asks cmd's process tree to terminate.
+func terminateProcessGroup(cmd *exec.Cmd) error {
+	return exec.Command("taskkill", "/T", "/PID", strconv.Itoa(cmd.Process.Pid)).Run()
+}
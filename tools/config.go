@@ -0,0 +1,167 @@
+package tools
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Default location of the config file (relative to the current directory).
+const DefaultConfigPath = ".vibrant/config.json"
+
+// Config holds the tool settings that can be provided via a config file, eg:
+//
+//	{
+//	  "root": ".",
+//	  "roots": { "backend": "../backend", "frontend": "../frontend" },
+//	  "deny": [".git/**", ".env*", "*.pem", "secrets/**"],
+//	  "review": ["apply_file_diff"],
+//	  "approvals": { "*": "confirm", "edit_file": "auto", "rename_file": "deny" },
+//	  "commands": {
+//	    "rules": [
+//	      { "name": "no-deploys", "action": "deny", "programs": ["kubectl", "terraform"] },
+//	      { "name": "confirm-pushes", "action": "confirm", "programs": ["git"], "subcommands": ["push"] }
+//	    ],
+//	    "default": "allow"
+//	  }
+//	}
+//
+// Relative directories are resolved against the current working directory.
+type Config struct {
+	// The default project root
+	Root string `json:"root,omitempty"`
+
+	// Named roots addressed in tool paths as "<name>:<path>"
+	Roots map[string]string `json:"roots,omitempty"`
+
+	// Allow and Deny globs applied to every root.  See Sandbox.
+	Allow []string `json:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty"`
+
+	// Tools whose changes are reviewed hunk by hunk (see HunkReviewer) on
+	// the terminal before being applied by 'calls respond'
+	Review []string `json:"review,omitempty"`
+
+	// Approval mode (auto, confirm or deny) of tools run by 'calls respond'
+	// by name.  "*" sets the mode of the mutating tools not listed.
+	Approvals map[string]string `json:"approvals,omitempty"`
+
+	// Which commands run_shell_command and start_process may run.  Replaces
+	// DefaultCommandPolicy when set.
+	Commands *CommandPolicy `json:"commands,omitempty"`
+
+	// Where the config was loaded from - the sandboxes never let tools touch it
+	path string
+}
+
+// LoadConfig loads the config at the given path.  A missing file is not an
+// error and results in an empty config.
+func LoadConfig(path string) (*Config, error) {
+	config := &Config{path: path}
+	if path == "" {
+		return config, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return config, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+	return config, nil
+}
+
+// SetRoots applies root specs (as passed to --root or VIBRANT_ROOT) on top of
+// the config.  A plain "<dir>" sets the default root and "<name>=<dir>" adds
+// (or overrides) a named root.
+func (c *Config) SetRoots(specs []string) {
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		if name, dir, found := strings.Cut(spec, "="); found {
+			if c.Roots == nil {
+				c.Roots = map[string]string{}
+			}
+			c.Roots[name] = dir
+		} else {
+			c.Root = spec
+		}
+	}
+}
+
+// ApprovalPolicy returns the ApprovalPolicy described by Approvals.
+func (c *Config) ApprovalPolicy() (*ApprovalPolicy, error) {
+	policy := &ApprovalPolicy{Tools: map[string]string{}}
+	for name, mode := range c.Approvals {
+		if name == "*" {
+			policy.Default = mode
+		} else {
+			policy.Tools[name] = mode
+		}
+	}
+	if err := policy.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid approvals: %w", err)
+	}
+	return policy, nil
+}
+
+// NewWorkspace creates the Workspace described by this config.
+func (c *Config) NewWorkspace() (*Workspace, error) {
+	root := c.Root
+	if root == "" {
+		root = "."
+	}
+	ws := NewWorkspace(root)
+	protected := c.protectedPaths()
+	ws.Default.Allow, ws.Default.Deny, ws.Default.Protected = c.Allow, c.Deny, protected
+	if c.Commands != nil {
+		if err := c.Commands.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid command policy: %w", err)
+		}
+		ws.Commands = c.Commands
+	}
+	for name, dir := range c.Roots {
+		sandbox := &Sandbox{Root: dir, Allow: c.Allow, Deny: c.Deny, Protected: protected}
+		if err := ws.AddRoot(name, sandbox); err != nil {
+			return nil, err
+		}
+	}
+
+	// Fail early on roots that do not exist instead of on the first tool call
+	for _, sandbox := range append([]*Sandbox{ws.Default}, slices.Collect(maps.Values(ws.Roots))...) {
+		if _, err := sandbox.RealRoot(); err != nil {
+			return nil, fmt.Errorf("invalid root: %w", err)
+		}
+	}
+	return ws, nil
+}
+
+// protectedPaths returns the absolute paths of the config file (both as given
+// and with symlinks resolved) so that no sandbox lets tools rewrite it.  The
+// file does not have to exist as creating it is just as bad.
+func (c *Config) protectedPaths() (paths []string) {
+	if c.path == "" {
+		return nil
+	}
+	path, err := filepath.Abs(c.path)
+	if err != nil {
+		return nil
+	}
+	if dir, err := filepath.EvalSymlinks(filepath.Dir(path)); err == nil {
+		path = filepath.Join(dir, filepath.Base(path))
+	}
+	paths = append(paths, path)
+	if real, err := filepath.EvalSymlinks(path); err == nil && real != path {
+		paths = append(paths, real)
+	}
+	return paths
+}
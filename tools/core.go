@@ -1,19 +1,109 @@
 package tools
 
-import "path/filepath"
+import "context"
 
+// A Parameter describes a tool parameter (or return value) as a JSON Schema
+// property.  Only the subset of JSON Schema that the major AI platforms
+// understand is supported.
 type Parameter struct {
 	Name        string `json:"name"`
 	Description string `json:"description"`
-	Type        string `json:"type"`
-	Required    bool
+
+	// One of "string", "integer", "number", "boolean", "array" or "object"
+	Type     string `json:"type"`
+	Required bool
+
+	// If non empty the value must be one of these
+	Enum []any `json:"enum,omitempty"`
+
+	// Value used when the parameter is not provided
+	Default any `json:"default,omitempty"`
+
+	// Schema of each item when Type is "array"
+	Items *Parameter `json:"items,omitempty"`
+
+	// Nested properties when Type is "object"
+	Properties []*Parameter `json:"properties,omitempty"`
+
+	// Inclusive bounds for "integer" and "number" values
+	Minimum *float64 `json:"minimum,omitempty"`
+	Maximum *float64 `json:"maximum,omitempty"`
+
+	// Length bounds for strings (characters) and arrays (items)
+	MinLength *int `json:"minLength,omitempty"`
+	MaxLength *int `json:"maxLength,omitempty"`
+
+	// Regular expression string values must match
+	Pattern string `json:"pattern,omitempty"`
 }
 
+// Ptr returns a pointer to v.  Handy for the optional bounds in a Parameter.
+func Ptr[T any](v T) *T {
+	return &v
+}
+
+// Json returns the JSON Schema for this parameter.
 func (p *Parameter) Json() any {
-	return map[string]any{
-		"description": p.Description,
-		"type":        p.Type,
+	out := map[string]any{
+		"type": p.Type,
+	}
+	if p.Description != "" {
+		out["description"] = p.Description
+	}
+	if len(p.Enum) > 0 {
+		out["enum"] = p.Enum
+	}
+	if p.Default != nil {
+		out["default"] = p.Default
+	}
+	if p.Items != nil {
+		out["items"] = p.Items.Json()
+	}
+	if p.Type == "object" && p.Properties != nil {
+		schema := ParametersSchema(p.Properties)
+		out["properties"] = schema["properties"]
+		if required, ok := schema["required"]; ok {
+			out["required"] = required
+		}
+	}
+	if p.Minimum != nil {
+		out["minimum"] = *p.Minimum
+	}
+	if p.Maximum != nil {
+		out["maximum"] = *p.Maximum
+	}
+	minKey, maxKey := "minLength", "maxLength"
+	if p.Type == "array" {
+		minKey, maxKey = "minItems", "maxItems"
+	}
+	if p.MinLength != nil {
+		out[minKey] = *p.MinLength
+	}
+	if p.MaxLength != nil {
+		out[maxKey] = *p.MaxLength
+	}
+	if p.Pattern != "" {
+		out["pattern"] = p.Pattern
 	}
+	return out
+}
+
+// ParametersSchema returns the JSON Schema of an object with the given
+// parameters as its properties.
+func ParametersSchema(params []*Parameter) map[string]any {
+	props := map[string]any{}
+	var required []string
+	for _, param := range params {
+		props[param.Name] = param.Json()
+		if param.Required {
+			required = append(required, param.Name)
+		}
+	}
+	out := map[string]any{"type": "object", "properties": props}
+	if required != nil {
+		out["required"] = required
+	}
+	return out
 }
 
 type Tool interface {
@@ -24,11 +114,69 @@ type Tool interface {
 	Run(args map[string]any) (any, error)
 }
 
+// A ContextTool is a Tool that can be cancelled (or timed out) while running,
+// eg a long running shell command.
+type ContextTool interface {
+	Tool
+	RunContext(ctx context.Context, args map[string]any) (any, error)
+}
+
+// A ReadOnlyTool never changes anything (files, processes, ...).  Tools that
+// do not implement it are treated as mutating.
+type ReadOnlyTool interface {
+	Tool
+	ReadOnly() bool
+}
+
+// IsReadOnly returns true if a tool is tagged as read-only.
+func IsReadOnly(tool Tool) bool {
+	ro, ok := tool.(ReadOnlyTool)
+	return ok && ro.ReadOnly()
+}
+
+// A HunkReviewable tool has every hunk of its changes reviewed by the
+// HunkReviewer in its context (if any) before writing them.
+type HunkReviewable interface {
+	Tool
+	ReviewsHunks() bool
+}
+
+// ReviewsHunks returns true if the tool has its hunks reviewed by the
+// HunkReviewer in ctx.
+func ReviewsHunks(ctx context.Context, tool Tool) bool {
+	reviewable, ok := tool.(HunkReviewable)
+	return ok && reviewable.ReviewsHunks() && HunkReviewerFrom(ctx) != nil
+}
+
+// A Previewer describes what a call would do without doing it (eg the diff
+// write_file would make) so it can be approved first.
+type Previewer interface {
+	Preview(ctx context.Context, args map[string]any) (string, error)
+}
+
 type BaseFileTool struct {
-	ProjectRoot string
+	Workspace *Workspace
+
+	// Hashes of the files read and written in this session (optional)
+	Files *FileTracker
+
+	// Records the prior state of the files changed (optional)
+	Journal *Journal
+}
+
+// Sandbox returns the sandbox a (possibly root prefixed) path belongs to along
+// with the path inside that sandbox.
+func (b *BaseFileTool) Sandbox(path string) (*Sandbox, string) {
+	if b.Workspace == nil {
+		b.Workspace = NewWorkspace(".")
+	}
+	return b.Workspace.Split(path)
 }
 
+// ResolvePath resolves a tool supplied path to an absolute path inside its
+// root.  A *SandboxError is returned if the path escapes the root (via "..",
+// an absolute path or a symlink) or is rejected by the allow/deny globs.
 func (b *BaseFileTool) ResolvePath(path string) (fullpath string, err error) {
-	fullpath, err = filepath.Abs(filepath.Join(b.ProjectRoot, path))
-	return
+	sandbox, relpath := b.Sandbox(path)
+	return sandbox.Resolve(relpath)
 }
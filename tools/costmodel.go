@@ -0,0 +1,144 @@
+package tools
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// A CostModel tunes how EditCosts aligns a patch against a file: the cost of
+// each operation and when a line of the patch counts as matching a line of
+// the file.  Different models break patches in different ways (reindenting,
+// retyping lines slightly differently, ...) so named profiles of these are
+// selectable by the patching tools (see CostProfile).
+type CostModel struct {
+	// Name of the profile (if any) the model came from
+	Name string
+
+	MatchContext         int // InLines[i] matched a context line
+	KeepInputLine        int // InLines[i] kept without the patch acting on it
+	SkipPatchContext     int // A context line that is not in the input was skipped
+	AddPatchLine         int // A '+' line was inserted
+	MatchDelete          int // InLines[i] matched (and was deleted by) a '-' line
+	FuzzyDelete          int // InLines[i] was deleted by a '-' line it does not match
+	SkipPatchDelete      int // A '-' line that is not in the input was skipped
+	SkipInvalidPatchLine int // A line without a ' ', '+' or '-' prefix was skipped
+
+	// Whether '-' lines may delete input lines they do not match and whether
+	// they may be skipped (before the end of the input).  Both are off by
+	// default as either can silently drop part of a change.
+	FuzzyDeletes bool
+	SkipDeletes  bool
+
+	// When a line of the patch matches a line of the input.  Defaults to
+	// ExactEqual.
+	AlmostEqual func(patchLine, inputLine string) bool
+}
+
+// Names of the built in cost profiles
+const (
+	ProfileStrict         = "strict"
+	ProfileLenient        = "lenient"
+	ProfileIndentTolerant = "indent-tolerant"
+)
+
+// DefaultCostModel is the "strict" profile: patch lines must match the file
+// exactly.
+var DefaultCostModel = &CostModel{
+	Name:                 ProfileStrict,
+	SkipPatchContext:     1,
+	AddPatchLine:         1,
+	FuzzyDelete:          2,
+	SkipPatchDelete:      1,
+	SkipInvalidPatchLine: 1,
+	AlmostEqual:          ExactEqual,
+}
+
+var costProfiles = map[string]*CostModel{
+	ProfileStrict: DefaultCostModel,
+
+	// For models that reindent code (tabs vs spaces, dropped nesting levels)
+	ProfileIndentTolerant: DefaultCostModel.With(ProfileIndentTolerant, WhitespaceInsensitiveEqual),
+
+	// For models that retype lines slightly differently (stale comments,
+	// small typos, reordered arguments)
+	ProfileLenient: DefaultCostModel.With(ProfileLenient, AnyEqual(
+		WhitespaceInsensitiveEqual,
+		LevenshteinEqual(0.85),
+		TokenJaccardEqual(0.8),
+	)),
+}
+
+// CostProfileNames returns the names of the built in cost profiles.
+func CostProfileNames() []string {
+	names := make([]string, 0, len(costProfiles))
+	for name := range costProfiles {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// CostProfile returns the named cost profile ("" for the default one).
+func CostProfile(name string) (*CostModel, error) {
+	if name == "" {
+		return DefaultCostModel, nil
+	}
+	model, ok := costProfiles[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown cost profile %q (expected one of %s)", name, strings.Join(CostProfileNames(), ", "))
+	}
+	return model, nil
+}
+
+// With returns a copy of the model with a different name and AlmostEqual.
+func (c *CostModel) With(name string, almostEqual func(patchLine, inputLine string) bool) *CostModel {
+	out := *c
+	out.Name, out.AlmostEqual = name, almostEqual
+	return &out
+}
+
+// ExactEqual matches identical lines only.
+func ExactEqual(a, b string) bool {
+	return a == b
+}
+
+// TrailingWhitespaceEqual matches lines that only differ in trailing
+// whitespace (including stray carriage returns).
+func TrailingWhitespaceEqual(a, b string) bool {
+	return strings.TrimRight(a, " \t\r") == strings.TrimRight(b, " \t\r")
+}
+
+// WhitespaceInsensitiveEqual matches lines that only differ in their
+// indentation or the amount of whitespace between words.
+func WhitespaceInsensitiveEqual(a, b string) bool {
+	return slices.Equal(strings.Fields(a), strings.Fields(b))
+}
+
+// LevenshteinEqual matches lines (ignoring surrounding whitespace) whose
+// LevenshteinRatio is at least threshold.
+func LevenshteinEqual(threshold float64) func(a, b string) bool {
+	return func(a, b string) bool {
+		return a == b || LevenshteinRatio(strings.TrimSpace(a), strings.TrimSpace(b)) >= threshold
+	}
+}
+
+// TokenJaccardEqual matches lines whose TokenJaccard similarity is at least
+// threshold.
+func TokenJaccardEqual(threshold float64) func(a, b string) bool {
+	return func(a, b string) bool {
+		return a == b || TokenJaccard(a, b) >= threshold
+	}
+}
+
+// AnyEqual matches lines matched by any of the given strategies.
+func AnyEqual(strategies ...func(a, b string) bool) func(a, b string) bool {
+	return func(a, b string) bool {
+		for _, equal := range strategies {
+			if equal(a, b) {
+				return true
+			}
+		}
+		return false
+	}
+}
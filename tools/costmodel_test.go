@@ -0,0 +1,68 @@
+package tools
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAlmostEqualStrategies(t *testing.T) {
+	tests := []struct {
+		name     string
+		equal    func(a, b string) bool
+		a, b     string
+		expected bool
+	}{
+		{"exact", ExactEqual, "\treturn x", "\treturn x", true},
+		{"exact indent", ExactEqual, "\treturn x", "    return x", false},
+		{"trailing", TrailingWhitespaceEqual, "return x  ", "return x\r", true},
+		{"trailing leading", TrailingWhitespaceEqual, "  return x", "return x", false},
+		{"whitespace indent", WhitespaceInsensitiveEqual, "\treturn  x", "    return x", true},
+		{"whitespace words", WhitespaceInsensitiveEqual, "return x", "return y", false},
+		{"levenshtein typo", LevenshteinEqual(0.85), "fmt.Println(\"hello world\")", "  fmt.Println(\"helo world\")", true},
+		{"levenshtein different", LevenshteinEqual(0.85), "return nil", "return err", false},
+		{"jaccard reordered", TokenJaccardEqual(0.8), "a b c d e", "e d c b a", true},
+		{"jaccard different", TokenJaccardEqual(0.8), "a b c d e", "a b c x y", false},
+		{"any", AnyEqual(ExactEqual, WhitespaceInsensitiveEqual), " x", "x", true},
+	}
+	for _, tc := range tests {
+		if got := tc.equal(tc.a, tc.b); got != tc.expected {
+			t.Errorf("%s: expected %q ~ %q to be %v", tc.name, tc.a, tc.b, tc.expected)
+		}
+	}
+}
+
+func TestCostProfiles(t *testing.T) {
+	lines := []string{"func main() {", "\tif ok {", "\t\tfmt.Println(\"hello world\")", "\t}", "}"}
+	tests := []struct {
+		name    string
+		profile string
+		hunk    []string
+		status  string
+	}{
+		{"strict exact", ProfileStrict, []string{" \tif ok {", "-\t\tfmt.Println(\"hello world\")", "+\t\tfmt.Println(\"bye\")", " \t}"}, HunkClean},
+		{"strict reindented", ProfileStrict, []string{" if ok {", "-    fmt.Println(\"hello world\")", "+    fmt.Println(\"bye\")", " }"}, HunkFailed},
+		{"indent-tolerant reindented", ProfileIndentTolerant, []string{" if ok {", "-    fmt.Println(\"hello world\")", "+\t\tfmt.Println(\"bye\")", " }"}, HunkFuzzy},
+		{"indent-tolerant typo", ProfileIndentTolerant, []string{" if ok {", "-\t\tfmt.Println(\"helo world\")", "+\t\tfmt.Println(\"bye\")", " }"}, HunkConflicted},
+		{"lenient typo", ProfileLenient, []string{" if ok {", "-\t\tfmt.Println(\"helo world\")", "+\t\tfmt.Println(\"bye\")", " }"}, HunkFuzzy},
+	}
+	for _, tc := range tests {
+		costs, err := CostProfile(tc.profile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hunk := &Hunk{Lines: tc.hunk}
+		trimHunk(hunk)
+		out, result := ApplyHunk(lines, hunk, costs, DefaultMinConfidence)
+		if result.Status != tc.status {
+			t.Errorf("%s: expected %s, got %+v", tc.name, tc.status, result)
+			continue
+		}
+		if (tc.status == HunkClean || tc.status == HunkFuzzy) && !strings.Contains(strings.Join(out, "\n"), "\t\tfmt.Println(\"bye\")\n\t}") {
+			t.Errorf("%s: unexpected output %q", tc.name, out)
+		}
+	}
+
+	if _, err := CostProfile("sloppy"); err == nil {
+		t.Errorf("expected an error for an unknown profile")
+	}
+}
@@ -0,0 +1,354 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Places old_text is looked for at when it does not match exactly
+const maxFuzzyEdits = 3
+
+// Lines of old_text that did not match exactly are matched with the lenient
+// cost profile (so reindented and slightly outdated lines still match)
+var fuzzyEditCosts = costProfiles[ProfileLenient]
+
+type EditFile struct {
+	BaseFileTool
+}
+
+// EditResult describes how one edit of an edit_file call was applied.
+type EditResult struct {
+	// Number of places old_text was replaced at
+	Replacements int `json:"replacements"`
+
+	// Line (in the file as it was before this edit) of the first replacement
+	StartLine int `json:"start_line"`
+
+	// Whether old_text had to be matched approximately and how closely (0-1) it matched
+	Fuzzy      bool    `json:"fuzzy"`
+	Confidence float64 `json:"confidence"`
+}
+
+// EditFileResult is the result of an edit_file call.
+type EditFileResult struct {
+	Path  string        `json:"path"`
+	Hash  string        `json:"hash"`
+	Edits []*EditResult `json:"edits"`
+	Stats DiffStats     `json:"stats"`
+
+	// The changed regions of the file in unified diff form
+	Hunks []string `json:"hunks"`
+}
+
+func (e *EditFile) Name() string {
+	return "edit_file"
+}
+
+func (e *EditFile) Description() string {
+	return `
+	Edits an existing file by replacing snippets of text.   This is preferred over write_file (and diffs) for small changes to a file.
+
+	Each edit in 'edits' replaces 'old_text' with 'new_text'.   'old_text' must match exactly one place in the file (include enough surrounding lines
+	to make it unique) unless 'replace_all' is set in which case every occurrence is replaced.   Edits are applied in order so later edits see the
+	results of earlier ones.
+
+	If 'old_text' does not match exactly (eg it has different indentation or a slightly outdated line) the closest matching block of lines is used as long
+	as it is similar enough - the 'confidence' (0 to 1) of each such fuzzy match is returned.
+
+	Either all edits are applied or (if any edit fails) the file is left untouched and an error explaining the failing edit is returned.
+
+	Returns the changed regions of the file as unified diff hunks along with the new hash of the file.
+	`
+}
+
+func (e *EditFile) Parameters() []*Parameter {
+	return []*Parameter{
+		{
+			Name:        "path",
+			Description: "Path of the file to edit.",
+			Type:        "string",
+			Required:    true,
+		},
+		{
+			Name:        "edits",
+			Description: "List of edits to apply (in order)",
+			Type:        "array",
+			Required:    true,
+			MinLength:   Ptr(1),
+			Items: &Parameter{
+				Type: "object",
+				Properties: []*Parameter{
+					{
+						Name:        "old_text",
+						Description: "Text to replace.  Must match exactly one place in the file unless replace_all is set.",
+						Type:        "string",
+						Required:    true,
+						MinLength:   Ptr(1),
+					},
+					{
+						Name:        "new_text",
+						Description: "Text to replace old_text with",
+						Type:        "string",
+						Required:    true,
+					},
+					{
+						Name:        "replace_all",
+						Description: "Replace every occurrence of old_text instead of requiring a unique match",
+						Type:        "boolean",
+						Default:     false,
+					},
+				},
+			},
+		},
+		{
+			Name:        "expected_hash",
+			Description: "Hash of the file (from read_file) the edits are based on.  If the file has changed since, no edits are made and the current contents are returned instead.",
+			Type:        "string",
+		},
+		{
+			Name:        "fuzzy",
+			Description: "Whether to fall back to approximate matching when old_text does not match exactly",
+			Type:        "boolean",
+			Default:     true,
+		},
+	}
+}
+
+func (e *EditFile) Returns() []*Parameter {
+	return []*Parameter{
+		{
+			Name:        "result",
+			Description: "Error or how each edit was applied along with the changed hunks of the file",
+			Type:        "object",
+		},
+	}
+}
+
+func (e *EditFile) Run(args map[string]any) (any, error) {
+	return e.RunContext(context.Background(), args)
+}
+
+func (e *EditFile) RunContext(ctx context.Context, args map[string]any) (any, error) {
+	path := args["path"].(string)
+	fullpath, err := e.ResolvePath(path)
+	if err != nil {
+		return nil, err
+	}
+	original, err := os.ReadFile(fullpath)
+	if err != nil {
+		return nil, err
+	}
+	// old_text already guards against editing the wrong thing so (unlike
+	// write_file) edits to files that were not read are not warned about
+	if expectedHash, _ := args["expected_hash"].(string); expectedHash != "" {
+		if err := e.CheckWrite(ctx, path, fullpath, expectedHash, original, true); err != nil {
+			return nil, err
+		}
+	}
+	fuzzy := true
+	if val, ok := args["fuzzy"].(bool); ok {
+		fuzzy = val
+	}
+
+	result := &EditFileResult{Path: path}
+	content, edits, err := applyEdits(ctx, string(original), args["edits"].([]any), fuzzy)
+	if err != nil {
+		return nil, err
+	}
+	result.Edits = edits
+
+	tx := e.Journal.Begin(e.Name())
+	trackChange(ctx, tx, path, fullpath)
+	if err := WriteFileAtomic(fullpath, []byte(content), 0644); err != nil {
+		return nil, err
+	}
+	commitChange(ctx, tx)
+	result.Hash = HashContent([]byte(content))
+	e.Files.Record(fullpath, result.Hash)
+
+	diffs := DiffLineSlices(SplitLines(string(original)), SplitLines(content))
+	result.Stats = Stats(diffs)
+	result.Hunks = []string{}
+	for _, hunk := range GroupHunks(diffs, 3) {
+		result.Hunks = append(result.Hunks, hunk.String())
+	}
+	return result, nil
+}
+
+// Preview returns the diff the edits would make.
+func (e *EditFile) Preview(ctx context.Context, args map[string]any) (string, error) {
+	path := args["path"].(string)
+	fullpath, err := e.ResolvePath(path)
+	if err != nil {
+		return "", err
+	}
+	original, err := os.ReadFile(fullpath)
+	if err != nil {
+		return "", err
+	}
+	fuzzy := true
+	if val, ok := args["fuzzy"].(bool); ok {
+		fuzzy = val
+	}
+	content, _, err := applyEdits(context.Background(), string(original), args["edits"].([]any), fuzzy)
+	if err != nil {
+		return "", err
+	}
+	return UnifiedDiff("a/"+path, "b/"+path, string(original), content, 3), nil
+}
+
+// applyEdits applies each of the edits (in order) to content.
+func applyEdits(ctx context.Context, content string, edits []any, fuzzy bool) (string, []*EditResult, error) {
+	var results []*EditResult
+	for i, item := range edits {
+		edit := item.(map[string]any)
+		oldText, _ := edit["old_text"].(string)
+		newText, _ := edit["new_text"].(string)
+		replaceAll, _ := edit["replace_all"].(bool)
+
+		var res *EditResult
+		var err error
+		content, res, err = applyEdit(content, oldText, newText, replaceAll, fuzzy)
+		if err != nil {
+			return "", nil, fmt.Errorf("edit %d failed (no edits were applied): %w", i, err)
+		}
+		if res.Fuzzy {
+			Warn(ctx, "edit %d: old_text did not match exactly - replaced lines %d-%d with %.0f%% confidence",
+				i, res.StartLine, res.StartLine+len(SplitLines(oldText))-1, res.Confidence*100)
+		}
+		results = append(results, res)
+	}
+	return content, results, nil
+}
+
+// applyEdit replaces oldText with newText in content.  oldText must occur
+// exactly once (or at least once with replaceAll).  If it does not occur at
+// all the most similar block of lines is replaced instead (if fuzzy is set).
+func applyEdit(content, oldText, newText string, replaceAll, fuzzy bool) (string, *EditResult, error) {
+	crlf := strings.Contains(content, "\r\n")
+	if crlf {
+		// Models rarely reproduce CRLFs so match (and write) them as the file has them
+		oldText = toCRLF(oldText)
+		newText = toCRLF(newText)
+	}
+
+	count := strings.Count(content, oldText)
+	if count > 1 && !replaceAll {
+		return "", nil, fmt.Errorf("old_text matches %d places (lines %s) - include more surrounding lines to make it unique or set replace_all",
+			count, strings.Join(matchLines(content, oldText), ", "))
+	}
+	if count > 0 {
+		res := &EditResult{
+			Replacements: count,
+			StartLine:    strings.Count(content[:strings.Index(content, oldText)], "\n") + 1,
+			Confidence:   1,
+		}
+		if replaceAll {
+			return strings.ReplaceAll(content, oldText, newText), res, nil
+		}
+		return strings.Replace(content, oldText, newText, 1), res, nil
+	}
+
+	if !fuzzy {
+		return "", nil, fmt.Errorf("old_text was not found in the file")
+	}
+	// Match old_text the way apply_file_diff matches a hunk deleting it
+	lines := SplitLines(content)
+	oldLines := SplitLines(oldText)
+	deletes := make([]string, len(oldLines))
+	for i, line := range oldLines {
+		deletes[i] = "-" + line
+	}
+	match, runnerUp := matchBlock(lines, deletes, oldLines, 0, fuzzyEditCosts, maxFuzzyEdits)
+	if match == nil || match.confidence < DefaultMinConfidence {
+		msg := "old_text was not found in the file"
+		if match != nil {
+			if first, _ := match.span(); first >= 0 {
+				msg += fmt.Sprintf(" (closest match at line %d is only %.0f%% similar)", first+1, match.confidence*100)
+			}
+		}
+		return "", nil, fmt.Errorf("%s - re-read the file and copy old_text exactly", msg)
+	}
+	first, last := match.span()
+	if runnerUp != nil && runnerUp.confidence >= match.confidence {
+		other, _ := runnerUp.span()
+		return "", nil, fmt.Errorf("old_text does not match exactly and is similar to several places (lines %d and %d) - include more surrounding lines",
+			min(first, other)+1, max(first, other)+1)
+	}
+
+	// Lines of the file between the matched ones that old_text does not have
+	// cannot be placed in new_text with any certainty - have them included
+	var skipped []string
+	for _, aligned := range match.alignment {
+		if aligned.Type == KEPT_INPUT_UNAFFECTED && aligned.InputLineNo-1 > first && aligned.InputLineNo-1 < last {
+			skipped = append(skipped, fmt.Sprint(aligned.InputLineNo))
+		}
+	}
+	if len(skipped) > 0 {
+		return "", nil, fmt.Errorf("old_text matches lines %d-%d of the file except for line(s) %s which it leaves out - include them in old_text (and new_text)",
+			first+1, last+1, strings.Join(skipped, ", "))
+	}
+
+	// Replace the matched lines with new_text
+	out := append([]string{}, lines[:first]...)
+	out = append(out, reindent(SplitLines(newText), match.alignment)...)
+	out = append(out, lines[last+1:]...)
+	eol := "\n"
+	if crlf {
+		eol = "\r\n"
+	}
+	newContent := strings.Join(out, eol)
+	if len(out) > 0 && strings.HasSuffix(content, "\n") {
+		newContent += eol
+	}
+	return newContent, &EditResult{Replacements: 1, StartLine: first + 1, Fuzzy: true, Confidence: match.confidence}, nil
+}
+
+// reindent shifts newLines by the indentation the lines of the file have over
+// the lines of old_text they were aligned with (eg when the model dropped a
+// level of indentation).  The shift is taken from the first line that only
+// differs in its indentation.
+func reindent(newLines []string, alignment []AlignedLine) []string {
+	for _, aligned := range alignment {
+		old, matched := aligned.PatchLine, aligned.InputLine
+		trimmed := strings.TrimLeft(old, " \t")
+		if aligned.Type != DELETED_INPUT_MATCHED_PATCH || trimmed == "" || strings.TrimLeft(matched, " \t") != trimmed {
+			continue
+		}
+		oldIndent := old[:len(old)-len(trimmed)]
+		newIndent := matched[:len(matched)-len(trimmed)]
+		extra, found := strings.CutSuffix(newIndent, oldIndent)
+		if !found || extra == "" {
+			return newLines
+		}
+		out := make([]string, len(newLines))
+		for j, line := range newLines {
+			if line != "" {
+				line = extra + line
+			}
+			out[j] = line
+		}
+		return out
+	}
+	return newLines
+}
+
+// matchLines returns the line numbers of (upto 10) occurrences of text.
+func matchLines(content, text string) (out []string) {
+	offset := 0
+	for len(out) < 10 {
+		index := strings.Index(content[offset:], text)
+		if index < 0 {
+			break
+		}
+		out = append(out, fmt.Sprint(strings.Count(content[:offset+index], "\n")+1))
+		offset += index + max(len(text), 1)
+	}
+	return
+}
+
+func toCRLF(text string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(text, "\r\n", "\n"), "\n", "\r\n")
+}
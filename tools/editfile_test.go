@@ -0,0 +1,102 @@
+package tools
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const editSample = `package main
+
+import "fmt"
+
+func main() {
+	fmt.Println("hello")
+	fmt.Println("world")
+}
+
+func helper() {
+	fmt.Println("hello")
+}
+`
+
+func TestEditFile(t *testing.T) {
+	root := t.TempDir()
+	full := filepath.Join(root, "main.go")
+	registry := NewRegistry(NewWorkspace(root))
+	edit := func(edits ...map[string]any) *Envelope {
+		os.WriteFile(full, []byte(editSample), 0644)
+		items := []any{}
+		for _, e := range edits {
+			items = append(items, e)
+		}
+		return registry.Invoke(t.Context(), "edit_file", map[string]any{"path": "main.go", "edits": items})
+	}
+	contents := func() string {
+		data, _ := os.ReadFile(full)
+		return string(data)
+	}
+
+	env := edit(map[string]any{"old_text": `fmt.Println("world")`, "new_text": `fmt.Println("there")`})
+	if !env.Ok || !strings.Contains(contents(), `"there"`) {
+		t.Fatalf("expected a unique exact edit to apply, got %s", env)
+	}
+	result := env.Result.(*EditFileResult)
+	if len(result.Hunks) != 1 || result.Stats != (DiffStats{Added: 1, Removed: 1}) || result.Edits[0].StartLine != 7 || result.Edits[0].Fuzzy {
+		t.Errorf("unexpected edit result: %s", env)
+	}
+
+	env = edit(map[string]any{"old_text": `fmt.Println("hello")`, "new_text": `fmt.Println("hi")`})
+	if env.Ok || !strings.Contains(env.Error.Message, "matches 2 places (lines 6, 11)") {
+		t.Errorf("expected ambiguous edits to fail, got %s", env)
+	}
+
+	env = edit(map[string]any{"old_text": `fmt.Println("hello")`, "new_text": `fmt.Println("hi")`, "replace_all": true})
+	if !env.Ok || strings.Count(contents(), `"hi"`) != 2 || env.Result.(*EditFileResult).Edits[0].Replacements != 2 {
+		t.Errorf("expected replace_all to replace every occurrence, got %s", env)
+	}
+
+	// A failing edit leaves the file untouched even if earlier edits succeeded
+	env = edit(
+		map[string]any{"old_text": `import "fmt"`, "new_text": `import "os"`},
+		map[string]any{"old_text": "no such\nlines here", "new_text": "x"},
+	)
+	if env.Ok || !strings.Contains(env.Error.Message, "edit 1") || contents() != editSample {
+		t.Errorf("expected the edits to be rolled back, got %s", env)
+	}
+
+	// Wrong indentation and a slightly outdated line are matched fuzzily
+	env = edit(map[string]any{
+		"old_text": "fmt.Println(\"helo\")\nfmt.Println(\"world\")",
+		"new_text": "fmt.Println(\"bye\")\nreturn",
+	})
+	if !env.Ok || len(env.Warnings) != 1 {
+		t.Fatalf("expected a fuzzy edit to apply, got %s", env)
+	}
+	if res := env.Result.(*EditFileResult).Edits[0]; !res.Fuzzy || res.Confidence < DefaultMinConfidence || res.Confidence >= 1 || res.StartLine != 6 {
+		t.Errorf("unexpected fuzzy edit result: %+v", res)
+	}
+	if !strings.Contains(contents(), "main() {\n\tfmt.Println(\"bye\")\n\treturn\n}") {
+		t.Errorf("expected the replacement to be reindented, got:\n%s", contents())
+	}
+
+	env = edit(map[string]any{"old_text": `fmt.Println("helo")`, "new_text": "x"})
+	if env.Ok || !strings.Contains(env.Error.Message, "similar to several places (lines 6 and 11)") {
+		t.Errorf("expected an ambiguous fuzzy edit to fail, got %s", env)
+	}
+
+	// Lines of the file old_text leaves out are not moved around but refused
+	env = edit(map[string]any{
+		"old_text": "func main() {\n\tfmt.Println(\"hello\")\n}",
+		"new_text": "func main() {\n\tfmt.Println(\"hi\")\n}",
+	})
+	if env.Ok || !strings.Contains(env.Error.Message, "lines 5-8 of the file except for line(s) 7") || contents() != editSample {
+		t.Errorf("expected an edit missing a middle line to fail, got %s", env)
+	}
+
+	env = edit(map[string]any{"old_text": "func nothing() {\n\tpanic(1)\n}", "new_text": "x"})
+	if env.Ok || !strings.Contains(env.Error.Message, "not found") {
+		t.Errorf("expected dissimilar text to be rejected, got %s", env)
+	}
+}
@@ -1,6 +1,7 @@
 package tools
 
 import (
+	"math"
 	"strings"
 
 	"github.com/panyam/goutils/fn"
@@ -21,7 +22,7 @@ This algorithm serves as a fallback when standard `patch` utilities (even with f
 **Core Technique:**
 The algorithm is based on **dynamic programming**, similar in principle to global sequence alignment algorithms like Needleman-Wunsch. However, it's specialized to understand the semantics of patch file lines (context `' '`, additions `'+'`, deletions `'-'`).
 
-It constructs a 2D table (`costTable`) where `costTable[i][d]` stores the minimum "edit cost" to align the first `i` lines of `InLines` with the first `d` lines of `DiffLines`. A corresponding `opTable[i][d]` stores the optimal operation that leads to this minimum cost.
+It works over a 2D grid where cell `(i, d)` stands for the minimum "edit cost" of aligning `InLines[i:]` with `DiffLines[d:]`, and the optimal operation that leads to this minimum cost.  The grid is never kept whole: costs are computed a row at a time and the operations along the best path are recovered by divide and conquer (Hirschberg's algorithm) so memory stays linear.
 
 **Key Components:**
 
@@ -36,7 +37,7 @@ It constructs a 2D table (`costTable`) where `costTable[i][d]` stores the minimu
     *   Skipping a patch operation (`' '` or `'-'`) if it doesn't match the current `InLines` line: Moderate cost (e.g., 1).
     *   Forcing a delete operation on an `InLines` line even if it doesn't perfectly match the `DiffLines` delete instruction: Moderate to high cost (e.g., 1 or more).
     The specific costs are tunable and dictate the algorithm's preferences when ambiguities arise.
-5.  **Operation Codes (`opTable`)**: The `opTable` stores codes representing the decision made at each step of the alignment. These codes are more descriptive than simple add/delete/change and reflect the patch context:
+5.  **Operation Codes (`EditOp`)**: The alignment is a sequence of codes representing the decision made at each step of the alignment. These codes are more descriptive than simple add/delete/change and reflect the patch context:
     *   `KEPT_INPUT_UNAFFECTED`: An input line was kept as is.
     *   `CONTEXT_MATCHED`: An input line matched a patch context line.
     *   `CONTEXT_SKIPPED_PATCH_OP`: A patch context line was skipped as it didn't match the current input line.
@@ -49,12 +50,12 @@ It constructs a 2D table (`costTable`) where `costTable[i][d]` stores the minimu
 **Workflow:**
 
 1.  **Initialization (`Init`)**:
-    *   Sets up the `costTable`, `opTable`, and `visited` (for memoization) tables.
+    *   Applies the default cost model and `AlmostEqual` and drops empty and file header lines from `DiffLines`.
+2.  **Distance Calculation (`Dist`)**:
     *   Initializes boundary conditions:
         *   If `InLines` are exhausted, remaining `DiffLines` are processed (e.g., `+` lines are added, `' '`/`'-'` lines are skipped, each with a cost).
         *   If `DiffLines` are exhausted, remaining `InLines` are kept (at low/no cost).
-2.  **Distance Calculation (`dist(i, d)`)**:
-    *   Recursively calculates `costTable[i][d]` and `opTable[i][d]`.
+    *   Iteratively calculates the costs of a region of the grid from its last row up, each row from its last column down.  Small regions keep the operation of each of their cells and walk them from their first cell to their last; larger ones are split at their middle row `m` - at the column where the costs of reaching row `m` (computed forwards) plus the costs from row `m` on (computed backwards) are smallest - and both halves are aligned in turn.
     *   For state `(i, d)` (considering `InLines[i]` and `DiffLines[d]`):
         *   It explores several "paths" or choices:
             1.  Keep `InLines[i]` (advancing `i` to `i+1`, `d` stays `d`).
@@ -66,12 +67,11 @@ It constructs a 2D table (`costTable`) where `costTable[i][d]` stores the minimu
                 *   Try to match `InLines[i]` for deletion. If `AlmostEqual`, advance both `i` and `d`.
                 *   If not `AlmostEqual`, consider forcibly deleting `InLines[i]` (advancing `i` and `d`) or skipping the patch delete `DiffLines[d]` (advancing `d`, `i` stays `i`).
         *   The path with the minimum accumulated cost is chosen, and its cost and leading operation are stored.
-    *   Memoization (`visited` table) prevents re-computation of subproblems.
-3.  **Get Final Cost (`Dist`)**: Calls `Init()` then `dist(0,0)` to get the overall minimum cost.
-4.  **Reconstruct Alignment (`GetAlignment`)**:
-    *   Backtracks through the `opTable` from `(0,0)` to `(NI, ND)`.
+    *   The cost of `(0, 0)` is the overall minimum cost and the operations along the way are kept for `GetAlignment`.
+3.  **Reconstruct Alignment (`GetAlignment`)**:
+    *   Walks the operations found by `Dist` from `(0,0)` to `(NI, ND)`.
     *   Generates a sequence of `AlignedLine` structs, each describing an operation (using the detailed op codes), the involved input line (if any), and the involved patch line (if any). This sequence provides the rich, annotated diff.
-5.  **Apply Patch (`ApplyPatchToInput`)**:
+4.  **Apply Patch (`ApplyPatchToInput`)**:
     *   Uses the result of `GetAlignment()`.
     *   Constructs a new list of strings by applying the operations: keeping lines, adding lines from the patch, and omitting deleted lines. This produces the "patched file" content.
 
@@ -83,43 +83,32 @@ It constructs a 2D table (`costTable`) where `costTable[i][d]` stores the minimu
 **Considerations for Future Development:**
 *   **Cost Tuning**: The specific cost values are critical. Experimentation may be needed to achieve the most intuitive alignments for various scenarios.
 *   **Tie-Breaking**: The logic for choosing an operation when multiple paths yield the same minimum cost can influence the output. Current tie-breaking generally prefers applying a patch operation over simply keeping an input line if costs are equal.
-*   **`GetAlignment` Reconstruction**: The logic to reconstruct the `AlignedLine` sequence from the `opTable` needs to be robust, especially for "skip patch op" scenarios, to accurately reflect the alignment decisions for display purposes.
-*   **Performance**: For very large files or very long patch hunks, the `O(NI * ND)` complexity of the DP algorithm could be a concern, though it's standard for such problems.
+*   **`GetAlignment` Reconstruction**: The logic to reconstruct the `AlignedLine` sequence from the operations needs to be robust, especially for "skip patch op" scenarios, to accurately reflect the alignment decisions for display purposes.
+*   **Performance**: The DP is `O(NI * ND)` in time (about three times that for inputs too large to keep every operation of) but only `O(NI + ND)` in memory.  It should still only be run against the window of a file a hunk applies to (see `ApplyHunk`) rather than against whole large files.
 
 ---
 */
 
-type EditOp int
-
-const (
-	costMatchContext         = 0
-	costKeepInputLine        = 0
-	costSkipPatchContext     = 1
-	costAddPatchLine         = 1
-	costMatchDelete          = 0
-	costFuzzyDelete          = 2
-	costSkipPatchDelete      = 1
-	costSkipInvalidPatchLine = 1
-)
+type EditOp uint8
 
 const (
 	NOOP EditOp = iota // General placeholder, or could mean "skip patch line, keep input line pointer"
 
 	// Operations related to InLines
-	KEPT_INPUT_UNAFFECTED = 10 // InLines[i] kept, no specific patch op action on it here
+	KEPT_INPUT_UNAFFECTED EditOp = 10 // InLines[i] kept, no specific patch op action on it here
 	// (e.g., patch lines exhausted, or skipping a patch op)
 
 	// Operations driven by matching patch context lines (' ')
-	CONTEXT_MATCHED          = 20 // InLines[i] matched DiffLines[d] (context)
-	CONTEXT_SKIPPED_PATCH_OP = 21 // InLines[i] kept, DiffLines[d] (context) didn't match InLines[i] and was skipped
+	CONTEXT_MATCHED          EditOp = 20 // InLines[i] matched DiffLines[d] (context)
+	CONTEXT_SKIPPED_PATCH_OP EditOp = 21 // InLines[i] kept, DiffLines[d] (context) didn't match InLines[i] and was skipped
 
 	// Operations driven by patch delete lines ('-')
-	DELETED_INPUT_MATCHED_PATCH   = 30 // InLines[i] matched DiffLines[d] (delete op) and is considered deleted
-	DELETED_INPUT_UNMATCHED_PATCH = 31 // InLines[i] did NOT match DiffLines[d] (delete op), but InLines[i] is still considered deleted (forced delete)
-	DELETE_SKIPPED_PATCH_OP       = 32 // InLines[i] kept, DiffLines[d] (delete op) didn't match InLines[i] and was skipped
+	DELETED_INPUT_MATCHED_PATCH   EditOp = 30 // InLines[i] matched DiffLines[d] (delete op) and is considered deleted
+	DELETED_INPUT_UNMATCHED_PATCH EditOp = 31 // InLines[i] did NOT match DiffLines[d] (delete op), but InLines[i] is still considered deleted (forced delete)
+	DELETE_SKIPPED_PATCH_OP       EditOp = 32 // InLines[i] kept, DiffLines[d] (delete op) didn't match InLines[i] and was skipped
 
 	// Operations driven by patch add lines ('+')
-	ADDED_FROM_PATCH = 40 // DiffLines[d] (add op) is inserted
+	ADDED_FROM_PATCH EditOp = 40 // DiffLines[d] (add op) is inserted
 
 	// Could add more if needed, e.g., for replace operations if you extend patch parsing
 )
@@ -145,104 +134,278 @@ type EditCosts struct {
 	InLines   []string
 	DiffLines []string
 	NI, ND    int
-	costTable [][]int
-	opTable   [][]EditOp
-	visited   [][]bool
+
+	// The operations along the best alignment (see Dist), the table reused
+	// by alignTable and whether Init has been called
+	ops   []EditOp
+	table []EditOp
+	ready bool
+
+	// Costs of each operation - defaults to DefaultCostModel
+	Costs *CostModel
 
 	// A helper to Checks if two strings are "almost" equal
-	// we will use this for fuzzy similarity instead of absolute equality
+	// we will use this for fuzzy similarity instead of absolute equality.
+	// Defaults to Costs.AlmostEqual
 	AlmostEqual func(s1, s2 string) bool
 }
 
 func (e *EditCosts) Init() *EditCosts {
+	if e.Costs == nil {
+		e.Costs = DefaultCostModel
+	}
 	// only keep non empty diff lines
 	e.DiffLines = fn.Filter(e.DiffLines, func(s string) bool {
 		return len(s) > 0 && !strings.HasPrefix(s, "---") && !strings.HasPrefix(s, "+++")
 	})
 	e.NI = len(e.InLines)
 	e.ND = len(e.DiffLines)
+	e.ops = nil
+	e.ready = true
+
+	if e.AlmostEqual == nil {
+		e.AlmostEqual = e.Costs.AlmostEqual
+	}
+	if e.AlmostEqual == nil {
+		e.AlmostEqual = ExactEqual
+	}
+	return e
+}
+
+// Regions of the grid with at most this many cells are aligned with a table
+// of their operations.  Larger ones are split in two (see align).
+var maxAlignTableCells = 1 << 18
+
+// Stands in for the cost of moves that are not possible.  Costs are clamped
+// to it so they cannot overflow.
+const infCost = math.MaxInt32 / 4
 
-	e.costTable = make([][]int, e.NI+1)
-	e.opTable = make([][]EditOp, e.NI+1)
-	e.visited = make([][]bool, e.NI+1)
-	for i := range e.NI + 1 {
-		e.costTable[i] = make([]int, e.ND+1)
-		e.opTable[i] = make([]EditOp, e.ND+1)
-		e.visited[i] = make([]bool, e.ND+1)
+// Dist aligns InLines with DiffLines, keeping the operations along the way
+// for GetAlignment, and returns the minimum cost of doing so.  Memory is
+// linear in the number of lines (see align).  Init is called first unless it
+// already has been.
+func (e *EditCosts) Dist() int {
+	if !e.ready {
+		e.Init()
 	}
+	e.ops = make([]EditOp, 0, e.NI+e.ND)
+	return e.align(0, 0, e.NI, e.ND)
+}
 
-	// Base case: both sequences exhausted
-	e.costTable[e.NI][e.ND] = 0
-	e.opTable[e.NI][e.ND] = NOOP // Or a specific "END" op
-	e.visited[e.NI][e.ND] = true
-
-	// Case: DiffLines exhausted, InLines remain
-	// These are input lines that the patch didn't touch further.
-	for i := e.NI - 1; i >= 0; i-- {
-		e.costTable[i][e.ND] = 0 + e.costTable[i+1][e.ND] // Cost 0 to keep an input line
-		e.opTable[i][e.ND] = KEPT_INPUT_UNAFFECTED
-		e.visited[i][e.ND] = true
+// align appends the operations of the cheapest path from cell (i0, d0) to
+// cell (i1, d1) to e.ops and returns its cost.  Small regions are solved
+// directly.  Others are split at their middle row at the column the cheapest
+// path crosses it (Hirschberg's algorithm) so only a few rows of costs are
+// ever kept.
+func (e *EditCosts) align(i0, d0, i1, d1 int) int {
+	if i1-i0 < 2 || (i1-i0+1)*(d1-d0+1) <= maxAlignTableCells {
+		return e.alignTable(i0, d0, i1, d1)
 	}
+	m := (i0 + i1) / 2
+	to, from := e.costsTo(i0, d0, m, d1), e.costsFrom(m, d0, i1, d1)
+	split := 0
+	for d := range to {
+		// Prefer later columns on ties as the path through a single region
+		// consumes patch lines as early as it can
+		if min(to[d]+from[d], infCost) <= min(to[split]+from[split], infCost) {
+			split = d
+		}
+	}
+	return e.align(i0, d0, m, d0+split) + e.align(m, d0+split, i1, d1)
+}
 
-	// Case: InLines exhausted, DiffLines remain
-	// These are patch operations that have no corresponding input lines.
-	for d := e.ND - 1; d >= 0; d-- {
-		costForThisDiffLine := 1 // Default cost for a remaining patch line (e.g. to skip it)
-		op := NOOP               // Placeholder, should be more specific
-
-		switch e.DiffLines[d][0] {
-		case '+':
-			// This is an add operation from the patch; it can still be "applied"
-			costForThisDiffLine = 1 // Cost of an insertion
-			op = ADDED_FROM_PATCH
-		case '-':
-			// A delete op with no input line to delete from; skip it
-			costForThisDiffLine = 1      // Cost of skipping a patch delete op
-			op = DELETE_SKIPPED_PATCH_OP // Or a more general SKIP_PATCH_OP
-		case ' ':
-			// A context op with no input line to match; skip it
-			costForThisDiffLine = 1       // Cost of skipping a patch context op
-			op = CONTEXT_SKIPPED_PATCH_OP // Or a more general SKIP_PATCH_OP
+// alignTable aligns a region by keeping the best operation of each of its
+// cells and walking them from (i0, d0).
+func (e *EditCosts) alignTable(i0, d0, i1, d1 int) int {
+	cols := d1 - d0 + 1
+	if cells := (i1 - i0 + 1) * cols; cap(e.table) < cells {
+		e.table = make([]EditOp, cells)
+	}
+	table := e.table[:(i1-i0+1)*cols]
+	next, curr := e.lastRow(i0, d0, i1, d1, table[(i1-i0)*cols:]), make([]int, cols)
+	for i := i1 - 1; i >= i0; i-- {
+		ops := table[(i-i0)*cols : (i-i0+1)*cols]
+		e.fillRow(i, d0, d1, next, curr, ops)
+		next, curr = curr, next
+	}
+
+	i, d := i0, d0
+	for i < i1 || d < d1 {
+		op := table[(i-i0)*cols+d-d0]
+		e.ops = append(e.ops, op)
+		switch op {
+		case KEPT_INPUT_UNAFFECTED:
+			i++
+		case CONTEXT_MATCHED, DELETED_INPUT_MATCHED_PATCH, DELETED_INPUT_UNMATCHED_PATCH:
+			i++
+			d++
 		default:
-			// Invalid patch line marker
-			costForThisDiffLine = 1 // Cost to skip invalid line
-			op = NOOP               // Or SKIP_INVALID_PATCH_OP
+			d++
 		}
-		e.costTable[e.NI][d] = costForThisDiffLine + e.costTable[e.NI][d+1]
-		e.opTable[e.NI][d] = op
-		e.visited[e.NI][d] = true
 	}
+	return next[0]
+}
 
-	if e.AlmostEqual == nil {
-		// default AlmostEqual to a space trimmed equality
-		e.AlmostEqual = func(s1, s2 string) bool { return strings.TrimSpace(s1) == strings.TrimSpace(s2) }
-		e.AlmostEqual = func(s1, s2 string) bool { return s1 == s2 }
+// costsTo returns the minimum costs of reaching each cell (m, d0..d1) from
+// (i0, d0), computed forwards a row at a time.
+func (e *EditCosts) costsTo(i0, d0, m, d1 int) []int {
+	cols := d1 - d0 + 1
+	costs := make([]int, cols) // of reaching each cell of row i (row i-1 until updated)
+	both := make([]int, cols)  // of the diagonal move out of each cell of the row above
+	for i := i0; i <= m; i++ {
+		// diag is the cost of reaching the current cell diagonally and skip
+		// of the move right out of the previous cell
+		diag, skip := infCost, infCost
+		for c := range cols {
+			cost := infCost
+			if i == i0 && c == 0 {
+				cost = 0
+			} else if i > i0 {
+				cost = min(costs[c]+e.Costs.KeepInputLine, diag)
+			}
+			if c > 0 {
+				cost = min(cost, costs[c-1]+skip)
+			}
+			if i > i0 {
+				diag = costs[c] + both[c]
+			}
+			both[c], skip = e.moves(i, d0+c)
+			costs[c] = min(cost, infCost)
+		}
 	}
-	return e
+	return costs
 }
 
-func (e *EditCosts) Dist() int {
-	return e.Init().dist(0, 0)
+// costsFrom returns the minimum costs of reaching (i1, d1) from each cell
+// (m, d0..d1), computed backwards a row at a time.
+func (e *EditCosts) costsFrom(m, d0, i1, d1 int) []int {
+	next, curr := e.lastRow(m, d0, i1, d1, nil), make([]int, d1-d0+1)
+	for i := i1 - 1; i >= m; i-- {
+		e.fillRow(i, d0, d1, next, curr, nil)
+		next, curr = curr, next
+	}
+	return next
+}
+
+// lastRow returns the costs of reaching (i1, d1) from each cell of the last
+// row of a region.  Its operations are stored in ops unless it is nil.
+func (e *EditCosts) lastRow(i0, d0, i1, d1 int, ops []EditOp) []int {
+	costs := make([]int, d1-d0+1)
+	for d := d1 - 1; d >= d0; d-- {
+		cost, op := e.step(i1, d, infCost, infCost, costs[d+1-d0])
+		costs[d-d0] = min(cost, infCost)
+		if ops != nil {
+			ops[d-d0] = op
+		}
+	}
+	return costs
+}
+
+// fillRow computes the costs (and operations unless ops is nil) of row i of
+// a region ending at column d1 given those of row i+1 in next.
+func (e *EditCosts) fillRow(i, d0, d1 int, next, curr []int, ops []EditOp) {
+	for d := d1; d >= d0; d-- {
+		both, skip := infCost, infCost
+		if d < d1 {
+			both, skip = next[d+1-d0], curr[d+1-d0]
+		}
+		cost, op := e.step(i, d, next[d-d0], both, skip)
+		curr[d-d0] = min(cost, infCost)
+		if ops != nil {
+			ops[d-d0] = op
+		}
+	}
 }
 
-func (e *EditCosts) dist(i, d int) int {
-	if e.visited[i][d] {
-		return e.costTable[i][d]
+// step picks the cheapest operation for aligning InLines[i:] with
+// DiffLines[d:] like bestOp but also for cells past the end of either.
+// Moves leaving the region being aligned cost infCost.
+func (e *EditCosts) step(i, d, keep, both, skip int) (int, EditOp) {
+	switch {
+	case i == e.NI && d == e.ND:
+		return 0, NOOP
+	case i == e.NI:
+		// InLines exhausted, DiffLines remain
+		cost, op := e.exhaustedOp(d)
+		return cost + skip, op
+	case d == e.ND:
+		// DiffLines exhausted, InLines remain.  These are input lines that
+		// the patch didn't touch further.
+		return e.Costs.KeepInputLine + keep, KEPT_INPUT_UNAFFECTED
 	}
-	e.visited[i][d] = true
+	return e.bestOp(i, d, keep, both, skip)
+}
 
-	currentBestOp := NOOP
+// exhaustedOp returns the cost and operation of DiffLines[d] once InLines
+// are exhausted.  These are patch operations that have no corresponding
+// input lines.
+func (e *EditCosts) exhaustedOp(d int) (int, EditOp) {
+	switch e.DiffLines[d][0] {
+	case '+':
+		// This is an add operation from the patch; it can still be "applied"
+		return e.Costs.AddPatchLine, ADDED_FROM_PATCH
+	case '-':
+		// A delete op with no input line to delete from; skip it
+		return e.Costs.SkipPatchDelete, DELETE_SKIPPED_PATCH_OP
+	case ' ':
+		// A context op with no input line to match; skip it
+		return e.Costs.SkipPatchContext, CONTEXT_SKIPPED_PATCH_OP
+	default:
+		// Invalid patch line marker
+		return e.Costs.SkipInvalidPatchLine, NOOP
+	}
+}
 
+// moves returns the costs of the moves out of cell (i, d) that step and
+// bestOp choose between besides keeping InLines[i] (which always costs
+// KeepInputLine): consuming both lines (to (i+1, d+1)) and consuming
+// DiffLines[d] alone (to (i, d+1)).  Moves that are not possible cost
+// infCost.
+func (e *EditCosts) moves(i, d int) (both, skip int) {
+	both, skip = infCost, infCost
+	if d == e.ND {
+		return
+	}
+	if i == e.NI {
+		skip, _ = e.exhaustedOp(d)
+		return
+	}
+	diffline := e.DiffLines[d]
+	switch diffline[0] {
+	case ' ':
+		if e.AlmostEqual(diffline[1:], e.InLines[i]) {
+			both = e.Costs.MatchContext
+		} else {
+			skip = e.Costs.SkipPatchContext
+		}
+	case '+':
+		skip = e.Costs.AddPatchLine
+	case '-':
+		if e.AlmostEqual(diffline[1:], e.InLines[i]) {
+			both = e.Costs.MatchDelete
+		} else if e.Costs.FuzzyDeletes {
+			both = e.Costs.FuzzyDelete
+		}
+		if e.Costs.SkipDeletes {
+			skip = e.Costs.SkipPatchDelete
+		}
+	default:
+		skip = e.Costs.SkipInvalidPatchLine
+	}
+	return
+}
+
+// bestOp picks the cheapest operation for aligning InLines[i:] with
+// DiffLines[d:] given the costs of the cells it can move to: keep is the cost
+// of (i+1, d), both of (i+1, d+1) and skip of (i, d+1).
+func (e *EditCosts) bestOp(i, d, keep, both, skip int) (int, EditOp) {
 	// Option 1: Keep InLines[i] and advance i (patch doesn't act on this InLines[i])
 	// This is always a possibility, representing InLines[i] as an "original" line
 	// that current DiffLines[d] doesn't consume.
-	// Cost: Cost of keeping the input line + recurse.
 	// This acts as a baseline or a way to skip over an input line if the patch line is better matched later.
 	// Path A: Consume InLines[i] as KEPT_INPUT_UNAFFECTED
-	costA := costKeepInputLine + e.dist(i+1, d)
-	currentBestCost := costA
-	currentBestOp = KEPT_INPUT_UNAFFECTED
-	// if currentBestCost == -1 || costA < currentBestCost { }
+	currentBestCost := e.Costs.KeepInputLine + keep
+	var currentBestOp EditOp = KEPT_INPUT_UNAFFECTED
 
 	inline := e.InLines[i]
 	diffline := e.DiffLines[d]
@@ -251,7 +414,7 @@ func (e *EditCosts) dist(i, d int) int {
 	if diffline[0] == ' ' { // Patch context line
 		// Path B: Try to match InLines[i] with this context line DiffLines[d]
 		if e.AlmostEqual(dlContent, inline) {
-			costB := costMatchContext + e.dist(i+1, d+1)
+			costB := e.Costs.MatchContext + both
 			if costB <= currentBestCost {
 				currentBestCost = costB
 				currentBestOp = CONTEXT_MATCHED
@@ -259,8 +422,8 @@ func (e *EditCosts) dist(i, d int) int {
 		} else {
 			// Context line doesn't match InLines[i].
 			// Option B.1: Skip this patch context line and try to match InLines[i] with DiffLines[d+1].
-			// Cost: Cost of skipping a patch context line + recurse.
-			costB1 := costSkipPatchContext + e.dist(i, d+1)
+			// Cost: Cost of skipping a patch context line + cost of the rest of the alignment.
+			costB1 := e.Costs.SkipPatchContext + skip
 			if costB1 < currentBestCost {
 				currentBestCost = costB1
 				currentBestOp = CONTEXT_SKIPPED_PATCH_OP // Implies InLines[i] is still available for next DiffLine
@@ -270,8 +433,8 @@ func (e *EditCosts) dist(i, d int) int {
 		}
 	} else if diffline[0] == '+' { // Patch add line
 		// Path C: Add DiffLines[d] from the patch. InLines[i] is not consumed by this operation.
-		// Cost: Cost of adding a patch line + recurse.
-		costC := costAddPatchLine + e.dist(i, d+1)
+		// Cost: Cost of adding a patch line + cost of the rest of the alignment.
+		costC := e.Costs.AddPatchLine + skip
 		if costC <= currentBestCost {
 			currentBestCost = costC
 			currentBestOp = ADDED_FROM_PATCH
@@ -280,18 +443,18 @@ func (e *EditCosts) dist(i, d int) int {
 		// Path D: Try to apply this delete operation to InLines[i]
 		if e.AlmostEqual(dlContent, inline) {
 			// Lines match for deletion.
-			// Cost: Cost of a matched delete + recurse.
-			costD1 := costMatchDelete + e.dist(i+1, d+1)
+			// Cost: Cost of a matched delete + cost of the rest of the alignment.
+			costD1 := e.Costs.MatchDelete + both
 			// Prefer DELETED_INPUT_MATCHED_PATCH if cost is <= current (which might now be cost_skip_patch_del)
 			if costD1 <= currentBestCost {
 				currentBestCost = costD1
 				currentBestOp = DELETED_INPUT_MATCHED_PATCH
 			}
-		} else if false {
+		} else if e.Costs.FuzzyDeletes {
 			// Lines do NOT match, but patch wants to delete something.
 			// Option D.2: Force delete InLines[i] anyway (fuzzy delete).
-			// Cost: Cost of a fuzzy/unmatched delete + recurse.
-			costD2 := costFuzzyDelete + e.dist(i+1, d+1)
+			// Cost: Cost of a fuzzy/unmatched delete + cost of the rest of the alignment.
+			costD2 := e.Costs.FuzzyDelete + both
 			// Prefer DELETED_INPUT_UNMATCHED_PATCH if cost is <= current
 			if costD2 <= currentBestCost {
 				currentBestCost = costD2
@@ -299,11 +462,11 @@ func (e *EditCosts) dist(i, d int) int {
 			}
 		}
 
-		if false {
+		if e.Costs.SkipDeletes {
 			// Option E: Skip this patch delete operation. InLines[i] is not consumed by *this* delete op.
 			// Try to match InLines[i] with DiffLines[d+1] or consider InLines[i] as KEPT_INPUT_UNAFFECTED.
-			// Cost: Cost of skipping a patch delete operation + recurse.
-			costE := costSkipPatchDelete + e.dist(i, d+1)
+			// Cost: Cost of skipping a patch delete operation + cost of the rest of the alignment.
+			costE := e.Costs.SkipPatchDelete + skip
 			if costE < currentBestCost {
 				currentBestCost = costE
 				currentBestOp = DELETE_SKIPPED_PATCH_OP // Implies InLines[i] is still available
@@ -311,38 +474,27 @@ func (e *EditCosts) dist(i, d int) int {
 		}
 	} else { // Invalid patch line marker
 		// Path F: Skip this invalid patch line. InLines[i] is not consumed.
-		// Cost: Cost of skipping an invalid patch line + recurse.
-		costF := costSkipInvalidPatchLine + e.dist(i, d+1)
+		// Cost: Cost of skipping an invalid patch line + cost of the rest of the alignment.
+		costF := e.Costs.SkipInvalidPatchLine + skip
 		if costF < currentBestCost {
 			currentBestCost = costF
 			currentBestOp = NOOP // Or a more specific SKIP_INVALID_PATCH_OP
 		}
 	}
 
-	e.costTable[i][d] = currentBestCost
-	e.opTable[i][d] = currentBestOp
-	return e.costTable[i][d]
+	return currentBestCost, currentBestOp
 }
 
+// GetAlignment describes each operation along the best alignment found by
+// Dist (which is called first if it has not been).
 func (e *EditCosts) GetAlignment() []AlignedLine {
-	alignment := make([]AlignedLine, 0)
+	if e.ops == nil {
+		e.Dist()
+	}
+	alignment := make([]AlignedLine, 0, len(e.ops))
 	i, d := 0, 0
 
-	for i < e.NI || d < e.ND {
-		if i >= e.NI && d >= e.ND { // Both exhausted, should be handled by loop condition
-			break
-		}
-
-		op := e.opTable[i][d] // Get pre-computed op from the table
-
-		// Handle boundary conditions where one index is already at the end
-		if i >= e.NI { // InLines exhausted, only DiffLines remain
-			op = e.opTable[e.NI][d] // Use boundary condition op
-		} else if d >= e.ND { // DiffLines exhausted, only InLines remain
-			op = e.opTable[i][e.ND] // Use boundary condition op
-		}
-		// else op = e.opTable[i][d] // This was already done
-
+	for _, op := range e.ops {
 		var currentAlignedLine AlignedLine
 		currentAlignedLine.Type = op
 
@@ -365,7 +517,7 @@ func (e *EditCosts) GetAlignment() []AlignedLine {
 			// with the same 'i' but 'd+1'.
 			// However, our DP state assumes InLines[i] is available for the *next* DiffLine,
 			// so if we record this skip, we should probably show InLines[i] first as KEPT
-			// if opTable[i][d+1] indicates that. This reconstruction gets tricky.
+			// if the next op indicates that. This reconstruction gets tricky.
 
 			// Simpler: if this op means only 'd' advances, just mark the patch line.
 			// The 'i' will be handled by subsequent ops.
@@ -389,39 +541,49 @@ func (e *EditCosts) GetAlignment() []AlignedLine {
 			currentAlignedLine.PatchLineNo = d + 1
 			currentAlignedLine.InputLineNo = i + 1
 			d++
-		case NOOP: // Could be an invalid patch line skipped, or end of alignment
-			if d < e.ND { // If it's skipping an invalid patch line
-				currentAlignedLine.PatchLine = e.DiffLines[d] // Show the raw invalid line
-				currentAlignedLine.PatchLineNo = d + 1
-				d++
-			} else if i < e.NI { // Should be KEPT_INPUT_UNAFFECTED if only InLines left
-				// This case might indicate an issue or end of useful alignment.
-				// For safety, break or handle as KEPT_INPUT_UNAFFECTED if i is valid.
-				currentAlignedLine.InputLine = e.InLines[i]
-				currentAlignedLine.InputLineNo = i + 1
-				currentAlignedLine.Type = KEPT_INPUT_UNAFFECTED // Override
-				i++
-			} else { // Both i and d are at NI, ND
-				// This is the actual end, opTable[NI][ND] should be NOOP
-				if i == e.NI && d == e.ND && e.opTable[i][d] == NOOP {
-					// Normal termination
-				} else {
-					// Log error or handle unexpected state
-				}
-				goto endLoop // Exit loop
-			}
+		case NOOP: // An invalid patch line was skipped
+			currentAlignedLine.PatchLine = e.DiffLines[d] // Show the raw invalid line
+			currentAlignedLine.PatchLineNo = d + 1
+			d++
 
 		default:
-			// Should not happen if opTable is filled correctly
+			// Should not happen if the ops were found by Dist
 			// Log error or panic
 			panic("unknown op code during reconstruction")
 		}
 		alignment = append(alignment, currentAlignedLine)
 	}
-endLoop:
 	return alignment
 }
 
+// Confidence scores (from 0 to 1) how well the patch fit the input once
+// aligned (see Dist): the share of the patch's context and delete lines that
+// were found in the input, each scored by LineSimilarity (as AlmostEqual may
+// have matched it approximately).  Delete lines weigh double since deleting
+// the wrong line does more harm than misplacing one.  A patch with nothing to
+// match (only additions) has a confidence of 1.
+func (e *EditCosts) Confidence() float64 {
+	var score, total float64
+	for _, aligned := range e.GetAlignment() {
+		switch aligned.Type {
+		case CONTEXT_MATCHED:
+			score += LineSimilarity(aligned.PatchLine, aligned.InputLine)
+			total++
+		case DELETED_INPUT_MATCHED_PATCH:
+			score += 2 * LineSimilarity(aligned.PatchLine, aligned.InputLine)
+			total += 2
+		case CONTEXT_SKIPPED_PATCH_OP:
+			total++
+		case DELETED_INPUT_UNMATCHED_PATCH, DELETE_SKIPPED_PATCH_OP:
+			total += 2
+		}
+	}
+	if total == 0 {
+		return 1
+	}
+	return score / total
+}
+
 // ApplyPatchToInput takes the original input lines and patch diff lines,
 // performs the alignment, and returns the lines that would result from
 // applying the patch operations.
@@ -437,7 +599,7 @@ func (e *EditCosts) ApplyPatchToInput() []string {
 	// (No, GetAlignment should assume tables are ready from a prior Dist() call)
 	// The caller of ApplyPatchToInput should do:
 	// ec := newEditCosts(inLines, diffLines)
-	// ec.Dist() // This calculates costs and fills opTable
+	// ec.Dist() // This calculates costs and finds the ops
 	// outputLines := ec.ApplyPatchToInput()
 
 	alignment := e.GetAlignment()
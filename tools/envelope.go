@@ -0,0 +1,154 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sync"
+	"time"
+)
+
+// Error codes reported in a ToolError
+const (
+	ErrCodeUnknownTool      = "unknown_tool"
+	ErrCodeInvalidArguments = "invalid_arguments"
+	ErrCodeSandbox          = "sandbox_violation"
+	ErrCodeNotFound         = "not_found"
+	ErrCodeStaleWrite       = "stale_write"
+	ErrCodePatchFailed      = "patch_failed"
+	ErrCodePolicyDenied     = "policy_denied"
+	ErrCodeNotApproved      = "not_approved"
+	ErrCodeCancelled        = "cancelled"
+	ErrCodeTimeout          = "timeout"
+	ErrCodePanic            = "panic"
+	ErrCodeToolError        = "tool_error"
+)
+
+// ErrToolPanicked is returned (wrapped) when a tool panics.
+var ErrToolPanicked = errors.New("tool panicked")
+
+// A ToolError is the JSON friendly form of an error returned by a tool.
+type ToolError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+
+	// Extra machine readable information, eg the violations of a ValidationError
+	Details any `json:"details,omitempty"`
+}
+
+// An Envelope wraps the outcome of every tool call so callers (and the AI on
+// the other end) always get the same parseable shape - success or not.
+// Result is always present (null when the call failed).
+type Envelope struct {
+	Ok         bool       `json:"ok"`
+	Result     any        `json:"result"`
+	Error      *ToolError `json:"error,omitempty"`
+	Warnings   []string   `json:"warnings,omitempty"`
+	DurationMs int64      `json:"duration_ms"`
+}
+
+// NewToolError classifies an error returned by a tool call.
+func NewToolError(err error) *ToolError {
+	out := &ToolError{Code: ErrCodeToolError, Message: err.Error()}
+	var verr *ValidationError
+	var serr *SandboxError
+	var stale *StaleWriteError
+	var perr *PatchError
+	var polerr *PolicyError
+	var aerr *ApprovalError
+	switch {
+	case errors.As(err, &verr):
+		out.Code = ErrCodeInvalidArguments
+		out.Details = verr.Violations
+	case errors.As(err, &serr):
+		out.Code = ErrCodeSandbox
+		out.Details = map[string]any{"path": serr.Path, "rule": serr.Rule}
+	case errors.As(err, &stale):
+		out.Code = ErrCodeStaleWrite
+		out.Details = stale
+	case errors.As(err, &perr):
+		out.Code = ErrCodePatchFailed
+		out.Details = perr.Result
+	case errors.As(err, &polerr):
+		out.Code = ErrCodePolicyDenied
+		out.Details = polerr
+	case errors.As(err, &aerr):
+		out.Code = ErrCodeNotApproved
+		out.Details = aerr
+	case errors.Is(err, ErrUnknownTool):
+		out.Code = ErrCodeUnknownTool
+	case errors.Is(err, ErrToolPanicked):
+		out.Code = ErrCodePanic
+	case errors.Is(err, context.DeadlineExceeded):
+		out.Code = ErrCodeTimeout
+	case errors.Is(err, context.Canceled):
+		out.Code = ErrCodeCancelled
+	case errors.Is(err, fs.ErrNotExist), errors.Is(err, ErrUnknownProcess):
+		out.Code = ErrCodeNotFound
+	}
+	return out
+}
+
+// String returns the JSON encoding of the envelope as handed back to the AI.
+func (e *Envelope) String() string {
+	out, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		// Results that cannot be encoded are reported in their string form
+		fallback := *e
+		fallback.Result = fmt.Sprintf("%v", e.Result)
+		out, _ = json.MarshalIndent(&fallback, "", "  ")
+	}
+	return string(out)
+}
+
+// Invoke runs a tool (see CallContext) and wraps the outcome in an Envelope
+// along with any warnings raised during the call.
+func (r *Registry) Invoke(ctx context.Context, name string, args map[string]any) *Envelope {
+	ctx, warnings := WithWarnings(ctx)
+	start := time.Now()
+	result, err := r.CallContext(ctx, name, args)
+	env := &Envelope{
+		Ok:         err == nil,
+		Warnings:   warnings(),
+		DurationMs: time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		env.Error = NewToolError(err)
+	} else if b, ok := result.([]byte); ok {
+		// []byte would otherwise be encoded as base64
+		env.Result = string(b)
+	} else {
+		env.Result = result
+	}
+	return env
+}
+
+type warningsKey struct{}
+
+type warningCollector struct {
+	mu       sync.Mutex
+	warnings []string
+}
+
+// WithWarnings returns a context that collects the warnings raised (with
+// Warn) during a tool call along with a function returning them.
+func WithWarnings(ctx context.Context) (context.Context, func() []string) {
+	collector := &warningCollector{}
+	return context.WithValue(ctx, warningsKey{}, collector), func() []string {
+		collector.mu.Lock()
+		defer collector.mu.Unlock()
+		return append([]string(nil), collector.warnings...)
+	}
+}
+
+// Warn records a non fatal problem with a tool call.  It is a no-op if ctx
+// is not collecting warnings.
+func Warn(ctx context.Context, format string, args ...any) {
+	if collector, ok := ctx.Value(warningsKey{}).(*warningCollector); ok {
+		collector.mu.Lock()
+		defer collector.mu.Unlock()
+		collector.warnings = append(collector.warnings, fmt.Sprintf(format, args...))
+	}
+}
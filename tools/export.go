@@ -0,0 +1,248 @@
+package tools
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Formats tool definitions can be exported in.  Each format produces exactly
+// what the platform expects as the value of the "tools" field in a request
+// (or in the case of MCP the result of a "tools/list" call).
+const (
+	FormatOpenAI    = "openai"
+	FormatAnthropic = "anthropic"
+	FormatGemini    = "gemini"
+	FormatMCP       = "mcp"
+)
+
+var ExportFormats = []string{FormatOpenAI, FormatAnthropic, FormatGemini, FormatMCP}
+
+// A ToolDef is the platform independent definition of a tool as recovered
+// from an exported format.
+type ToolDef struct {
+	Name        string
+	Description string
+	Parameters  []*Parameter
+}
+
+// ExportTools converts tool definitions into the given format.
+func ExportTools(tools []Tool, format string) (any, error) {
+	var out []any
+	switch format {
+	case FormatOpenAI:
+		for _, tool := range tools {
+			out = append(out, map[string]any{
+				"type": "function",
+				"function": map[string]any{
+					"name":        tool.Name(),
+					"description": tool.Description(),
+					"parameters":  ParametersSchema(tool.Parameters()),
+				},
+			})
+		}
+	case FormatAnthropic:
+		for _, tool := range tools {
+			out = append(out, map[string]any{
+				"name":         tool.Name(),
+				"description":  tool.Description(),
+				"input_schema": ParametersSchema(tool.Parameters()),
+			})
+		}
+	case FormatGemini:
+		var decls []any
+		for _, tool := range tools {
+			decls = append(decls, map[string]any{
+				"name":        tool.Name(),
+				"description": tool.Description(),
+				"parameters":  toGeminiSchema(ParametersSchema(tool.Parameters())),
+			})
+		}
+		out = append(out, map[string]any{"functionDeclarations": decls})
+	case FormatMCP:
+		for _, tool := range tools {
+			out = append(out, map[string]any{
+				"name":        tool.Name(),
+				"description": tool.Description(),
+				"inputSchema": ParametersSchema(tool.Parameters()),
+				"annotations": map[string]any{"readOnlyHint": IsReadOnly(tool)},
+			})
+		}
+		return map[string]any{"tools": out}, nil
+	default:
+		return nil, fmt.Errorf("unknown format %q, must be one of %s", format, strings.Join(ExportFormats, ", "))
+	}
+	return out, nil
+}
+
+// ImportTools parses tool definitions exported in the given format.
+func ImportTools(data []byte, format string) (out []*ToolDef, err error) {
+	var entries []map[string]any
+	switch format {
+	case FormatOpenAI, FormatAnthropic:
+		err = json.Unmarshal(data, &entries)
+	case FormatGemini:
+		var geminiTools []struct {
+			FunctionDeclarations []map[string]any `json:"functionDeclarations"`
+		}
+		err = json.Unmarshal(data, &geminiTools)
+		for _, gt := range geminiTools {
+			entries = append(entries, gt.FunctionDeclarations...)
+		}
+	case FormatMCP:
+		var result struct {
+			Tools []map[string]any `json:"tools"`
+		}
+		err = json.Unmarshal(data, &result)
+		entries = result.Tools
+	default:
+		err = fmt.Errorf("unknown format %q, must be one of %s", format, strings.Join(ExportFormats, ", "))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	schemaKey := map[string]string{
+		FormatOpenAI:    "parameters",
+		FormatAnthropic: "input_schema",
+		FormatGemini:    "parameters",
+		FormatMCP:       "inputSchema",
+	}[format]
+	for _, entry := range entries {
+		if format == FormatOpenAI {
+			entry, _ = entry["function"].(map[string]any)
+		}
+		name, _ := entry["name"].(string)
+		desc, _ := entry["description"].(string)
+		schema, _ := entry[schemaKey].(map[string]any)
+		if format == FormatGemini {
+			schema = fromGeminiSchema(schema).(map[string]any)
+		}
+		out = append(out, &ToolDef{Name: name, Description: desc, Parameters: ParametersFromSchema(schema)})
+	}
+	return
+}
+
+// ParametersFromSchema is the inverse of ParametersSchema.  As JSON objects
+// are unordered the parameters are returned sorted by name.
+func ParametersFromSchema(schema map[string]any) (out []*Parameter) {
+	props, _ := schema["properties"].(map[string]any)
+	required := map[string]bool{}
+	if reqs, ok := schema["required"].([]any); ok {
+		for _, r := range reqs {
+			if name, ok := r.(string); ok {
+				required[name] = true
+			}
+		}
+	}
+	for name, prop := range props {
+		propSchema, _ := prop.(map[string]any)
+		param := ParameterFromSchema(propSchema)
+		param.Name = name
+		param.Required = required[name]
+		out = append(out, param)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return
+}
+
+// ParameterFromSchema is the inverse of Parameter.Json.
+func ParameterFromSchema(schema map[string]any) *Parameter {
+	param := &Parameter{}
+	param.Type, _ = schema["type"].(string)
+	param.Description, _ = schema["description"].(string)
+	param.Pattern, _ = schema["pattern"].(string)
+	param.Default = schema["default"]
+	if enum, ok := schema["enum"].([]any); ok {
+		param.Enum = enum
+	}
+	if items, ok := schema["items"].(map[string]any); ok {
+		param.Items = ParameterFromSchema(items)
+	}
+	if param.Type == "object" {
+		if _, ok := schema["properties"]; ok {
+			param.Properties = ParametersFromSchema(schema)
+		}
+	}
+	if num, ok := toFloat(schema["minimum"]); ok {
+		param.Minimum = Ptr(num)
+	}
+	if num, ok := toFloat(schema["maximum"]); ok {
+		param.Maximum = Ptr(num)
+	}
+	minKey, maxKey := "minLength", "maxLength"
+	if param.Type == "array" {
+		minKey, maxKey = "minItems", "maxItems"
+	}
+	if num, ok := toFloat(schema[minKey]); ok {
+		param.MinLength = Ptr(int(num))
+	}
+	if num, ok := toFloat(schema[maxKey]); ok {
+		param.MaxLength = Ptr(int(num))
+	}
+	return param
+}
+
+// Gemini uses the OpenAPI flavor of schemas where types are upper case (eg
+// "STRING") and numeric bounds for lengths are strings (int64 in proto3 JSON).
+var geminiLengthKeys = map[string]bool{"minLength": true, "maxLength": true, "minItems": true, "maxItems": true}
+
+func toGeminiSchema(schema any) any {
+	switch val := schema.(type) {
+	case map[string]any:
+		out := map[string]any{}
+		for key, child := range val {
+			switch {
+			case key == "type":
+				out[key] = strings.ToUpper(child.(string))
+			case key == "properties":
+				props := map[string]any{}
+				for name, prop := range child.(map[string]any) {
+					props[name] = toGeminiSchema(prop)
+				}
+				out[key] = props
+			case key == "items":
+				out[key] = toGeminiSchema(child)
+			case geminiLengthKeys[key]:
+				out[key] = fmt.Sprintf("%v", child)
+			default:
+				out[key] = child
+			}
+		}
+		return out
+	}
+	return schema
+}
+
+func fromGeminiSchema(schema any) any {
+	switch val := schema.(type) {
+	case map[string]any:
+		out := map[string]any{}
+		for key, child := range val {
+			switch {
+			case key == "type":
+				out[key] = strings.ToLower(child.(string))
+			case key == "properties":
+				props := map[string]any{}
+				for name, prop := range child.(map[string]any) {
+					props[name] = fromGeminiSchema(prop)
+				}
+				out[key] = props
+			case key == "items":
+				out[key] = fromGeminiSchema(child)
+			case geminiLengthKeys[key]:
+				var num float64
+				fmt.Sscan(fmt.Sprintf("%v", child), &num)
+				out[key] = num
+			default:
+				out[key] = child
+			}
+		}
+		return out
+	}
+	if schema == nil {
+		return map[string]any{}
+	}
+	return schema
+}
@@ -0,0 +1,97 @@
+package tools
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// A tool exercising every schema feature we export
+type schemaTool struct{ panickyTool }
+
+func (s *schemaTool) Name() string        { return "schema_tool" }
+func (s *schemaTool) Description() string { return "Exercises the schema" }
+func (s *schemaTool) Parameters() []*Parameter {
+	return []*Parameter{
+		{Name: "mode", Description: "Mode", Type: "string", Required: true, Enum: []any{"a", "b"}, Default: "a"},
+		{Name: "count", Type: "integer", Minimum: Ptr(1.0), Maximum: Ptr(10.0)},
+		{Name: "name", Type: "string", MinLength: Ptr(1), MaxLength: Ptr(64), Pattern: "^[a-z]+$"},
+		{Name: "tags", Type: "array", MaxLength: Ptr(5), Items: &Parameter{Type: "string"}},
+		{Name: "options", Type: "object", Properties: []*Parameter{
+			{Name: "depth", Type: "integer", Required: true},
+			{Name: "verbose", Type: "boolean", Default: false},
+		}},
+	}
+}
+
+func sortParams(params []*Parameter) []*Parameter {
+	out := append([]*Parameter(nil), params...)
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	for _, p := range out {
+		if p.Properties != nil {
+			p.Properties = sortParams(p.Properties)
+		}
+		if p.Items != nil && p.Items.Properties != nil {
+			p.Items.Properties = sortParams(p.Items.Properties)
+		}
+	}
+	return out
+}
+
+func TestExportTools_RoundTrip(t *testing.T) {
+	registry := NewRegistry(NewWorkspace(t.TempDir()))
+	registry.Register(&schemaTool{})
+	tools := registry.Tools()
+
+	for _, format := range ExportFormats {
+		t.Run(format, func(t *testing.T) {
+			exported, err := ExportTools(tools, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := json.Marshal(exported)
+			if err != nil {
+				t.Fatal(err)
+			}
+			imported, err := ImportTools(data, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(imported) != len(tools) {
+				t.Fatalf("expected %d tools, got %d", len(tools), len(imported))
+			}
+			for i, tool := range tools {
+				def := imported[i]
+				if def.Name != tool.Name() || def.Description != tool.Description() {
+					t.Errorf("%s: name/description mismatch: %q", tool.Name(), def.Name)
+				}
+				expected := sortParams(tool.Parameters())
+				if !reflect.DeepEqual(def.Parameters, expected) {
+					e, _ := json.Marshal(expected)
+					g, _ := json.Marshal(def.Parameters)
+					t.Errorf("%s: parameter mismatch:\nExpected: %s\nGot:      %s", tool.Name(), e, g)
+				}
+			}
+		})
+	}
+}
+
+func TestExportTools_Shapes(t *testing.T) {
+	tools := []Tool{&schemaTool{}}
+	exported, _ := ExportTools(tools, FormatOpenAI)
+	fn := exported.([]any)[0].(map[string]any)
+	if fn["type"] != "function" || fn["function"].(map[string]any)["parameters"].(map[string]any)["required"] == nil {
+		t.Errorf("openai: expected a function entry with required inside parameters, got %v", fn)
+	}
+
+	exported, _ = ExportTools(tools, FormatGemini)
+	decl := exported.([]any)[0].(map[string]any)["functionDeclarations"].([]any)[0].(map[string]any)
+	if decl["parameters"].(map[string]any)["type"] != "OBJECT" {
+		t.Errorf("gemini: expected upper case types, got %v", decl["parameters"])
+	}
+
+	if _, err := ExportTools(tools, "unknown"); err == nil {
+		t.Errorf("expected an error for an unknown format")
+	}
+}
@@ -0,0 +1,218 @@
+package tools
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Default location of the FileTracker's hashes (relative to the workspace
+// root).
+const DefaultFileTrackerPath = ".vibrant/state/files.json"
+
+// ErrStaleWrite is returned (wrapped in a *StaleWriteError) when a file has
+// changed since the caller last read it.
+var ErrStaleWrite = errors.New("file has changed since it was read")
+
+// A StaleWriteError is returned when the expected_hash precondition of a
+// write does not match the file on disk.  The current content is included so
+// the caller can redo its change without another read.
+type StaleWriteError struct {
+	Path           string `json:"path"`
+	ExpectedHash   string `json:"expected_hash"`
+	CurrentHash    string `json:"current_hash"`
+	CurrentContent string `json:"current_content"`
+
+	// Whether CurrentContent was cut short (at DefaultReadMaxBytes)
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+func (e *StaleWriteError) Error() string {
+	if e.CurrentHash == "" {
+		return fmt.Sprintf("%s: %v (expected hash %s but the file no longer exists)", e.Path, ErrStaleWrite, e.ExpectedHash)
+	}
+	return fmt.Sprintf("%s: %v (expected hash %s, current hash %s)", e.Path, ErrStaleWrite, e.ExpectedHash, e.CurrentHash)
+}
+
+func (e *StaleWriteError) Unwrap() error {
+	return ErrStaleWrite
+}
+
+// HashContent returns the hash of file contents as reported by read_file and
+// accepted as expected_hash by the tools that modify files.
+func HashContent(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Hashes recorded in a session are dropped once it has recorded nothing for
+// this long (unless a FileTracker sets its own TTL).
+const DefaultFileTrackerTTL = 24 * time.Hour
+
+// A FileTracker remembers the hash of every file as of its last read (or
+// write) in a session so writes to files that were never read, or have changed
+// since, can be flagged.
+//
+// Every 'calls respond' or 'tools run' is a process of its own so trackers
+// with a Path keep the hashes in that file (shared by every process using it)
+// rather than only in memory.  The file holds the hashes of each session
+// apart - a read in one conversation says nothing about a write in another -
+// and is locked while being updated.
+type FileTracker struct {
+	// File the hashes are kept in (see OpenFileTracker)
+	Path string
+
+	// Session the hashes are recorded for and looked up in
+	Session string
+
+	// How long the hashes of a session that records nothing are kept.
+	// Defaults to DefaultFileTrackerTTL.
+	TTL time.Duration
+
+	mu     sync.Mutex
+	hashes map[string]string
+}
+
+// The hashes of a session as kept in a FileTracker's Path
+type trackedSession struct {
+	Updated time.Time         `json:"updated"`
+	Hashes  map[string]string `json:"hashes"`
+}
+
+// NewFileTracker creates a tracker keeping its hashes in memory.
+func NewFileTracker() *FileTracker {
+	return &FileTracker{hashes: map[string]string{}}
+}
+
+// OpenFileTracker creates a tracker keeping the hashes of a session in path.
+// Nothing is created until the first hash is recorded.
+func OpenFileTracker(path, session string) *FileTracker {
+	return &FileTracker{Path: path, Session: session, hashes: map[string]string{}}
+}
+
+// Record notes the hash of a file (by its resolved path) as seen by the caller.
+func (t *FileTracker) Record(fullpath, hash string) {
+	t.update(func(hashes map[string]string) {
+		hashes[fullpath] = hash
+	})
+}
+
+// Forget drops what is known about a file (eg after it is renamed away).
+func (t *FileTracker) Forget(fullpath string) {
+	t.update(func(hashes map[string]string) {
+		delete(hashes, fullpath)
+	})
+}
+
+// LastSeen returns the hash of a file as of its last read or write.
+func (t *FileTracker) LastSeen(fullpath string) (hash string, ok bool) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.Path != "" {
+		// Other processes may have recorded hashes since.  Path is replaced
+		// atomically so it can be read without the lock.
+		t.hashes = map[string]string{}
+		if session := t.load()[t.Session]; session != nil {
+			t.hashes = session.Hashes
+		}
+	}
+	hash, ok = t.hashes[fullpath]
+	return
+}
+
+// update changes the hashes of the session.  With a Path this happens under
+// its lock and on the latest hashes in it so updates by other processes are
+// not lost.  Failing to update Path is not an error as the worst that can
+// happen is needless warnings about blind writes.
+func (t *FileTracker) update(change func(hashes map[string]string)) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.Path == "" {
+		change(t.hashes)
+		return
+	}
+	unlock, err := lockPath(t.Path)
+	if err != nil {
+		return
+	}
+	defer unlock()
+
+	sessions := t.load()
+	session := sessions[t.Session]
+	if session == nil {
+		session = &trackedSession{Hashes: map[string]string{}}
+		sessions[t.Session] = session
+	}
+	change(session.Hashes)
+	session.Updated = time.Now()
+	t.hashes = session.Hashes
+	if data, err := json.MarshalIndent(sessions, "", "  "); err == nil {
+		WriteFileAtomic(t.Path, data, 0644)
+	}
+}
+
+// load reads the sessions in Path without those that have expired.  A
+// missing or corrupt file is treated as empty.
+func (t *FileTracker) load() map[string]*trackedSession {
+	sessions := map[string]*trackedSession{}
+	if data, err := os.ReadFile(t.Path); err == nil && json.Unmarshal(data, &sessions) != nil {
+		sessions = map[string]*trackedSession{}
+	}
+	ttl := t.TTL
+	if ttl == 0 {
+		ttl = DefaultFileTrackerTTL
+	}
+	for id, session := range sessions {
+		if session == nil || session.Hashes == nil || time.Since(session.Updated) > ttl {
+			delete(sessions, id)
+		}
+	}
+	return sessions
+}
+
+// CheckWrite is called by tools before modifying a file with its current
+// contents (nil if it does not exist).  If expectedHash is set and does not
+// match the current contents a *StaleWriteError is returned.  Otherwise
+// overwriting a file that was not read in this session, or that changed since
+// it was, raises a warning.
+func (b *BaseFileTool) CheckWrite(ctx context.Context, path, fullpath, expectedHash string, current []byte, exists bool) error {
+	currentHash := ""
+	if exists {
+		currentHash = HashContent(current)
+	}
+	if expectedHash != "" {
+		if expectedHash == currentHash {
+			return nil
+		}
+		err := &StaleWriteError{Path: path, ExpectedHash: expectedHash, CurrentHash: currentHash}
+		if exists && !isBinary(current) {
+			err.CurrentContent = string(current)
+			if len(current) > DefaultReadMaxBytes {
+				err.CurrentContent, err.Truncated = string(current[:DefaultReadMaxBytes]), true
+			}
+		}
+		return err
+	}
+
+	if !exists || b.Files == nil {
+		return nil
+	}
+	if lastSeen, ok := b.Files.LastSeen(fullpath); !ok {
+		Warn(ctx, "%s was overwritten without being read first - pass expected_hash (from read_file) to guard against clobbering changes", path)
+	} else if lastSeen != currentHash {
+		Warn(ctx, "%s had changed since it was last read and those changes were overwritten", path)
+	}
+	return nil
+}
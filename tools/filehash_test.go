@@ -0,0 +1,53 @@
+package tools
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFileTracker(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "files.json")
+
+	// Trackers of the same session (eg separate calls respond runs) see each
+	// other's hashes but not those of other sessions
+	OpenFileTracker(path, "chat-1").Record("/p/a.go", "h1")
+	if hash, ok := OpenFileTracker(path, "chat-1").LastSeen("/p/a.go"); !ok || hash != "h1" {
+		t.Errorf("expected the hash recorded in the session, got %q %v", hash, ok)
+	}
+	if _, ok := OpenFileTracker(path, "chat-2").LastSeen("/p/a.go"); ok {
+		t.Errorf("expected hashes of another session to be ignored")
+	}
+
+	// Concurrent updates (each with a tracker of its own like separate
+	// processes) are not lost
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			OpenFileTracker(path, "chat-1").Record(fmt.Sprintf("/p/%d.go", i), "h")
+		}()
+	}
+	wg.Wait()
+	tracker := OpenFileTracker(path, "chat-1")
+	for i := range 20 {
+		if _, ok := tracker.LastSeen(fmt.Sprintf("/p/%d.go", i)); !ok {
+			t.Errorf("expected the hash of /p/%d.go to be kept", i)
+		}
+	}
+	tracker.Forget("/p/a.go")
+	if _, ok := OpenFileTracker(path, "chat-1").LastSeen("/p/a.go"); ok {
+		t.Errorf("expected /p/a.go to be forgotten")
+	}
+
+	// Sessions that have been idle for longer than the TTL expire
+	expiring := OpenFileTracker(path, "chat-1")
+	expiring.TTL = time.Nanosecond
+	time.Sleep(time.Millisecond)
+	if _, ok := expiring.LastSeen("/p/1.go"); ok {
+		t.Errorf("expected the hashes of an idle session to expire")
+	}
+}
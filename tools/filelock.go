@@ -0,0 +1,29 @@
+package tools
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// lockPath takes an exclusive lock (blocking until it is free) shared by
+// every process using path, for read-modify-write updates of files that
+// several 'calls respond' or 'tools run' processes may update at once.  The
+// lock is held on a separate path+".lock" file as path itself is usually
+// replaced (see WriteFileAtomic) while locked.
+func lockPath(path string) (unlock func(), err error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() {
+		unlockFile(f)
+		f.Close()
+	}, nil
+}
@@ -0,0 +1,78 @@
+package tools
+
+import (
+	"path"
+	"strings"
+)
+
+// MatchGlob reports whether the slash separated relative path matches the
+// given glob pattern.  Patterns follow the usual path.Match syntax per
+// segment with a few additions borrowed from gitignore:
+//
+//   - "**" matches zero or more whole path segments ("src/**/*.go").
+//   - A pattern without a "/" is matched against every suffix of the path so
+//     "*.pem" matches "certs/server.pem" and ".env*" matches "deploy/.env.prod".
+//   - A trailing "/**" also matches the directory itself, so ".git/**" matches
+//     ".git" as well as everything under it.
+func MatchGlob(pattern, relpath string) bool {
+	pattern = strings.TrimPrefix(strings.TrimSpace(pattern), "./")
+	relpath = strings.Trim(path.Clean("/"+relpath), "/")
+	if pattern == "" {
+		return false
+	}
+
+	patParts := strings.Split(strings.Trim(pattern, "/"), "/")
+	pathParts := strings.Split(relpath, "/")
+	if relpath == "" {
+		pathParts = nil
+	}
+
+	if !strings.Contains(strings.Trim(pattern, "/"), "/") {
+		// unanchored pattern - try it against every suffix of the path
+		for i := range pathParts {
+			if matchSegments(patParts, pathParts[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if strings.HasSuffix(pattern, "/**") && matchSegments(patParts[:len(patParts)-1], pathParts) {
+		return true
+	}
+	return matchSegments(patParts, pathParts)
+}
+
+// MatchAnyGlob returns the first pattern that matches relpath (and true) if any.
+func MatchAnyGlob(patterns []string, relpath string) (string, bool) {
+	for _, pattern := range patterns {
+		if MatchGlob(pattern, relpath) {
+			return pattern, true
+		}
+	}
+	return "", false
+}
+
+func matchSegments(pat, parts []string) bool {
+	for len(pat) > 0 {
+		if pat[0] == "**" {
+			// Collapse consecutive "**" and try every possible split
+			for len(pat) > 1 && pat[1] == "**" {
+				pat = pat[1:]
+			}
+			for i := 0; i <= len(parts); i++ {
+				if matchSegments(pat[1:], parts[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(parts) == 0 {
+			return false
+		}
+		if ok, err := path.Match(pat[0], parts[0]); err != nil || !ok {
+			return false
+		}
+		pat, parts = pat[1:], parts[1:]
+	}
+	return len(parts) == 0
+}
@@ -0,0 +1,298 @@
+package tools
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Default cap on the number of matches returned by grep_files
+const DefaultGrepMaxMatches = 100
+
+// Lines longer than this are cut short in match snippets
+const grepMaxLineLength = 500
+
+// Files larger than this are not searched
+const grepMaxFileSize = 10 * 1024 * 1024
+
+type GrepFiles struct {
+	BaseFileTool
+}
+
+// A GrepMatch is a single line matching the pattern.
+type GrepMatch struct {
+	// Slash separated path of the file relative to the searched folder
+	File string `json:"file"`
+
+	// 1-based line and column (in characters) of the start of the match
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+	Text   string `json:"text"`
+
+	// Lines around the match (upto context_lines on each side)
+	Before []string `json:"before,omitempty"`
+	After  []string `json:"after,omitempty"`
+}
+
+// GrepFilesResult is the result of a grep_files call.
+type GrepFilesResult struct {
+	Matches       []*GrepMatch `json:"matches"`
+	FilesSearched int          `json:"files_searched"`
+	FilesMatched  int          `json:"files_matched"`
+
+	// Whether there were more than max_matches matches (and the search stopped)
+	Truncated bool `json:"truncated"`
+}
+
+func (g *GrepFiles) Name() string {
+	return "grep_files"
+}
+
+func (g *GrepFiles) ReadOnly() bool {
+	return true
+}
+
+func (g *GrepFiles) Description() string {
+	return `
+	Searches the contents of files under the folder given by 'path' for lines matching the regular expression in 'pattern' (RE2 syntax).
+
+	Use 'include' and 'exclude' globs (eg "**/*.go") to limit the files searched.   Files matched by .gitignore/.vibrantignore files, .git and node_modules
+	as well as binary files are skipped.
+
+	Returns a list of matches each with the file, line, column and text of the matching line (along with 'context_lines' lines before and after it).
+	At most 'max_matches' matches are returned and 'truncated' is set if there were more.
+	`
+}
+
+func (g *GrepFiles) Parameters() []*Parameter {
+	return []*Parameter{
+		{
+			Name:        "pattern",
+			Description: "Regular expression (RE2 syntax) to search for.  Matches are found within a single line.",
+			Type:        "string",
+			Required:    true,
+			MinLength:   Ptr(1),
+		},
+		{
+			Name:        "path",
+			Description: "Folder (or file) to search in.  Path will be resolved to a relative path in the current project.",
+			Type:        "string",
+			Default:     ".",
+		},
+		{
+			Name:        "include",
+			Description: "Only search files matching one of these globs (relative to 'path', eg '**/*.go')",
+			Type:        "array",
+			Items:       &Parameter{Type: "string"},
+		},
+		{
+			Name:        "exclude",
+			Description: "Skip files and folders matching any of these globs (relative to 'path')",
+			Type:        "array",
+			Items:       &Parameter{Type: "string"},
+		},
+		{
+			Name:        "case_sensitive",
+			Description: "Whether the pattern is case sensitive",
+			Type:        "boolean",
+			Default:     true,
+		},
+		{
+			Name:        "context_lines",
+			Description: "Number of lines to include before and after each match",
+			Type:        "integer",
+			Default:     0.0,
+			Minimum:     Ptr(0.0),
+			Maximum:     Ptr(20.0),
+		},
+		{
+			Name:        "max_matches",
+			Description: "Maximum number of matches to return",
+			Type:        "integer",
+			Default:     float64(DefaultGrepMaxMatches),
+			Minimum:     Ptr(1.0),
+		},
+		{
+			Name:        "respect_ignore",
+			Description: "Whether to skip files matched by .gitignore/.vibrantignore files as well as .git and node_modules folders",
+			Type:        "boolean",
+			Default:     true,
+		},
+	}
+}
+
+func (g *GrepFiles) Returns() []*Parameter {
+	return []*Parameter{
+		{
+			Name:        "matches",
+			Description: "List of matches with the file, line, column, text and surrounding lines of each match",
+			Type:        "object",
+		},
+	}
+}
+
+func (g *GrepFiles) Run(args map[string]any) (any, error) {
+	return g.RunContext(context.Background(), args)
+}
+
+func (g *GrepFiles) RunContext(ctx context.Context, args map[string]any) (any, error) {
+	pattern := args["pattern"].(string)
+	if caseSensitive, ok := args["case_sensitive"].(bool); ok && !caseSensitive {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern: %w", err)
+	}
+
+	path := "."
+	if val, ok := args["path"].(string); ok && val != "" {
+		path = val
+	}
+	sandbox, relpath := g.Sandbox(path)
+	fullpath, err := sandbox.Resolve(relpath)
+	if err != nil {
+		return nil, err
+	}
+	respectIgnore := true
+	if val, ok := args["respect_ignore"].(bool); ok {
+		respectIgnore = val
+	}
+	contextLines := intArg(args, "context_lines", 0)
+	maxMatches := intArg(args, "max_matches", DefaultGrepMaxMatches)
+
+	result := &GrepFilesResult{Matches: []*GrepMatch{}}
+	search := func(file, rel string) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		// One match more than there is room for is looked for so the search
+		// is only reported as truncated if there really are more matches
+		room := maxMatches - len(result.Matches)
+		matches, err := grepFile(file, rel, re, contextLines, room+1)
+		if err != nil {
+			// Unreadable files are skipped rather than failing the search
+			return nil
+		}
+		result.FilesSearched++
+		if len(matches) > room {
+			matches, result.Truncated = matches[:room], true
+		}
+		if len(matches) > 0 {
+			result.FilesMatched++
+			result.Matches = append(result.Matches, matches...)
+		}
+		if result.Truncated {
+			return fs.SkipAll
+		}
+		return nil
+	}
+
+	info, err := os.Stat(fullpath)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		if err := search(fullpath, info.Name()); err != nil && err != fs.SkipAll {
+			return nil, err
+		}
+		return result, nil
+	}
+
+	opts := WalkOptions{
+		Include:            stringsArg(args, "include"),
+		Exclude:            stringsArg(args, "exclude"),
+		RespectIgnoreFiles: respectIgnore,
+	}
+	err = WalkTree(sandbox, fullpath, opts, func(file, rel string, depth int, entry fs.DirEntry) error {
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		return search(file, rel)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// grepFile returns upto maxMatches matches of re in the given file.  Binary
+// and very large files have no matches.
+func grepFile(fullpath, rel string, re *regexp.Regexp, contextLines, maxMatches int) (matches []*GrepMatch, err error) {
+	file, err := os.Open(fullpath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if info, err := file.Stat(); err != nil || info.Size() > grepMaxFileSize {
+		return nil, err
+	}
+
+	reader := bufio.NewReader(file)
+	if head, _ := reader.Peek(8000); isBinary(head) {
+		return nil, nil
+	}
+
+	// Recent lines are kept around for the "before" context and matches stay
+	// pending till their "after" context has been collected
+	var recent []string
+	var pending []*GrepMatch
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), grepMaxFileSize)
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		line := strings.TrimRight(scanner.Text(), "\r")
+
+		for _, match := range pending {
+			if len(match.After) < contextLines {
+				match.After = append(match.After, clipLine(line))
+			}
+		}
+		for len(pending) > 0 && len(pending[0].After) >= contextLines {
+			pending = pending[1:]
+		}
+
+		if len(matches) < maxMatches {
+			if loc := re.FindStringIndex(line); loc != nil {
+				match := &GrepMatch{
+					File:   rel,
+					Line:   lineno,
+					Column: utf8.RuneCountInString(line[:loc[0]]) + 1,
+					Text:   clipLine(line),
+				}
+				if contextLines > 0 {
+					match.Before = append([]string{}, recent...)
+					pending = append(pending, match)
+				}
+				matches = append(matches, match)
+			}
+		} else if len(pending) == 0 {
+			break
+		}
+
+		if contextLines > 0 {
+			recent = append(recent, clipLine(line))
+			if len(recent) > contextLines {
+				recent = recent[1:]
+			}
+		}
+	}
+	return matches, scanner.Err()
+}
+
+func clipLine(line string) string {
+	if len(line) <= grepMaxLineLength {
+		return line
+	}
+	line = line[:grepMaxLineLength]
+	for len(line) > 0 && !utf8.ValidString(line) {
+		line = line[:len(line)-1]
+	}
+	return line + "..."
+}
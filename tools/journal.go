@@ -0,0 +1,479 @@
+package tools
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Default location of the change journal (relative to the workspace root).
+const DefaultJournalDir = ".vibrant/journal"
+
+// Kinds of journal entries
+const (
+	JournalChange     = "change"
+	JournalUndo       = "undo"
+	JournalCheckpoint = "checkpoint"
+)
+
+// ErrJournalConflict is returned (wrapped) when undoing a change to a file
+// that has been modified since by something other than the journaled tools.
+var ErrJournalConflict = errors.New("file changed since")
+
+// A FileChange records the state of a file before and after a tool changed it.
+// States are the hashes of the contents (saved in the journal's blobs) with ""
+// meaning the file did not exist.
+type FileChange struct {
+	// The path as passed to the tool and the file it resolved to
+	Path     string `json:"path"`
+	FullPath string `json:"fullpath"`
+
+	Before string `json:"before,omitempty"`
+	After  string `json:"after,omitempty"`
+
+	// Permissions of the file before the change
+	Mode fs.FileMode `json:"mode,omitempty"`
+}
+
+// A JournalEntry is a change made by a tool, the undoing of earlier changes or
+// a named checkpoint.
+type JournalEntry struct {
+	ID   int       `json:"id"`
+	Kind string    `json:"kind"`
+	Time time.Time `json:"time"`
+
+	// Tool that made the change
+	Tool  string        `json:"tool,omitempty"`
+	Files []*FileChange `json:"files,omitempty"`
+
+	// Name of a checkpoint
+	Name string `json:"name,omitempty"`
+
+	// Ids of the changes reverted by an undo
+	Undoes []int `json:"undoes,omitempty"`
+
+	// Whether a change has been undone since (see Journal.Entries)
+	Undone bool `json:"-"`
+}
+
+// A Journal records the prior state of every file changed by the mutating
+// tools so the changes of a session can be listed, diffed and undone - one at
+// a time or back to a named checkpoint.  Unlike git stash it does not care
+// whether the files are in a repository or have uncommitted changes.
+//
+// Entries are appended to journal.jsonl in Dir and file contents are stored
+// (once) by their hash under Dir/blobs.
+type Journal struct {
+	Dir string
+
+	mu sync.Mutex
+}
+
+// NewJournal creates a journal stored in dir.  Nothing is created until the
+// first entry is recorded.
+func NewJournal(dir string) *Journal {
+	return &Journal{Dir: dir}
+}
+
+func (j *Journal) entriesPath() string {
+	return filepath.Join(j.Dir, "journal.jsonl")
+}
+
+// Entries returns every entry in the order recorded with undone changes
+// marked.
+func (j *Journal) Entries() ([]*JournalEntry, error) {
+	file, err := os.Open(j.entriesPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []*JournalEntry
+	byId := map[int]*JournalEntry{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 16*1024*1024)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		entry := &JournalEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), entry); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", j.entriesPath(), lineno, err)
+		}
+		for _, id := range entry.Undoes {
+			if undone := byId[id]; undone != nil {
+				undone.Undone = true
+			}
+		}
+		byId[entry.ID] = entry
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// Get returns the entry with the given id.
+func (j *Journal) Get(id int) (*JournalEntry, error) {
+	entries, err := j.Entries()
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.ID == id {
+			return entry, nil
+		}
+	}
+	return nil, fmt.Errorf("no change with id %d: %w", id, fs.ErrNotExist)
+}
+
+// Changes returns the changes that have not been undone - most recent first.
+func (j *Journal) Changes() ([]*JournalEntry, error) {
+	entries, err := j.Entries()
+	if err != nil {
+		return nil, err
+	}
+	var out []*JournalEntry
+	for _, entry := range slices.Backward(entries) {
+		if entry.Kind == JournalChange && !entry.Undone {
+			out = append(out, entry)
+		}
+	}
+	return out, nil
+}
+
+// record assigns the entry the next id and appends it to the journal.  The
+// journal is locked (across processes) from reading the last id to appending
+// so concurrent CLI calls never record the same id.
+func (j *Journal) record(entry *JournalEntry) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	unlock, err := lockPath(j.entriesPath())
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	entries, err := j.Entries()
+	if err != nil {
+		return err
+	}
+	entry.ID = 1
+	if len(entries) > 0 {
+		entry.ID = entries[len(entries)-1].ID + 1
+	}
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(j.entriesPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Blob returns the contents saved with the given hash.
+func (j *Journal) Blob(hash string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(j.Dir, "blobs", hash))
+}
+
+// saveBlob saves contents (if not already saved) returning its hash.
+func (j *Journal) saveBlob(contents []byte) (string, error) {
+	hash := HashContent(contents)
+	path := filepath.Join(j.Dir, "blobs", hash)
+	if _, err := os.Stat(path); err == nil {
+		return hash, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	return hash, WriteFileAtomic(path, contents, 0644)
+}
+
+// snapshot saves the current contents of a file returning its hash ("" if it
+// does not exist) and permissions.
+func (j *Journal) snapshot(fullpath string) (string, fs.FileMode, error) {
+	info, err := os.Stat(fullpath)
+	if os.IsNotExist(err) {
+		return "", 0, nil
+	} else if err != nil {
+		return "", 0, err
+	}
+	if info.IsDir() {
+		return "", 0, fmt.Errorf("%s is a directory - only changes to files are journaled", fullpath)
+	}
+	contents, err := os.ReadFile(fullpath)
+	if err != nil {
+		return "", 0, err
+	}
+	hash, err := j.saveBlob(contents)
+	return hash, info.Mode().Perm(), err
+}
+
+// Begin starts journaling a change made by a tool.  Every file the tool is
+// about to change is passed to Track and Commit records the entry once the
+// tool is done.  A nil journal returns a nil *JournalTx that records nothing.
+func (j *Journal) Begin(tool string) *JournalTx {
+	if j == nil {
+		return nil
+	}
+	return &JournalTx{journal: j, tool: tool}
+}
+
+// A JournalTx collects the files changed by one tool call.
+type JournalTx struct {
+	journal *Journal
+	tool    string
+	files   []*FileChange
+}
+
+// Track saves the state of a file before it is changed.
+func (tx *JournalTx) Track(path, fullpath string) error {
+	if tx == nil {
+		return nil
+	}
+	for _, file := range tx.files {
+		if file.FullPath == fullpath {
+			return nil
+		}
+	}
+	before, mode, err := tx.journal.snapshot(fullpath)
+	if err != nil {
+		return err
+	}
+	tx.files = append(tx.files, &FileChange{Path: path, FullPath: fullpath, Before: before, Mode: mode})
+	return nil
+}
+
+// Commit saves the state of the tracked files after the change and records
+// the entry.  Nothing is recorded if no file actually changed.  It is safe to
+// call even if the change failed part way.
+func (tx *JournalTx) Commit() error {
+	if tx == nil {
+		return nil
+	}
+	entry := &JournalEntry{Kind: JournalChange, Tool: tx.tool}
+	for _, file := range tx.files {
+		after, _, err := tx.journal.snapshot(file.FullPath)
+		if err != nil {
+			return err
+		}
+		if after != file.Before {
+			file.After = after
+			entry.Files = append(entry.Files, file)
+		}
+	}
+	tx.files = nil
+	if len(entry.Files) == 0 {
+		return nil
+	}
+	return tx.journal.record(entry)
+}
+
+// Rollback puts the tracked files back into their state before the change
+// (for tools that change several files all or nothing and failed part way)
+// returning the files put back.  Files that cannot be put back stay tracked
+// so Commit still records them.
+func (tx *JournalTx) Rollback() ([]*FileChange, error) {
+	if tx == nil {
+		return nil, nil
+	}
+	var restored, failed []*FileChange
+	var errs []error
+	for _, file := range slices.Backward(tx.files) {
+		current, err := fileHash(file.FullPath)
+		if err == nil && current != file.Before {
+			err = tx.journal.restore(file)
+		}
+		if err != nil {
+			failed = append(failed, file)
+			errs = append(errs, fmt.Errorf("%s: %w", file.Path, err))
+			continue
+		}
+		restored = append(restored, file)
+	}
+	slices.Reverse(failed)
+	tx.files = failed
+	return restored, errors.Join(errs...)
+}
+
+// trackChange tracks a file about to be changed by a tool call - warning
+// instead of failing the call if it cannot be journaled.
+func trackChange(ctx context.Context, tx *JournalTx, path, fullpath string) {
+	if err := tx.Track(path, fullpath); err != nil {
+		Warn(ctx, "%s: the change cannot be undone: %v", path, err)
+	}
+}
+
+// commitChange commits the change made by a tool call warning if it could not
+// be journaled.
+func commitChange(ctx context.Context, tx *JournalTx) {
+	if err := tx.Commit(); err != nil {
+		Warn(ctx, "the change could not be journaled (so cannot be undone): %v", err)
+	}
+}
+
+// Checkpoint records a named point in the journal that Restore can return to.
+// A later checkpoint with the same name replaces it.
+func (j *Journal) Checkpoint(name string) (*JournalEntry, error) {
+	if strings.TrimSpace(name) == "" {
+		return nil, fmt.Errorf("checkpoints need a name")
+	}
+	entry := &JournalEntry{Kind: JournalCheckpoint, Name: name}
+	return entry, j.record(entry)
+}
+
+// Undo reverts the last n changes that have not been undone (most recent
+// first) and returns the changes reverted.  Unless force is set, undoing stops
+// at the first change to a file that has been modified since.
+func (j *Journal) Undo(n int, force bool) ([]*JournalEntry, error) {
+	changes, err := j.Changes()
+	if err != nil {
+		return nil, err
+	}
+	return j.undo(changes[:min(n, len(changes))], force)
+}
+
+// Restore reverts every change made since the named checkpoint (see Undo).
+func (j *Journal) Restore(name string, force bool) ([]*JournalEntry, error) {
+	entries, err := j.Entries()
+	if err != nil {
+		return nil, err
+	}
+	checkpoint := -1
+	for _, entry := range entries {
+		if entry.Kind == JournalCheckpoint && entry.Name == name {
+			checkpoint = entry.ID
+		}
+	}
+	if checkpoint < 0 {
+		return nil, fmt.Errorf("no checkpoint named %q: %w", name, fs.ErrNotExist)
+	}
+	var changes []*JournalEntry
+	for _, entry := range slices.Backward(entries) {
+		if entry.ID > checkpoint && entry.Kind == JournalChange && !entry.Undone {
+			changes = append(changes, entry)
+		}
+	}
+	return j.undo(changes, force)
+}
+
+// undo reverts changes in order and records an undo entry for the ones that
+// were reverted.
+func (j *Journal) undo(changes []*JournalEntry, force bool) (undone []*JournalEntry, err error) {
+	for _, change := range changes {
+		if err = j.revert(change, force); err != nil {
+			err = fmt.Errorf("undoing change %d (%s): %w", change.ID, change.Tool, err)
+			break
+		}
+		undone = append(undone, change)
+	}
+	if len(undone) > 0 {
+		entry := &JournalEntry{Kind: JournalUndo}
+		for _, change := range undone {
+			entry.Undoes = append(entry.Undoes, change.ID)
+		}
+		if rerr := j.record(entry); err == nil {
+			err = rerr
+		}
+	}
+	return undone, err
+}
+
+// revert puts every file of a change back into its prior state.
+func (j *Journal) revert(change *JournalEntry, force bool) error {
+	if !force {
+		for _, file := range change.Files {
+			current, err := fileHash(file.FullPath)
+			if err != nil {
+				return err
+			}
+			if current != file.After {
+				return fmt.Errorf("%s: %w the change (use force to undo anyway)", file.Path, ErrJournalConflict)
+			}
+		}
+	}
+	for _, file := range slices.Backward(change.Files) {
+		if err := j.restore(file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restore puts a file back into its state before a change.
+func (j *Journal) restore(file *FileChange) error {
+	if file.Before == "" {
+		if err := os.Remove(file.FullPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	contents, err := j.Blob(file.Before)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file.FullPath), 0755); err != nil {
+		return err
+	}
+	mode := file.Mode
+	if mode == 0 {
+		mode = 0644
+	}
+	return WriteFileAtomic(file.FullPath, contents, mode)
+}
+
+// fileHash returns the hash of the contents of a file ("" if it does not
+// exist).
+func fileHash(fullpath string) (string, error) {
+	contents, err := os.ReadFile(fullpath)
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	return HashContent(contents), nil
+}
+
+// Diff returns the unified diff of every file of a change.
+func (j *Journal) Diff(change *JournalEntry) (string, error) {
+	var out strings.Builder
+	for _, file := range change.Files {
+		var before, after []byte
+		var err error
+		from, to := "a/"+file.Path, "b/"+file.Path
+		if file.Before == "" {
+			from = DevNull
+		} else if before, err = j.Blob(file.Before); err != nil {
+			return "", err
+		}
+		if file.After == "" {
+			to = DevNull
+		} else if after, err = j.Blob(file.After); err != nil {
+			return "", err
+		}
+		if isBinary(before) || isBinary(after) {
+			fmt.Fprintf(&out, "Binary files %s and %s differ\n", from, to)
+			continue
+		}
+		out.WriteString(UnifiedDiff(from, to, string(before), string(after), 3))
+	}
+	return out.String(), nil
+}
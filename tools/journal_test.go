@@ -0,0 +1,114 @@
+package tools
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestJournal(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("one\ntwo\n"), 0644)
+	registry := NewRegistry(NewWorkspace(dir))
+	journal := registry.Journal
+	read := func(name string) string {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return "<missing>"
+		}
+		return string(data)
+	}
+	call := func(name string, args map[string]any) {
+		t.Helper()
+		if env := registry.Invoke(t.Context(), name, args); !env.Ok {
+			t.Fatalf("%s failed: %s", name, env)
+		}
+	}
+
+	call("edit_file", map[string]any{"path": "a.txt", "edits": []any{map[string]any{"old_text": "two", "new_text": "three"}}})
+	if _, err := journal.Checkpoint("before-rename"); err != nil {
+		t.Fatal(err)
+	}
+	call("write_file", map[string]any{"path": "new/b.txt", "contents": "bee\n", "encoding": "plain"})
+	call("rename_file", map[string]any{"src": "a.txt", "dest": "c.txt"})
+	call("apply_file_diff", map[string]any{"diff": "--- a/c.txt\n+++ b/c.txt\n@@ -1,2 +1,2 @@\n-one\n+uno\n three\n"})
+
+	changes, err := journal.Changes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, change := range changes {
+		names = append(names, change.Tool)
+	}
+	if strings.Join(names, ",") != "apply_file_diff,rename_file,write_file,edit_file" {
+		t.Errorf("unexpected changes %q", names)
+	}
+	if diff, _ := journal.Diff(changes[3]); !strings.Contains(diff, "-two\n+three\n") {
+		t.Errorf("unexpected diff of the edit: %q", diff)
+	}
+
+	// The journal is kept away from the tools
+	if env := registry.Invoke(t.Context(), "read_file", map[string]any{"path": DefaultJournalDir + "/journal.jsonl"}); env.Ok || env.Error.Code != ErrCodeSandbox {
+		t.Errorf("expected the journal to be denied, got %s", env)
+	}
+
+	undone, err := journal.Undo(1, false)
+	if err != nil || len(undone) != 1 || read("c.txt") != "one\nthree\n" {
+		t.Errorf("expected the diff to be undone, got %v (%v) with %q", undone, err, read("c.txt"))
+	}
+
+	// Files changed outside the tools are not clobbered without force
+	os.WriteFile(filepath.Join(dir, "c.txt"), []byte("edited by hand\n"), 0644)
+	if _, err := journal.Restore("before-rename", false); !errors.Is(err, ErrJournalConflict) {
+		t.Errorf("expected a conflict, got %v", err)
+	}
+	undone, err = journal.Restore("before-rename", true)
+	if err != nil || len(undone) != 2 {
+		t.Fatalf("expected the rename and write to be undone, got %v (%v)", undone, err)
+	}
+	if read("a.txt") != "one\nthree\n" || read("c.txt") != "<missing>" || read("new/b.txt") != "<missing>" {
+		t.Errorf("unexpected files after restoring: a=%q c=%q b=%q", read("a.txt"), read("c.txt"), read("new/b.txt"))
+	}
+
+	undone, err = journal.Undo(5, false)
+	if err != nil || len(undone) != 1 || read("a.txt") != "one\ntwo\n" {
+		t.Errorf("expected the edit to be undone, got %v (%v) with %q", undone, err, read("a.txt"))
+	}
+	if _, err := journal.Restore("missing", false); err == nil {
+		t.Errorf("expected restoring an unknown checkpoint to fail")
+	}
+}
+
+func TestJournal_ConcurrentIds(t *testing.T) {
+	dir := t.TempDir()
+
+	// Journals of their own like separate CLI processes sharing the workspace
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			journal := NewJournal(dir)
+			for n := range 10 {
+				if _, err := journal.Checkpoint(fmt.Sprintf("c%d-%d", i, n)); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	entries, err := NewJournal(dir).Entries()
+	if err != nil || len(entries) != 80 {
+		t.Fatalf("expected 80 entries, got %d (%v)", len(entries), err)
+	}
+	for i, entry := range entries {
+		if entry.ID != i+1 {
+			t.Errorf("expected entry %d to have id %d, got %d", i, i+1, entry.ID)
+		}
+	}
+}
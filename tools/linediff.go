@@ -0,0 +1,260 @@
+package tools
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DiffKind says what happened to a line in a LineDiff.
+type DiffKind int
+
+const (
+	DiffEqual DiffKind = iota
+	DiffDelete
+	DiffInsert
+)
+
+// A LineDiff is a single line of the difference between two texts.
+type LineDiff struct {
+	Kind DiffKind
+	Line string
+
+	// 1-based line numbers in the old and new texts (0 if the line is not in that text)
+	OldLineNo int
+	NewLineNo int
+}
+
+// DiffStats counts the lines added and removed between two texts.
+type DiffStats struct {
+	Added   int `json:"added"`
+	Removed int `json:"removed"`
+}
+
+// A DiffHunk is a group of changes along with the lines of context around
+// them - as in a unified diff.
+type DiffHunk struct {
+	OldStart, OldLines int
+	NewStart, NewLines int
+	Lines              []LineDiff
+}
+
+// Header returns the "@@ -a,b +c,d @@" line of the hunk.
+func (h *DiffHunk) Header() string {
+	return fmt.Sprintf("@@ -%d,%d +%d,%d @@", h.OldStart, h.OldLines, h.NewStart, h.NewLines)
+}
+
+// String returns the hunk in unified diff form (header included).
+func (h *DiffHunk) String() string {
+	var sb strings.Builder
+	sb.WriteString(h.Header())
+	sb.WriteString("\n")
+	for _, diff := range h.Lines {
+		switch diff.Kind {
+		case DiffEqual:
+			sb.WriteString(" ")
+		case DiffDelete:
+			sb.WriteString("-")
+		case DiffInsert:
+			sb.WriteString("+")
+		}
+		sb.WriteString(diff.Line)
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// SplitLines splits text into lines without their line endings.  A trailing
+// newline does not produce an empty last line.
+func SplitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	lines := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSuffix(line, "\r")
+	}
+	return lines
+}
+
+// DiffLineSlices returns the shortest sequence of line inserts and deletes
+// turning a into b (using Myers' O(ND) algorithm).
+func DiffLineSlices(a, b []string) (out []LineDiff) {
+	// Common prefixes and suffixes are cheap to strip and keep the search small
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	for i := 0; i < prefix; i++ {
+		out = append(out, LineDiff{Kind: DiffEqual, Line: a[i], OldLineNo: i + 1, NewLineNo: i + 1})
+	}
+	for _, diff := range myersDiff(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]) {
+		if diff.OldLineNo > 0 {
+			diff.OldLineNo += prefix
+		}
+		if diff.NewLineNo > 0 {
+			diff.NewLineNo += prefix
+		}
+		out = append(out, diff)
+	}
+	for i := suffix; i > 0; i-- {
+		out = append(out, LineDiff{Kind: DiffEqual, Line: a[len(a)-i], OldLineNo: len(a) - i + 1, NewLineNo: len(b) - i + 1})
+	}
+	return
+}
+
+func myersDiff(a, b []string) []LineDiff {
+	n, m := len(a), len(b)
+	if n == 0 && m == 0 {
+		return nil
+	}
+	total := n + m
+	offset := total + 1
+	v := make([]int, 2*total+2)
+	var trace [][]int
+
+search:
+	for d := 0; d <= total; d++ {
+		trace = append(trace, append([]int(nil), v...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				break search
+			}
+		}
+	}
+
+	// Walk the trace backwards to recover the edits
+	var out []LineDiff
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[offset+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			out = append(out, LineDiff{Kind: DiffEqual, Line: a[x-1], OldLineNo: x, NewLineNo: y})
+			x--
+			y--
+		}
+		if d > 0 {
+			if x == prevX {
+				out = append(out, LineDiff{Kind: DiffInsert, Line: b[y-1], NewLineNo: y})
+			} else {
+				out = append(out, LineDiff{Kind: DiffDelete, Line: a[x-1], OldLineNo: x})
+			}
+		}
+		x, y = prevX, prevY
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return out
+}
+
+// Stats counts the inserted and deleted lines in a diff.
+func Stats(diffs []LineDiff) (stats DiffStats) {
+	for _, diff := range diffs {
+		switch diff.Kind {
+		case DiffInsert:
+			stats.Added++
+		case DiffDelete:
+			stats.Removed++
+		}
+	}
+	return
+}
+
+// GroupHunks groups the changes in a diff into hunks with upto context
+// unchanged lines around them.  Changes closer than 2*context lines share a
+// hunk.
+func GroupHunks(diffs []LineDiff, context int) (hunks []*DiffHunk) {
+	var changes []int
+	for i, diff := range diffs {
+		if diff.Kind != DiffEqual {
+			changes = append(changes, i)
+		}
+	}
+
+	for c := 0; c < len(changes); {
+		start := max(changes[c]-context, 0)
+		end := changes[c]
+		for c < len(changes) && changes[c] <= end+2*context+1 {
+			end = changes[c]
+			c++
+		}
+		end = min(end+context+1, len(diffs))
+
+		hunk := &DiffHunk{Lines: diffs[start:end]}
+		for _, diff := range hunk.Lines {
+			if diff.Kind != DiffInsert {
+				if hunk.OldStart == 0 {
+					hunk.OldStart = diff.OldLineNo
+				}
+				hunk.OldLines++
+			}
+			if diff.Kind != DiffDelete {
+				if hunk.NewStart == 0 {
+					hunk.NewStart = diff.NewLineNo
+				}
+				hunk.NewLines++
+			}
+		}
+		// Empty sides are numbered after the line they follow, as diff does
+		if hunk.OldLines == 0 {
+			hunk.OldStart = lineBefore(diffs, start, true)
+		}
+		if hunk.NewLines == 0 {
+			hunk.NewStart = lineBefore(diffs, start, false)
+		}
+		hunks = append(hunks, hunk)
+	}
+	return
+}
+
+func lineBefore(diffs []LineDiff, index int, old bool) int {
+	for i := index - 1; i >= 0; i-- {
+		if old && diffs[i].OldLineNo > 0 {
+			return diffs[i].OldLineNo
+		} else if !old && diffs[i].NewLineNo > 0 {
+			return diffs[i].NewLineNo
+		}
+	}
+	return 0
+}
+
+// UnifiedDiff returns the unified diff (with the given lines of context)
+// turning oldText into newText or "" if they have the same lines.
+func UnifiedDiff(oldName, newName, oldText, newText string, context int) string {
+	hunks := GroupHunks(DiffLineSlices(SplitLines(oldText), SplitLines(newText)), context)
+	if len(hunks) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", oldName, newName)
+	for _, hunk := range hunks {
+		sb.WriteString(hunk.String())
+	}
+	return sb.String()
+}
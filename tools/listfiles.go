@@ -1,23 +1,62 @@
 package tools
 
 import (
+	"io/fs"
 	"os"
-	"path/filepath"
 )
 
+// Default cap on the number of entries returned by list_files
+const DefaultListMaxEntries = 1000
+
 type ListFiles struct {
 	BaseFileTool
 }
 
+// A FileEntry is a file or folder returned by list_files.
+type FileEntry struct {
+	Name string `json:"name"`
+
+	// Slash separated path relative to the folder being listed
+	Path string `json:"path"`
+
+	// One of "file", "dir" or "symlink"
+	Type string `json:"type"`
+	Size int64  `json:"size,omitempty"`
+
+	// Entries in a folder (only in the nested output)
+	Children []*FileEntry `json:"children,omitempty"`
+}
+
+// ListFilesResult is the result of a list_files call.
+type ListFilesResult struct {
+	Path    string       `json:"path"`
+	Entries []*FileEntry `json:"entries"`
+
+	// Number of entries returned (across all levels)
+	Count int `json:"count"`
+
+	// Whether the listing stopped early because max_entries was reached
+	Truncated bool `json:"truncated"`
+}
+
 func (r *ListFiles) Name() string {
 	return "list_files"
 }
 
+func (r *ListFiles) ReadOnly() bool {
+	return true
+}
+
 func (r *ListFiles) Description() string {
 	return `
-	Lists the files in a given folder provided by the 'path' parameter.   The 'recurse' parameter enables recursive file listing.
+	Lists the files in a given folder provided by the 'path' parameter.   The 'recurse' parameter enables recursive file listing (up to 'max_depth' levels).
+
+	Files and folders matched by .gitignore/.vibrantignore files as well as .git and node_modules are skipped unless 'respect_ignore' is false.
+	Use 'include' and 'exclude' globs (eg "**/*.go") to narrow down the listing.
 
-	Returns an error or a list of entries.  Each entry is a file or a folder.   Folder entries could recursively contain other entries.
+	Returns an error or a list of entries.  Each entry is a file or a folder with its name, path, type and size.   Folder entries recursively contain other entries
+	in 'children' unless 'flat' is true in which case all entries are returned in a single list.   If more than 'max_entries' entries are found the listing is
+	cut short and 'truncated' is set.
 	`
 }
 
@@ -33,6 +72,44 @@ func (r *ListFiles) Parameters() []*Parameter {
 			Name:        "recurse",
 			Description: "Whether to return files recursively.  This will help with minimizing number of tool calls if the folder content changes (file creations and deletions) are minimal",
 			Type:        "boolean",
+			Default:     false,
+		},
+		{
+			Name:        "max_depth",
+			Description: "Maximum depth to recurse to when 'recurse' is true (1 lists only the folder's own entries).  Unlimited if not specified.",
+			Type:        "integer",
+			Minimum:     Ptr(1.0),
+		},
+		{
+			Name:        "flat",
+			Description: "Return a flat list of entries (with paths relative to the folder) instead of a nested tree",
+			Type:        "boolean",
+			Default:     false,
+		},
+		{
+			Name:        "include",
+			Description: "Only list files matching one of these globs (relative to the folder, eg '**/*.go').  Folders are always traversed.",
+			Type:        "array",
+			Items:       &Parameter{Type: "string"},
+		},
+		{
+			Name:        "exclude",
+			Description: "Skip files and folders matching any of these globs (relative to the folder)",
+			Type:        "array",
+			Items:       &Parameter{Type: "string"},
+		},
+		{
+			Name:        "respect_ignore",
+			Description: "Whether to skip entries matched by .gitignore/.vibrantignore files as well as .git and node_modules folders",
+			Type:        "boolean",
+			Default:     true,
+		},
+		{
+			Name:        "max_entries",
+			Description: "Maximum number of entries to return",
+			Type:        "integer",
+			Default:     float64(DefaultListMaxEntries),
+			Minimum:     Ptr(1.0),
 		},
 	}
 }
@@ -52,41 +129,121 @@ func (r *ListFiles) Run(args map[string]any) (any, error) {
 	if args != nil && args["path"] != nil {
 		path = args["path"].(string)
 	}
-	dir, err := filepath.Abs(filepath.Join(r.ProjectRoot, path))
+	sandbox, relpath := r.Sandbox(path)
+	dir, err := sandbox.Resolve(relpath)
 	if err != nil {
 		return nil, err
 	}
 
-	var entries []any
-	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
+	recurse, _ := args["recurse"].(bool)
+	flat, _ := args["flat"].(bool)
+	respectIgnore := true
+	if val, ok := args["respect_ignore"].(bool); ok {
+		respectIgnore = val
+	}
+	maxEntries := intArg(args, "max_entries", DefaultListMaxEntries)
+	opts := WalkOptions{
+		MaxDepth:           intArg(args, "max_depth", 0),
+		Include:            stringsArg(args, "include"),
+		Exclude:            stringsArg(args, "exclude"),
+		RespectIgnoreFiles: respectIgnore,
+	}
+	if !recurse {
+		opts.MaxDepth = 1
+	}
+
+	result := &ListFilesResult{Path: path, Entries: []*FileEntry{}}
+	dirs := map[string]*FileEntry{}
+	err = WalkTree(sandbox, dir, opts, func(fullpath, rel string, depth int, entry fs.DirEntry) error {
+		if result.Count >= maxEntries {
+			result.Truncated = true
+			return fs.SkipAll
 		}
 
-		relPath, err := filepath.Rel(dir, path)
-		if err != nil {
-			return err
+		fe := &FileEntry{Name: entry.Name(), Path: rel, Type: "file"}
+		if entry.IsDir() {
+			fe.Type = "dir"
+			dirs[rel] = fe
+		} else if entry.Type()&os.ModeSymlink != 0 {
+			fe.Type = "symlink"
+		} else if info, err := entry.Info(); err == nil {
+			fe.Size = info.Size()
 		}
+		result.Count++
 
-		entry := map[string]any{}
-		if relPath != "." {
-			if info.IsDir() {
-				entry = map[string]any{
-					"is_folder": true,
-				}
-			} else {
-				entry = map[string]any{
-					"path": relPath,
-				}
-			}
-			entries = append(entries, entry)
+		if parent, ok := dirs[parentDir(rel)]; ok && !flat {
+			parent.Children = append(parent.Children, fe)
+		} else {
+			result.Entries = append(result.Entries, fe)
 		}
 		return nil
 	})
-
 	if err != nil {
 		return nil, err
 	}
 
-	return entries, err
+	// Folders that only exist to hold (filtered out) files are noise
+	if len(opts.Include) > 0 {
+		result.Entries = pruneEmptyDirs(result.Entries, result)
+		if result.Entries == nil {
+			result.Entries = []*FileEntry{}
+		}
+	}
+	return result, nil
+}
+
+// pruneEmptyDirs drops folders that do not (transitively) contain any files.
+func pruneEmptyDirs(entries []*FileEntry, result *ListFilesResult) []*FileEntry {
+	nonEmpty := map[string]bool{}
+	var mark func(entries []*FileEntry)
+	mark = func(entries []*FileEntry) {
+		for _, entry := range entries {
+			if entry.Type != "dir" {
+				for dir := parentDir(entry.Path); dir != "" && !nonEmpty[dir]; dir = parentDir(dir) {
+					nonEmpty[dir] = true
+				}
+			}
+			mark(entry.Children)
+		}
+	}
+	mark(entries)
+
+	var prune func(entries []*FileEntry) []*FileEntry
+	prune = func(entries []*FileEntry) (out []*FileEntry) {
+		for _, entry := range entries {
+			if entry.Type == "dir" && !nonEmpty[entry.Path] {
+				result.Count -= countEntries(entry)
+				continue
+			}
+			entry.Children = prune(entry.Children)
+			out = append(out, entry)
+		}
+		return
+	}
+	return prune(entries)
+}
+
+func countEntries(entry *FileEntry) int {
+	count := 1
+	for _, child := range entry.Children {
+		count += countEntries(child)
+	}
+	return count
+}
+
+// stringsArg returns a string list argument (which JSON decodes as a []any).
+func stringsArg(args map[string]any, name string) (out []string) {
+	switch val := args[name].(type) {
+	case []string:
+		return val
+	case []any:
+		for _, item := range val {
+			if str, ok := item.(string); ok {
+				out = append(out, str)
+			}
+		}
+	case string:
+		out = append(out, val)
+	}
+	return
 }
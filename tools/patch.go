@@ -0,0 +1,597 @@
+package tools
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// DevNull is the path used in diff headers for a missing side of a created
+// or deleted file.
+const DevNull = "/dev/null"
+
+// A Hunk is a single "@@ ... @@" section of a unified diff.
+type Hunk struct {
+	// Line ranges claimed by the header.  These are only hints - LLM
+	// generated diffs routinely get them wrong.
+	OldStart, OldLines int
+	NewStart, NewLines int
+
+	// Text after the closing "@@" (usually the enclosing function)
+	Section string
+
+	// The hunk's lines with their ' ', '+' or '-' prefix
+	Lines []string
+
+	// Set by "\ No newline at end of file" markers
+	NoNewlineAtEndOld bool
+	NoNewlineAtEndNew bool
+}
+
+// Header returns the hunk's "@@ -a,b +c,d @@" line (just "@@ @@" for hunks
+// without line numbers).
+func (h *Hunk) Header() string {
+	header := fmt.Sprintf("@@ -%d,%d +%d,%d @@", h.OldStart, h.OldLines, h.NewStart, h.NewLines)
+	if h.OldStart == 0 && h.NewStart == 0 && h.OldLines > 0 {
+		header = "@@ @@"
+	}
+	if h.Section != "" {
+		header += " " + h.Section
+	}
+	return header
+}
+
+// OldText returns the lines the hunk expects (context and deleted lines).
+func (h *Hunk) OldText() (out []string) {
+	for _, line := range h.Lines {
+		if line[0] != '+' {
+			out = append(out, line[1:])
+		}
+	}
+	return
+}
+
+// NewText returns the lines the hunk produces (context and added lines).
+func (h *Hunk) NewText() (out []string) {
+	for _, line := range h.Lines {
+		if line[0] != '-' {
+			out = append(out, line[1:])
+		}
+	}
+	return
+}
+
+// A FilePatch is the set of hunks for one file in a (possibly multi file) diff.
+type FilePatch struct {
+	// Paths from the ---/+++ headers without any a/ b/ prefixes.  One of
+	// them is DevNull for created and deleted files.
+	OldPath string
+	NewPath string
+	Hunks   []*Hunk
+
+	// Set by formats whose deletes only name the file (eg "*** Delete
+	// File") so the whole file goes.  Otherwise the hunks of a delete have to
+	// remove every line of the file.
+	DeletesWholeFile bool
+}
+
+// IsNew returns true if the patch creates a file.
+func (f *FilePatch) IsNew() bool {
+	return f.OldPath == DevNull
+}
+
+// IsDelete returns true if the patch deletes a file.
+func (f *FilePatch) IsDelete() bool {
+	return f.NewPath == DevNull
+}
+
+// IsRename returns true if the patch moves a file.
+func (f *FilePatch) IsRename() bool {
+	return !f.IsNew() && !f.IsDelete() && f.OldPath != "" && f.NewPath != "" && f.OldPath != f.NewPath
+}
+
+// Path returns the path of the file the patch applies to (the new path
+// unless the file is being deleted).
+func (f *FilePatch) Path() string {
+	if f.IsDelete() || f.NewPath == "" {
+		return f.OldPath
+	}
+	return f.NewPath
+}
+
+var hunkHeaderRegex = regexp.MustCompile(`^@@+ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@+ ?(.*)$`)
+
+// "@@" lines without line numbers (optionally followed by a section/anchor)
+var bareHunkHeaderRegex = regexp.MustCompile(`^@@(?:\s+(.*?))?(?:\s*@@)?\s*$`)
+
+// ParseUnifiedDiff parses a unified diff (as produced by diff -u or git diff)
+// into one FilePatch per file.  Hunks without any file headers are returned
+// in a single FilePatch with empty paths.  Parsing is lenient about the
+// mistakes LLMs tend to make (wrong line counts, missing blank context line
+// prefixes, chatter around the diff).
+func ParseUnifiedDiff(diff string) ([]*FilePatch, error) {
+	return parseUnifiedDiff(diff, false)
+}
+
+// parseUnifiedDiff parses unified diffs.  In bare mode hunks do not need
+// "@@" headers - any run of ' ', '+' and '-' prefixed lines is a hunk.
+func parseUnifiedDiff(diff string, bare bool) ([]*FilePatch, error) {
+	lines := strings.Split(strings.ReplaceAll(diff, "\r\n", "\n"), "\n")
+	var patches []*FilePatch
+	var current *FilePatch
+	var hunk *Hunk
+	// Lines of the old and new file the current hunk's header says are still
+	// to come.  Until they have all been seen, lines like "--- x" (a removed
+	// "-- x") belong to the hunk rather than starting another file - unless a
+	// hunk header follows them, which no hunk line looks like.
+	var oldLeft, newLeft int
+	fileHeader := func(i int) bool {
+		if !strings.HasPrefix(lines[i], "--- ") || i+1 >= len(lines) || !strings.HasPrefix(lines[i+1], "+++ ") {
+			return false
+		}
+		return oldLeft == 0 && newLeft == 0 || i+2 < len(lines) && hunkHeaderRegex.MatchString(lines[i+2])
+	}
+
+	newFile := func() *FilePatch {
+		current = &FilePatch{}
+		patches = append(patches, current)
+		hunk, oldLeft, newLeft = nil, 0, 0
+		return current
+	}
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			fp := newFile()
+			if parts := strings.Fields(line); len(parts) == 4 {
+				fp.OldPath, fp.NewPath = parts[2], parts[3]
+			}
+			continue
+		case fileHeader(i):
+			// A diff --git line may already have started this file
+			if current == nil || len(current.Hunks) > 0 || hunk != nil {
+				newFile()
+			}
+			current.OldPath = parseHeaderPath(line[4:])
+			current.NewPath = parseHeaderPath(lines[i+1][4:])
+			i++
+			if bare {
+				// The next prefixed lines start a hunk even without a "@@" line
+				hunk = &Hunk{}
+				current.Hunks = append(current.Hunks, hunk)
+			}
+			continue
+		case hunk == nil && (strings.HasPrefix(line, "rename from ") || strings.HasPrefix(line, "rename to ")):
+			// Pure renames (git diff) carry no hunks
+			if current == nil {
+				newFile()
+			}
+			if path, ok := strings.CutPrefix(line, "rename from "); ok {
+				current.OldPath = path
+			} else {
+				current.NewPath = strings.TrimPrefix(line, "rename to ")
+			}
+			continue
+		}
+
+		if match := hunkHeaderRegex.FindStringSubmatch(line); match != nil {
+			if current == nil {
+				newFile()
+			}
+			hunk = &Hunk{
+				OldStart: atoiOr(match[1], 0),
+				OldLines: atoiOr(match[2], 1),
+				NewStart: atoiOr(match[3], 0),
+				NewLines: atoiOr(match[4], 1),
+				Section:  strings.TrimSpace(match[5]),
+			}
+			current.Hunks = append(current.Hunks, hunk)
+			oldLeft, newLeft = hunk.OldLines, hunk.NewLines
+			continue
+		}
+		if match := bareHunkHeaderRegex.FindStringSubmatch(line); match != nil {
+			if current == nil {
+				newFile()
+			}
+			hunk = &Hunk{Section: strings.TrimSpace(match[1])}
+			current.Hunks = append(current.Hunks, hunk)
+			oldLeft, newLeft = 0, 0
+			continue
+		}
+		if hunk == nil && bare && line != "" && strings.ContainsRune(" +-", rune(line[0])) {
+			if current == nil {
+				newFile()
+			}
+			hunk = &Hunk{}
+			current.Hunks = append(current.Hunks, hunk)
+		}
+		if hunk == nil {
+			// Chatter before the first hunk (index lines, mode changes, prose)
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(line, `\`):
+			// "\ No newline at end of file" applies to the line before it
+			if len(hunk.Lines) > 0 {
+				last := hunk.Lines[len(hunk.Lines)-1][0]
+				if last != '+' {
+					hunk.NoNewlineAtEndOld = true
+				}
+				if last != '-' {
+					hunk.NoNewlineAtEndNew = true
+				}
+			}
+		case line == "":
+			// Editors (and LLMs) strip the single space of blank context lines
+			hunk.Lines = append(hunk.Lines, " ")
+			oldLeft, newLeft = max(oldLeft-1, 0), max(newLeft-1, 0)
+		case line[0] == ' ' || line[0] == '+' || line[0] == '-':
+			hunk.Lines = append(hunk.Lines, line)
+			if line[0] != '+' {
+				oldLeft = max(oldLeft-1, 0)
+			}
+			if line[0] != '-' {
+				newLeft = max(newLeft-1, 0)
+			}
+		default:
+			// Anything else ends the hunk
+			hunk = nil
+			oldLeft, newLeft = 0, 0
+		}
+	}
+
+	for _, fp := range patches {
+		stripDiffPrefixes(fp)
+		fp.Hunks = slices.DeleteFunc(fp.Hunks, func(h *Hunk) bool {
+			trimHunk(h)
+			return len(h.Lines) == 0
+		})
+	}
+	patches = withChanges(patches)
+	if len(patches) == 0 {
+		return nil, fmt.Errorf("no hunks or file headers found in the diff")
+	}
+	return patches, nil
+}
+
+// trimHunk drops trailing blank context lines beyond the hunk's declared
+// size - these are usually just the blank lines separating it from whatever
+// follows.  Hunks without line numbers get all of them dropped and their
+// sizes filled in.
+func trimHunk(h *Hunk) {
+	numbered := h.OldStart != 0 || h.NewStart != 0
+	for len(h.Lines) > 0 && h.Lines[len(h.Lines)-1] == " " && (!numbered || len(h.OldText()) > h.OldLines) {
+		h.Lines = h.Lines[:len(h.Lines)-1]
+	}
+	if !numbered {
+		h.OldLines, h.NewLines = len(h.OldText()), len(h.NewText())
+	}
+}
+
+func withChanges(patches []*FilePatch) (out []*FilePatch) {
+	for _, fp := range patches {
+		if len(fp.Hunks) > 0 || fp.IsDelete() || fp.IsRename() {
+			out = append(out, fp)
+		}
+	}
+	return
+}
+
+// parseHeaderPath extracts the path from a ---/+++ header (dropping any
+// timestamp).
+func parseHeaderPath(header string) string {
+	if tab := strings.Index(header, "\t"); tab >= 0 {
+		header = header[:tab]
+	}
+	header = strings.TrimSpace(header)
+	if unquoted, err := strconv.Unquote(header); err == nil {
+		header = unquoted
+	}
+	return header
+}
+
+// stripDiffPrefixes drops the a/ and b/ prefixes git adds to paths (only if
+// both sides have them so real "a/..." paths survive).
+func stripDiffPrefixes(fp *FilePatch) {
+	oldOk := fp.OldPath == DevNull || strings.HasPrefix(fp.OldPath, "a/")
+	newOk := fp.NewPath == DevNull || strings.HasPrefix(fp.NewPath, "b/")
+	if oldOk && newOk {
+		fp.OldPath = strings.TrimPrefix(fp.OldPath, "a/")
+		fp.NewPath = strings.TrimPrefix(fp.NewPath, "b/")
+	}
+}
+
+func atoiOr(s string, def int) int {
+	if n, err := strconv.Atoi(s); err == nil {
+		return n
+	}
+	return def
+}
+
+// Possible outcomes of applying a hunk
+const (
+	HunkClean      = "clean"
+	HunkFuzzy      = "fuzzy"
+	HunkConflicted = "conflicted"
+	HunkFailed     = "failed"
+)
+
+// Hunks matching with a confidence below this are written as conflicts (and
+// fuzzy edits are rejected)
+const DefaultMinConfidence = 0.7
+
+// HunkResult reports how a hunk was applied.
+type HunkResult struct {
+	Header string `json:"header"`
+
+	// One of HunkClean (every context and deleted line was found verbatim),
+	// HunkFuzzy (applied though some lines were missing or only matched
+	// approximately), HunkConflicted (too uncertain to apply - written as
+	// conflict markers instead) or HunkFailed (not found in the file at all)
+	Status string `json:"status"`
+
+	// How well (0-1) the hunk matched the file (see EditCosts.Confidence)
+	Confidence float64 `json:"confidence"`
+
+	// Line of the file (as it was when the hunk was applied) the hunk (or its
+	// conflict region) starts at
+	StartLine int `json:"start_line,omitempty"`
+
+	// Context/deleted lines of the hunk found in and missing from the file
+	Matched int `json:"matched"`
+	Missing int `json:"missing"`
+
+	// Alignment cost (see EditCosts)
+	Cost    int    `json:"cost"`
+	Message string `json:"message,omitempty"`
+
+	// What the reviewer (if the patch was reviewed - see HunkReviewer) decided
+	// along with the reason given for rejecting it and the hunk as edited
+	Decision string `json:"decision,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+	Edited   string `json:"edited,omitempty"`
+
+	// How the hunk's lines were aligned with the file (with line numbers of
+	// the file) - see ExplainPatch
+	Alignment []AlignedLine `json:"-"`
+}
+
+// ApplyHunks applies hunks in order to lines returning the patched lines and
+// a result per hunk.  Hunks that fail are skipped (the others are still
+// applied).  A nil costs uses DefaultCostModel.
+func ApplyHunks(lines []string, hunks []*Hunk, costs *CostModel, minConfidence float64) ([]string, []*HunkResult) {
+	var results []*HunkResult
+	for _, hunk := range hunks {
+		var result *HunkResult
+		lines, result = ApplyHunk(lines, hunk, costs, minConfidence)
+		results = append(results, result)
+	}
+	return lines, results
+}
+
+// ApplyHunk applies a single hunk with a fuzzy alignment (EditCosts) of the
+// hunk against the region of the file most similar to the hunk's old text.
+// Line numbers in the hunk header are only used for hunks without any
+// context or deleted lines.  Rather than guessing, hunks that align with a
+// confidence below minConfidence replace the lines they cover with git style
+// conflict markers holding both the file's lines and the hunk's new text.
+func ApplyHunk(lines []string, hunk *Hunk, costs *CostModel, minConfidence float64) ([]string, *HunkResult) {
+	result := &HunkResult{Header: hunk.Header(), Confidence: 1}
+	oldText := hunk.OldText()
+	if len(oldText) == 0 {
+		// Nothing to anchor on - trust the line numbers
+		at := hunk.OldStart
+		if hunk.OldLines > 0 {
+			at--
+		}
+		at = max(0, min(at, len(lines)))
+		result.Status, result.StartLine = HunkClean, at+1
+		for k, line := range hunk.NewText() {
+			result.Alignment = append(result.Alignment, AlignedLine{Type: ADDED_FROM_PATCH, PatchLine: line, PatchLineNo: k + 1, InputLineNo: at})
+		}
+		return splice(lines, at, at, hunk.NewText()), result
+	}
+
+	match, _ := matchBlock(lines, hunk.Lines, oldText, hunk.OldStart-1, costs, 1)
+	if match == nil {
+		result.Status = HunkFailed
+		result.Message = "none of the hunk's context or deleted lines were found in the file"
+		return lines, result
+	}
+	start, end := match.start, match.end
+	result.Cost, result.Alignment = match.cost, match.alignment
+
+	deletesMissing, approximate := 0, 0
+	for _, aligned := range result.Alignment {
+		switch aligned.Type {
+		case CONTEXT_MATCHED, DELETED_INPUT_MATCHED_PATCH:
+			result.Matched++
+			if aligned.InputLine != aligned.PatchLine {
+				approximate++
+			}
+		case CONTEXT_SKIPPED_PATCH_OP:
+			result.Missing++
+		case DELETED_INPUT_UNMATCHED_PATCH, DELETE_SKIPPED_PATCH_OP:
+			result.Missing++
+			deletesMissing++
+		}
+	}
+	if result.Matched == 0 {
+		result.Status = HunkFailed
+		result.Message = "none of the hunk's context or deleted lines were found in the file"
+		return lines, result
+	}
+
+	first, last := match.span()
+	result.Confidence = match.confidence
+	result.StartLine = first + 1
+	var problems []string
+	if deletesMissing > 0 {
+		problems = append(problems, fmt.Sprintf("%d of the lines to be deleted were not found", deletesMissing))
+	}
+	if contextMissing := result.Missing - deletesMissing; contextMissing > 0 {
+		problems = append(problems, fmt.Sprintf("%d context lines were not found", contextMissing))
+	}
+	if approximate > 0 {
+		problems = append(problems, fmt.Sprintf("%d lines only matched approximately", approximate))
+	}
+	result.Message = strings.Join(problems, ", ")
+
+	switch {
+	case result.Confidence < minConfidence:
+		result.Status = HunkConflicted
+		result.Message += fmt.Sprintf(" - written as conflict markers around lines %d-%d", result.StartLine, last+1)
+		region := conflictRegion(lines[first:last+1], hunk, result.Confidence)
+		return splice(lines, first, last+1, region), result
+	case result.Confidence < 1 || len(problems) > 0:
+		result.Status = HunkFuzzy
+	default:
+		result.Status = HunkClean
+	}
+	return splice(lines, start, end, match.ec.ApplyPatchToInput()), result
+}
+
+// conflictRegion returns git style conflict markers offering the choice
+// between the current lines of the file and the hunk's new text.
+func conflictRegion(current []string, hunk *Hunk, confidence float64) []string {
+	out := append([]string{"<<<<<<< current"}, current...)
+	out = append(out, "=======")
+	out = append(out, hunk.NewText()...)
+	return append(out, fmt.Sprintf(">>>>>>> patch %s (%.0f%% confidence)", hunk.Header(), confidence*100))
+}
+
+// Most places in a file matchBlock considers aligning a block of lines at
+const maxBlockCandidates = 16
+
+// A blockMatch is how the lines of a hunk (or an edit) aligned with the
+// window of a file its context and deleted lines were found in.
+type blockMatch struct {
+	ec *EditCosts
+
+	// The window of the file aligned
+	start, end int
+
+	// The alignment with line numbers of the file rather than the window
+	alignment []AlignedLine
+
+	cost       int
+	confidence float64
+}
+
+// matchBlock aligns diffLines (whose context and deleted lines are block)
+// with a window of lines around where block most likely starts (see
+// blockCandidates) - never the whole file as the alignment is quadratic.
+// Upto tries windows (not overlapping each other) are aligned and the match
+// with the highest confidence (see EditCosts.Confidence) is returned along
+// with the runner up.  Hunks and edits are matched the same way so their
+// confidences are comparable.  No match is returned if none of the lines of
+// block are anywhere near any line of the file.
+func matchBlock(lines, diffLines, block []string, hint int, costs *CostModel, tries int) (best, runnerUp *blockMatch) {
+	var tried []int
+	for _, offset := range blockCandidates(lines, block, hint, maxBlockCandidates) {
+		if len(tried) == tries {
+			break
+		}
+		if slices.ContainsFunc(tried, func(at int) bool { return abs(at-offset) < len(block) }) {
+			continue
+		}
+		tried = append(tried, offset)
+
+		// Allow some slack for lines the block does not know about
+		slack := len(block)/2 + 2
+		match := &blockMatch{start: max(0, offset-slack), end: min(len(lines), offset+len(block)+slack)}
+		match.ec = (&EditCosts{InLines: lines[match.start:match.end], DiffLines: diffLines, Costs: costs}).Init()
+		match.cost = match.ec.Dist()
+		match.alignment = match.ec.GetAlignment()
+		for k := range match.alignment {
+			match.alignment[k].InputLineNo += match.start
+		}
+		match.confidence = match.ec.Confidence()
+		switch {
+		case best == nil:
+			best = match
+		case match.confidence > best.confidence:
+			best, runnerUp = match, best
+		case runnerUp == nil || match.confidence > runnerUp.confidence:
+			runnerUp = match
+		}
+	}
+	return best, runnerUp
+}
+
+// span returns the first and last lines (0 based) of the file the block
+// matched or deleted (-1 if none).
+func (m *blockMatch) span() (first, last int) {
+	first, last = -1, -1
+	for _, aligned := range m.alignment {
+		switch aligned.Type {
+		case CONTEXT_MATCHED, DELETED_INPUT_MATCHED_PATCH, DELETED_INPUT_UNMATCHED_PATCH:
+			if first < 0 {
+				first = aligned.InputLineNo - 1
+			}
+			last = aligned.InputLineNo - 1
+		}
+	}
+	return first, last
+}
+
+// blockCandidates returns (upto limit of) the lines of the file block most
+// likely starts at, best first.  Each line of the file that (ignoring
+// surrounding whitespace) equals a line of block votes for the offset that
+// would line the two up and offsets with the most votes (closest to hint on
+// ties) come first.  Lines like "}" or blank lines only vote if the block has
+// nothing more distinctive.  If no line matches verbatim (eg every line was
+// retyped) the trigrams the lines share vote instead (see fuzzyCandidates).
+func blockCandidates(lines, block []string, hint, limit int) []int {
+	positions := map[string][]int{}
+	distinctive := false
+	for k, line := range block {
+		line = strings.TrimSpace(line)
+		positions[line] = append(positions[line], k)
+		distinctive = distinctive || len(line) > 3
+	}
+
+	votes := map[int]int{}
+	var offsets []int
+	for j, line := range lines {
+		line = strings.TrimSpace(line)
+		if distinctive && len(line) <= 3 {
+			continue
+		}
+		for _, k := range positions[line] {
+			if votes[j-k] == 0 {
+				offsets = append(offsets, j-k)
+			}
+			votes[j-k]++
+		}
+	}
+	if len(offsets) == 0 {
+		return fuzzyCandidates(lines, block, hint, limit)
+	}
+	slices.SortFunc(offsets, func(a, b int) int {
+		if votes[a] != votes[b] {
+			return votes[b] - votes[a]
+		}
+		return abs(a-hint) - abs(b-hint)
+	})
+	return offsets[:min(limit, len(offsets))]
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// splice returns lines with lines[start:end] replaced by replacement.
+func splice(lines []string, start, end int, replacement []string) []string {
+	out := make([]string, 0, len(lines)-(end-start)+len(replacement))
+	out = append(out, lines[:start]...)
+	out = append(out, replacement...)
+	return append(out, lines[end:]...)
+}
@@ -0,0 +1,346 @@
+package tools
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const multiFileDiff = `Here is the change you asked for:
+
+diff --git a/main.go b/main.go
+index 83db48f..bf269f4 100644
+--- a/main.go
++++ b/main.go
+@@ -1,6 +1,7 @@
+ package main
+ 
+ import "fmt"
++import "os"
+ 
+ func main() {
+ 	fmt.Println("hello")
+@@ -20,3 +21,3 @@ func helper() {
+ func helper() {
+-	return 1
++	return 2
+ }
+--- /dev/null
++++ b/docs/new.md
+@@ -0,0 +1,2 @@
++# New
++doc
+diff --git a/old.txt b/old.txt
+deleted file mode 100644
+--- a/old.txt
++++ /dev/null
+@@ -1 +0,0 @@
+-bye
+`
+
+func TestParseUnifiedDiff(t *testing.T) {
+	patches, err := ParseUnifiedDiff(multiFileDiff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(patches) != 3 {
+		t.Fatalf("expected 3 file patches, got %d", len(patches))
+	}
+	if p := patches[0]; p.Path() != "main.go" || len(p.Hunks) != 2 || p.IsNew() || p.IsDelete() {
+		t.Errorf("unexpected first patch: %+v", p)
+	}
+	if h := patches[0].Hunks[0]; h.OldStart != 1 || h.OldLines != 6 || len(h.Lines) != 7 || h.Lines[1] != " " {
+		t.Errorf("unexpected first hunk: %+v", h)
+	}
+	if h := patches[0].Hunks[1]; h.Section != "func helper() {" || len(h.Lines) != 4 {
+		t.Errorf("expected the blank separator line to be trimmed, got %+v", h)
+	}
+	if p := patches[1]; !p.IsNew() || p.Path() != "docs/new.md" {
+		t.Errorf("expected a new file, got %+v", p)
+	}
+	if p := patches[2]; !p.IsDelete() || p.Path() != "old.txt" {
+		t.Errorf("expected a deleted file, got %+v", p)
+	}
+
+	if _, err := ParseUnifiedDiff("no diff here"); err == nil {
+		t.Errorf("expected an error for text without hunks")
+	}
+
+	// The hunk header's counts tell a removed "-- x" and an added "++ y" from
+	// the headers of another file
+	patches, err = ParseUnifiedDiff("--- a/notes.md\n+++ b/notes.md\n@@ -1,3 +1,3 @@\n title\n--- x\n+++ y\n end\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(patches) != 1 || len(patches[0].Hunks) != 1 || strings.Join(patches[0].Hunks[0].Lines, "|") != " title|--- x|+++ y| end" {
+		t.Errorf("expected a single hunk removing \"-- x\", got %+v", patches[0])
+	}
+	// ... but headers followed by a hunk header still start a file when the
+	// counts are too large
+	patches, err = ParseUnifiedDiff("--- a/a.txt\n+++ b/a.txt\n@@ -1,5 +1,5 @@\n-a\n+b\n--- a/b.txt\n+++ b/b.txt\n@@ -1 +1 @@\n-c\n+d\n")
+	if err != nil || len(patches) != 2 || patches[1].Path() != "b.txt" {
+		t.Errorf("expected two files despite the miscounted hunk, got %+v (%v)", patches, err)
+	}
+}
+
+func TestApplyFileDiff(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		// main.go has drifted from what the diff expects (a comment was
+		// added and the helper moved further down)
+		"main.go": "package main\n\nimport \"fmt\"\n\n// main is the entry point\nfunc main() {\n\tfmt.Println(\"hello\")\n}\n\n\n\nfunc helper() {\n\treturn 1\n}\n",
+		"old.txt": "bye\n",
+	})
+	registry := NewRegistry(NewWorkspace(root))
+	read := func(name string) string {
+		data, _ := os.ReadFile(filepath.Join(root, name))
+		return string(data)
+	}
+
+	env := registry.Invoke(t.Context(), "apply_file_diff", map[string]any{"diff": multiFileDiff})
+	if !env.Ok {
+		t.Fatalf("expected the diff to apply, got %s", env)
+	}
+	expected := "package main\n\nimport \"fmt\"\nimport \"os\"\n\n// main is the entry point\nfunc main() {\n\tfmt.Println(\"hello\")\n}\n\n\n\nfunc helper() {\n\treturn 2\n}\n"
+	if got := read("main.go"); got != expected {
+		t.Errorf("main.go mismatch:\nExpected:\n%s\nGot:\n%s", expected, got)
+	}
+	if got := read("docs/new.md"); got != "# New\ndoc\n" {
+		t.Errorf("unexpected new file: %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(root, "old.txt")); !os.IsNotExist(err) {
+		t.Errorf("expected old.txt to be deleted")
+	}
+	files := env.Result.(*ApplyDiffResult).Files
+	// Line numbers are those of the file as each hunk is applied (after the import was added)
+	if files[0].Hunks[0].Status != HunkClean || files[0].Hunks[1].Status != HunkClean || files[0].Hunks[1].StartLine != 13 {
+		t.Errorf("unexpected hunk results: %+v %+v", files[0].Hunks[0], files[0].Hunks[1])
+	}
+
+	// Context lines that are not found make the hunk fuzzy
+	stale := "--- main.go\n+++ main.go\n@@ -5,4 +5,4 @@\n // main is where it all starts\n func main() {\n-\tfmt.Println(\"hello\")\n+\tfmt.Println(\"hi\")\n }\n"
+	env = registry.Invoke(t.Context(), "apply_file_diff", map[string]any{"diff": stale})
+	if !env.Ok || len(env.Warnings) != 1 || !strings.Contains(read("main.go"), `"hi"`) {
+		t.Fatalf("expected a fuzzy hunk to apply, got %s", env)
+	}
+	if hunk := env.Result.(*ApplyDiffResult).Files[0].Hunks[0]; hunk.Status != HunkFuzzy || hunk.Missing != 1 || hunk.Matched != 3 {
+		t.Errorf("unexpected fuzzy hunk result: %+v", hunk)
+	}
+
+	// Hunks without headers need a path and a failing hunk changes nothing
+	before := read("main.go")
+	bad := "@@ -3,1 +3,1 @@\n-import \"strings\"\n+import \"bytes\"\n"
+	env = registry.Invoke(t.Context(), "apply_file_diff", map[string]any{"diff": bad})
+	if env.Ok || !strings.Contains(env.Error.Message, "path") {
+		t.Errorf("expected a missing path error, got %s", env)
+	}
+	twoHunks := "@@ -1,3 +1,3 @@\n package main\n \n-import \"fmt\"\n+import \"log\"\n" + bad
+	env = registry.Invoke(t.Context(), "apply_file_diff", map[string]any{"diff": twoHunks, "path": "main.go"})
+	if env.Ok || env.Error.Code != ErrCodePatchFailed || read("main.go") != before {
+		t.Errorf("expected the patch to fail without changes, got %s", env)
+	}
+	if report := env.Error.Details.(*ApplyDiffResult); report.Files[0].Hunks[0].Status == HunkFailed || report.Files[0].Hunks[1].Status != HunkFailed {
+		t.Errorf("expected only the second hunk to fail, got %s", env)
+	}
+
+	// Hunks that are found but match too poorly become conflicts
+	uncertain := "@@ -1,2 +1,2 @@\n package main\n-import \"strings\"\n+import \"bytes\"\n"
+	env = registry.Invoke(t.Context(), "apply_file_diff", map[string]any{"diff": uncertain, "path": "main.go"})
+	if !env.Ok || len(env.Warnings) != 1 {
+		t.Fatalf("expected the hunk to be written as a conflict, got %s", env)
+	}
+	hunk := env.Result.(*ApplyDiffResult).Files[0].Hunks[0]
+	if hunk.Status != HunkConflicted || hunk.Confidence >= DefaultMinConfidence || hunk.StartLine != 1 {
+		t.Errorf("unexpected conflicted hunk result: %+v", hunk)
+	}
+	expected = "<<<<<<< current\npackage main\n=======\npackage main\nimport \"bytes\"\n>>>>>>> patch @@ -1,2 +1,2 @@ (33% confidence)\n" + before[len("package main\n"):]
+	if got := read("main.go"); got != expected {
+		t.Errorf("conflict mismatch:\nExpected:\n%s\nGot:\n%s", expected, got)
+	}
+}
+
+func TestApplyFileDiff_Delete(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{"old.txt": "one\ntwo\nthree\n"})
+	registry := NewRegistry(NewWorkspace(root))
+	apply := func(diff string) *Envelope {
+		return registry.Invoke(t.Context(), "apply_file_diff", map[string]any{"diff": diff})
+	}
+
+	// Deletes have to remove every line of the file
+	for _, diff := range []string{
+		"--- a/old.txt\n+++ /dev/null\n@@ -1,1 +0,0 @@\n-one\n",
+		"--- a/old.txt\n+++ /dev/null\n@@ -1,3 +0,0 @@\n-one\n-two\n-four\n",
+		"diff --git a/old.txt b/old.txt\ndeleted file mode 100644\n--- a/old.txt\n+++ /dev/null\n",
+	} {
+		env := apply(diff)
+		if env.Ok || env.Error.Code != ErrCodePatchFailed || !strings.Contains(env.Error.Message, "do not remove all of its 3 lines") {
+			t.Errorf("expected the partial delete to fail, got %s", env)
+		}
+		if _, err := os.Stat(filepath.Join(root, "old.txt")); err != nil {
+			t.Fatalf("expected old.txt to be kept: %v", err)
+		}
+	}
+
+	env := apply("--- a/old.txt\n+++ /dev/null\n@@ -1,3 +0,0 @@\n-one\n-two\n-three\n")
+	if !env.Ok || env.Result.(*ApplyDiffResult).Files[0].Action != "deleted" {
+		t.Fatalf("expected the file to be deleted, got %s", env)
+	}
+	if _, err := os.Stat(filepath.Join(root, "old.txt")); !os.IsNotExist(err) {
+		t.Errorf("expected old.txt to be deleted")
+	}
+}
+
+func TestApplyFileDiff_Rollback(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("one\ntwo\n"), 0644)
+	// A file where a directory is needed so writing under it fails (even as root)
+	os.WriteFile(filepath.Join(dir, "blocked"), []byte("not a directory\n"), 0644)
+	registry := NewRegistry(NewWorkspace(dir))
+	diff := "--- a/a.txt\n+++ b/a.txt\n@@ -1,2 +1,2 @@\n one\n-two\n+three\n" +
+		"--- /dev/null\n+++ b/new.txt\n@@ -0,0 +1 @@\n+new\n" +
+		"--- /dev/null\n+++ b/blocked/c.txt\n@@ -0,0 +1 @@\n+sea\n"
+
+	env := registry.Invoke(t.Context(), "apply_file_diff", map[string]any{"diff": diff})
+	if env.Ok || !strings.Contains(env.Error.Message, "blocked/c.txt") {
+		t.Fatalf("expected the third file to fail, got %s", env)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "a.txt")); string(data) != "one\ntwo\n" {
+		t.Errorf("expected a.txt to be put back, got %q", data)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "new.txt")); !os.IsNotExist(err) {
+		t.Errorf("expected new.txt to be removed, got %v", err)
+	}
+	if changes, _ := registry.Journal.Changes(); len(changes) != 0 {
+		t.Errorf("expected nothing to be journaled, got %d changes", len(changes))
+	}
+}
+
+// largePatch returns a file of n lines and a diff changing every 40th of
+// them in hunks of patchLines lines in total (so with the 3 lines of context
+// either side each hunk has 7 lines).
+func largePatch(n, patchLines int) (file []string, diff string, expected []string) {
+	for i := range n {
+		file = append(file, fmt.Sprintf("line %d: value = %d", i, i*7%13))
+	}
+	expected = append(expected, file...)
+	var sb strings.Builder
+	sb.WriteString("--- big.txt\n+++ big.txt\n")
+	for at := 40; patchLines > 0 && at+4 < n; at += 40 {
+		fmt.Fprintf(&sb, "@@ -%d,7 +%d,7 @@\n", at-2, at-2)
+		for i := at - 3; i < at; i++ {
+			sb.WriteString(" " + file[i] + "\n")
+		}
+		fmt.Fprintf(&sb, "-%s\n+changed %d\n", file[at], at)
+		for i := at + 1; i <= at+3; i++ {
+			sb.WriteString(" " + file[i] + "\n")
+		}
+		expected[at] = fmt.Sprintf("changed %d", at)
+		patchLines -= 8
+	}
+	return file, sb.String(), expected
+}
+
+func TestApplyHunks_LargeFile(t *testing.T) {
+	file, diff, expected := largePatch(20000, 500)
+	patches, err := ParseUnifiedDiff(diff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Shift the file so none of the hunk line numbers are right
+	shifted := append([]string{"// header", "// more header"}, file...)
+	out, results := ApplyHunks(shifted, patches[0].Hunks, nil, DefaultMinConfidence)
+	for i, result := range results {
+		if result.Status != HunkClean {
+			t.Fatalf("hunk %d: %+v", i, result)
+		}
+	}
+	if strings.Join(out[2:], "\n") != strings.Join(expected, "\n") {
+		t.Errorf("large file was not patched as expected")
+	}
+}
+
+// A single hunk rewriting 500 lines in the middle of a 20k line file
+func largeHunk(n, size int) ([]string, *Hunk) {
+	file := make([]string, n)
+	for i := range file {
+		file[i] = fmt.Sprintf("line %d: value = %d", i, i*7%13)
+	}
+	hunk := &Hunk{}
+	at := n / 2
+	for i := at; i < at+size; i++ {
+		if i%5 == 0 {
+			hunk.Lines = append(hunk.Lines, "-"+file[i], "+"+strings.ToUpper(file[i]))
+		} else {
+			hunk.Lines = append(hunk.Lines, " "+file[i])
+		}
+	}
+	trimHunk(hunk)
+	return file, hunk
+}
+
+// A hunk of size lines in the middle of a n line file none of whose lines
+// match the file verbatim (as if retyped) so it cannot be anchored - only
+// the lenient cost profile matches its lines.
+func unanchoredHunk(n, size int) ([]string, *Hunk) {
+	file := make([]string, n)
+	for i := range file {
+		file[i] = fmt.Sprintf("line %d: value = %d", i, i*7%13)
+	}
+	hunk := &Hunk{}
+	at := n / 2
+	for i := at; i < at+size; i++ {
+		retyped := strings.Replace(file[i], " = ", " := ", 1)
+		if i%5 == 0 {
+			hunk.Lines = append(hunk.Lines, "-"+retyped, "+"+strings.ToUpper(file[i]))
+		} else {
+			hunk.Lines = append(hunk.Lines, " "+retyped)
+		}
+	}
+	return file, hunk
+}
+
+func TestApplyHunk_Unanchored(t *testing.T) {
+	file, hunk := unanchoredHunk(20000, 200)
+	out, result := ApplyHunk(file, hunk, costProfiles[ProfileLenient], DefaultMinConfidence)
+	if result.Status != HunkFuzzy || result.StartLine != 10001 || result.Missing != 0 {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+	if out[10000] != strings.ToUpper(file[10000]) || out[10001] != file[10001] || len(out) != len(file) {
+		t.Errorf("hunk was not applied where expected: %q", out[9999:10002])
+	}
+}
+
+func BenchmarkApplyHunks_ManyHunks(b *testing.B) {
+	file, diff, _ := largePatch(20000, 500)
+	patches, err := ParseUnifiedDiff(diff)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	for b.Loop() {
+		ApplyHunks(file, patches[0].Hunks, nil, DefaultMinConfidence)
+	}
+}
+
+func BenchmarkApplyHunk_LargeHunk(b *testing.B) {
+	file, hunk := largeHunk(20000, 500)
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, result := ApplyHunk(file, hunk, nil, DefaultMinConfidence); result.Status != HunkClean {
+			b.Fatalf("unexpected result: %+v", result)
+		}
+	}
+}
+
+func BenchmarkApplyHunk_Unanchored(b *testing.B) {
+	file, hunk := unanchoredHunk(20000, 200)
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, result := ApplyHunk(file, hunk, costProfiles[ProfileLenient], DefaultMinConfidence); result.Status != HunkFuzzy {
+			b.Fatalf("unexpected result: %+v", result)
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package tools
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Patch dialects understood by ParsePatch
+const (
+	// diff -u / git diff output
+	PatchFormatUnified = "unified"
+
+	// aider style "<<<<<<< SEARCH / ======= / >>>>>>> REPLACE" blocks
+	PatchFormatSearchReplace = "search_replace"
+
+	// "*** Begin Patch / *** Update File: ... / *** End Patch" envelopes
+	PatchFormatEnvelope = "envelope"
+
+	// ' ', '+' and '-' prefixed lines without "@@ -a,b +c,d @@" line numbers
+	PatchFormatBareHunks = "bare_hunks"
+)
+
+var (
+	searchMarkerRegex  = regexp.MustCompile(`^<{5,9} ?SEARCH\s*$`)
+	dividerMarkerRegex = regexp.MustCompile(`^={5,9}\s*$`)
+	replaceMarkerRegex = regexp.MustCompile(`^>{5,9} ?REPLACE\s*$`)
+)
+
+// DetectPatchFormat guesses which dialect a patch is written in.
+func DetectPatchFormat(patch string) string {
+	for _, line := range SplitLines(patch) {
+		line = strings.TrimRight(line, "\r")
+		switch {
+		case strings.TrimSpace(line) == "*** Begin Patch":
+			return PatchFormatEnvelope
+		case searchMarkerRegex.MatchString(line):
+			return PatchFormatSearchReplace
+		case hunkHeaderRegex.MatchString(line):
+			return PatchFormatUnified
+		}
+	}
+	return PatchFormatBareHunks
+}
+
+// ParsePatch detects the dialect of a patch and parses it into one FilePatch
+// per file, returning the detected format.  Whatever the dialect, hunks are
+// normalized to ' ', '+' and '-' prefixed lines so they can be applied with
+// ApplyHunks.
+func ParsePatch(patch string) ([]*FilePatch, string, error) {
+	format := DetectPatchFormat(patch)
+	var patches []*FilePatch
+	var err error
+	switch format {
+	case PatchFormatEnvelope:
+		patches, err = parseEnvelopePatch(patch)
+	case PatchFormatSearchReplace:
+		patches, err = parseSearchReplace(patch)
+	default:
+		patches, err = parseUnifiedDiff(patch, format == PatchFormatBareHunks)
+	}
+	return patches, format, err
+}
+
+// parseSearchReplace parses aider style edit blocks:
+//
+//	path/to/file.go
+//	```go
+//	<<<<<<< SEARCH
+//	old lines
+//	=======
+//	new lines
+//	>>>>>>> REPLACE
+//	```
+//
+// The file a block applies to is taken from the last path looking line
+// before it (blocks without one apply to the same file as the previous
+// block).  A block with an empty SEARCH section creates a new file.
+func parseSearchReplace(patch string) ([]*FilePatch, error) {
+	var patches []*FilePatch
+	byPath := map[string]*FilePatch{}
+	path := ""
+	lines := SplitLines(strings.ReplaceAll(patch, "\r\n", "\n"))
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		if !searchMarkerRegex.MatchString(line) {
+			if candidate, ok := pathLine(line); ok {
+				path = candidate
+			}
+			continue
+		}
+
+		start := i
+		var search, replace []string
+		section := &search
+		for i++; ; i++ {
+			if i >= len(lines) {
+				return nil, fmt.Errorf("line %d: SEARCH block is not terminated with '>>>>>>> REPLACE'", start+1)
+			}
+			if dividerMarkerRegex.MatchString(lines[i]) && section == &search {
+				section = &replace
+			} else if replaceMarkerRegex.MatchString(lines[i]) {
+				if section == &search {
+					return nil, fmt.Errorf("line %d: SEARCH block has no '=======' divider", start+1)
+				}
+				break
+			} else {
+				*section = append(*section, lines[i])
+			}
+		}
+
+		fp := byPath[path]
+		if fp == nil {
+			fp = &FilePatch{OldPath: path, NewPath: path}
+			byPath[path] = fp
+			patches = append(patches, fp)
+		}
+		if len(search) == 0 {
+			if len(fp.Hunks) > 0 {
+				return nil, fmt.Errorf("line %d: empty SEARCH sections can only be used to create new files", start+1)
+			}
+			fp.OldPath = DevNull
+		}
+		fp.Hunks = append(fp.Hunks, hunkFromTexts(search, replace))
+	}
+	if len(patches) == 0 {
+		return nil, fmt.Errorf("no SEARCH/REPLACE blocks found")
+	}
+	return patches, nil
+}
+
+// pathLine returns the file path named on a line preceding a SEARCH block, eg
+// "path/to/file.go", "`file.go`" or "**file.go**".  Code fences, prose and
+// blank lines are rejected.
+func pathLine(line string) (string, bool) {
+	line = strings.TrimSpace(line)
+	if strings.HasPrefix(line, "```") {
+		return "", false
+	}
+	line = strings.TrimSuffix(line, ":")
+	line = strings.Trim(line, "`*")
+	line = strings.TrimPrefix(line, "# ")
+	if line == "" || strings.ContainsAny(line, " \t") || !strings.ContainsAny(line, "./") {
+		return "", false
+	}
+	return line, true
+}
+
+// hunkFromTexts turns a search/replace pair into a hunk, keeping the lines
+// the two have in common as context.
+func hunkFromTexts(search, replace []string) *Hunk {
+	hunk := &Hunk{OldLines: len(search), NewLines: len(replace)}
+	if len(search) == 0 {
+		// A new file
+		hunk.NewStart = 1
+	}
+	for _, diff := range DiffLineSlices(search, replace) {
+		switch diff.Kind {
+		case DiffEqual:
+			hunk.Lines = append(hunk.Lines, " "+diff.Line)
+		case DiffDelete:
+			hunk.Lines = append(hunk.Lines, "-"+diff.Line)
+		case DiffInsert:
+			hunk.Lines = append(hunk.Lines, "+"+diff.Line)
+		}
+	}
+	return hunk
+}
+
+// parseEnvelopePatch parses patches of the form:
+//
+//	*** Begin Patch
+//	*** Update File: path/to/file.go
+//	*** Move to: path/to/renamed.go
+//	@@ func main() {
+//	 context
+//	-old
+//	+new
+//	*** Add File: path/to/new.go
+//	+contents
+//	*** Delete File: path/to/old.go
+//	*** End Patch
+//
+// Hunks are introduced by "@@" lines (optionally naming a line near the
+// hunk) and carry no line numbers.
+func parseEnvelopePatch(patch string) ([]*FilePatch, error) {
+	var patches []*FilePatch
+	var current *FilePatch
+	var hunk *Hunk
+	started := false
+	for i, line := range SplitLines(strings.ReplaceAll(patch, "\r\n", "\n")) {
+		trimmed := strings.TrimSpace(line)
+		if !started {
+			started = trimmed == "*** Begin Patch"
+			continue
+		}
+		if trimmed == "*** End Patch" {
+			break
+		}
+
+		if rest, ok := strings.CutPrefix(line, "*** "); ok {
+			action, path, _ := strings.Cut(rest, ":")
+			path = strings.TrimSpace(path)
+			switch action {
+			case "Update File":
+				current = &FilePatch{OldPath: path, NewPath: path}
+			case "Add File":
+				current = &FilePatch{OldPath: DevNull, NewPath: path}
+			case "Delete File":
+				current = &FilePatch{OldPath: path, NewPath: DevNull, DeletesWholeFile: true}
+			case "Move to":
+				if current == nil {
+					return nil, fmt.Errorf("line %d: '*** Move to' without a preceding '*** Update File'", i+1)
+				}
+				current.NewPath = path
+				continue
+			case "End of File":
+				continue
+			default:
+				return nil, fmt.Errorf("line %d: unknown patch directive %q", i+1, line)
+			}
+			if path == "" {
+				return nil, fmt.Errorf("line %d: %q has no file path", i+1, line)
+			}
+			patches = append(patches, current)
+			hunk = nil
+			if current.IsNew() {
+				hunk = &Hunk{NewStart: 1}
+				current.Hunks = append(current.Hunks, hunk)
+			}
+			continue
+		}
+		if current == nil {
+			if trimmed == "" {
+				continue
+			}
+			return nil, fmt.Errorf("line %d: expected '*** Update File', '*** Add File' or '*** Delete File'", i+1)
+		}
+		if current.IsDelete() {
+			continue
+		}
+
+		if match := bareHunkHeaderRegex.FindStringSubmatch(line); match != nil && !current.IsNew() {
+			hunk = &Hunk{Section: strings.TrimSpace(match[1])}
+			current.Hunks = append(current.Hunks, hunk)
+			continue
+		}
+		if line == "" {
+			// Blank context lines often lose their leading space
+			line = " "
+		}
+		if !strings.ContainsRune(" +-", rune(line[0])) {
+			return nil, fmt.Errorf("line %d: expected a line starting with ' ', '+' or '-': %q", i+1, line)
+		}
+		if current.IsNew() && line[0] != '+' {
+			return nil, fmt.Errorf("line %d: lines of an added file must start with '+'", i+1)
+		}
+		if hunk == nil {
+			hunk = &Hunk{}
+			current.Hunks = append(current.Hunks, hunk)
+		}
+		hunk.Lines = append(hunk.Lines, line)
+	}
+	if !started {
+		return nil, fmt.Errorf("missing '*** Begin Patch'")
+	}
+	for _, fp := range patches {
+		for _, h := range fp.Hunks {
+			if fp.IsNew() {
+				h.NewLines = len(h.Lines)
+			} else {
+				trimHunk(h)
+			}
+		}
+	}
+	return patches, nil
+}
@@ -0,0 +1,150 @@
+package tools
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const formatsMain = "package main\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Println(\"hello\")\n}\n"
+
+// Marks a file expected to no longer exist
+const deleted = "<deleted>"
+
+func TestPatchFormats(t *testing.T) {
+	tests := []struct {
+		name   string
+		patch  string
+		path   string
+		format string
+
+		// Files after the patch was applied (or that must be unchanged if wantErr is set)
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name:   "unified",
+			patch:  "--- a/main.go\n+++ b/main.go\n@@ -5,3 +5,3 @@\n func main() {\n-\tfmt.Println(\"hello\")\n+\tfmt.Println(\"bye\")\n }\n",
+			format: PatchFormatUnified,
+			want:   map[string]string{"main.go": "package main\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Println(\"bye\")\n}\n"},
+		},
+		{
+			name:   "search replace with fenced file name",
+			patch:  "I'll change the greeting:\n\nmain.go\n```go\n<<<<<<< SEARCH\nfunc main() {\n\tfmt.Println(\"hello\")\n=======\nfunc main() {\n\tfmt.Println(\"bye\")\n>>>>>>> REPLACE\n```\n",
+			format: PatchFormatSearchReplace,
+			want:   map[string]string{"main.go": "package main\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Println(\"bye\")\n}\n"},
+		},
+		{
+			name: "search replace across files",
+			patch: "`main.go`\n<<<<<<< SEARCH\nimport \"fmt\"\n=======\nimport \"log\"\n>>>>>>> REPLACE\n\nAnd then:\n\n" +
+				"<<<<<<< SEARCH\n\tfmt.Println(\"hello\")\n=======\n\tlog.Println(\"hello\")\n>>>>>>> REPLACE\n\n" +
+				"docs/notes.md\n<<<<<<< SEARCH\n=======\n# Notes\n>>>>>>> REPLACE\n",
+			format: PatchFormatSearchReplace,
+			want: map[string]string{
+				"main.go":       "package main\n\nimport \"log\"\n\nfunc main() {\n\tlog.Println(\"hello\")\n}\n",
+				"docs/notes.md": "# Notes\n",
+			},
+		},
+		{
+			name:   "search replace without a file name uses path",
+			patch:  "<<<<<<< SEARCH\n\tfmt.Println(\"hello\")\n=======\n\tfmt.Println(\"hi\")\n>>>>>>> REPLACE\n",
+			path:   "main.go",
+			format: PatchFormatSearchReplace,
+			want:   map[string]string{"main.go": "package main\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Println(\"hi\")\n}\n"},
+		},
+		{
+			name:    "search replace that does not match",
+			patch:   "main.go\n<<<<<<< SEARCH\nfunc other() {\n\treturn nil\n}\n=======\n>>>>>>> REPLACE\n",
+			format:  PatchFormatSearchReplace,
+			want:    map[string]string{"main.go": formatsMain},
+			wantErr: true,
+		},
+		{
+			name:    "unterminated search block",
+			patch:   "main.go\n<<<<<<< SEARCH\nimport \"fmt\"\n=======\nimport \"log\"\n",
+			format:  PatchFormatSearchReplace,
+			want:    map[string]string{"main.go": formatsMain},
+			wantErr: true,
+		},
+		{
+			name: "envelope",
+			patch: "*** Begin Patch\n*** Update File: main.go\n@@ func main() {\n-\tfmt.Println(\"hello\")\n+\tfmt.Println(\"bye\")\n }\n" +
+				"*** Add File: docs/notes.md\n+# Notes\n+\n+todo\n*** Delete File: old.txt\n*** End Patch\n",
+			format: PatchFormatEnvelope,
+			want: map[string]string{
+				"main.go":       "package main\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Println(\"bye\")\n}\n",
+				"docs/notes.md": "# Notes\n\ntodo\n",
+				"old.txt":       deleted,
+			},
+		},
+		{
+			name:   "envelope with a move and several hunks",
+			patch:  "*** Begin Patch\n*** Update File: main.go\n*** Move to: cmd/main.go\n@@\n-package main\n+package cmd\n@@ func main() {\n \tfmt.Println(\"hello\")\n+\tfmt.Println(\"world\")\n }\n*** End of File\n*** End Patch",
+			format: PatchFormatEnvelope,
+			want: map[string]string{
+				"main.go":     deleted,
+				"cmd/main.go": "package cmd\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Println(\"hello\")\n\tfmt.Println(\"world\")\n}\n",
+			},
+		},
+		{
+			name:    "envelope with an unknown directive",
+			patch:   "*** Begin Patch\n*** Patch File: main.go\n*** End Patch\n",
+			format:  PatchFormatEnvelope,
+			want:    map[string]string{"main.go": formatsMain},
+			wantErr: true,
+		},
+		{
+			name:   "bare hunk with headers",
+			patch:  "--- main.go\n+++ main.go\n import \"fmt\"\n+import \"os\"\n \n",
+			format: PatchFormatBareHunks,
+			want:   map[string]string{"main.go": "package main\n\nimport \"fmt\"\nimport \"os\"\n\nfunc main() {\n\tfmt.Println(\"hello\")\n}\n"},
+		},
+		{
+			name:   "bare hunks separated by @@",
+			patch:  "@@\n-package main\n+package app\n@@ func main() @@\n func main() {\n-\tfmt.Println(\"hello\")\n+\tfmt.Println(\"hi\")\n",
+			path:   "main.go",
+			format: PatchFormatBareHunks,
+			want:   map[string]string{"main.go": "package app\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Println(\"hi\")\n}\n"},
+		},
+		{
+			name:    "bare hunk without a path",
+			patch:   "-package main\n+package app\n",
+			format:  PatchFormatBareHunks,
+			want:    map[string]string{"main.go": formatsMain},
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if format := DetectPatchFormat(tc.patch); format != tc.format {
+				t.Errorf("expected format %s, got %s", tc.format, format)
+			}
+
+			root := t.TempDir()
+			writeTree(t, root, map[string]string{"main.go": formatsMain, "old.txt": "bye\n"})
+			args := map[string]any{"diff": tc.patch}
+			if tc.path != "" {
+				args["path"] = tc.path
+			}
+			env := NewRegistry(NewWorkspace(root)).Invoke(t.Context(), "apply_file_diff", args)
+			if env.Ok == tc.wantErr {
+				t.Fatalf("expected error: %v, got %s", tc.wantErr, env)
+			}
+			if env.Ok && env.Result.(*ApplyDiffResult).Format != tc.format {
+				t.Errorf("expected the result to report format %s, got %s", tc.format, env)
+			}
+
+			for name, expected := range tc.want {
+				data, err := os.ReadFile(filepath.Join(root, name))
+				if expected == deleted {
+					if !os.IsNotExist(err) {
+						t.Errorf("expected %s to be removed", name)
+					}
+				} else if string(data) != expected {
+					t.Errorf("%s mismatch:\nExpected:\n%s\nGot:\n%s", name, expected, data)
+				}
+			}
+		})
+	}
+}
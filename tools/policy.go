@@ -0,0 +1,733 @@
+package tools
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Actions a CommandPolicy can take on a command
+const (
+	PolicyAllow   = "allow"
+	PolicyDeny    = "deny"
+	PolicyConfirm = "confirm"
+)
+
+// Estimated risk of a command (see EstimateCommandRisk)
+const (
+	RiskLow    = "low"
+	RiskMedium = "medium"
+	RiskHigh   = "high"
+)
+
+var riskLevels = []string{RiskLow, RiskMedium, RiskHigh}
+
+// ErrCommandDenied is returned (wrapped in a *PolicyError) for commands a
+// CommandPolicy does not allow.
+var ErrCommandDenied = errors.New("command denied by policy")
+
+// A CommandRule matches commands by their programs, arguments, working
+// directory and estimated risk.  Every condition that is set must hold for
+// the rule to match - a rule without conditions matches every command.
+type CommandRule struct {
+	// Identifies the rule in errors (defaults to its position)
+	Name string `json:"name,omitempty"`
+
+	// One of PolicyAllow, PolicyDeny or PolicyConfirm
+	Action string `json:"action"`
+
+	// Globs matched against the name of any program run by the command (eg
+	// "rm" or "python*").  Wrappers like env, nohup and xargs are looked
+	// through so "env X=1 rm" runs "rm".
+	Programs []string `json:"programs,omitempty"`
+
+	// Globs matched against the subcommand of the same program (or of any
+	// program if Programs is empty), eg "push" for git.  The subcommand is
+	// the first argument that is not an option (skipping the values of git's
+	// own options, eg "git -C dir push").
+	Subcommands []string `json:"subcommands,omitempty"`
+
+	// Regular expressions that must each match an argument of the same
+	// program (or of any program if Programs is empty), eg "^--force$"
+	Args []string `json:"args,omitempty"`
+
+	// Regular expression matched against the whole command line
+	Pattern string `json:"pattern,omitempty"`
+
+	// Globs (relative to the project root, "." being the root itself) the
+	// working directory must match
+	Dirs []string `json:"dirs,omitempty"`
+
+	// The rule only matches commands at least this risky
+	MinRisk string `json:"min_risk,omitempty"`
+
+	// Explanation returned (with the rule's name) when a command is denied
+	Reason string `json:"reason,omitempty"`
+}
+
+// A CommandPolicy decides which commands run_shell_command and start_process
+// may run.  Rules are tried in order and the first match decides.
+type CommandPolicy struct {
+	Rules []*CommandRule `json:"rules"`
+
+	// Action for commands no rule matches.  Defaults to PolicyAllow.
+	Default string `json:"default,omitempty"`
+}
+
+// DefaultCommandPolicy is used when a Workspace has no CommandPolicy.  It
+// denies running as another user and piping downloads into a shell, and asks
+// for confirmation for recursive deletes, pushes and other risky commands.
+// Whatever the policy, commands running a program that is only known once
+// the shell expands it are never just allowed (see Evaluate).
+func DefaultCommandPolicy() *CommandPolicy {
+	return &CommandPolicy{
+		Rules: []*CommandRule{
+			{
+				Name:     "privilege-escalation",
+				Action:   PolicyDeny,
+				Programs: []string{"sudo", "su", "doas", "pkexec"},
+				Reason:   "commands may not run as another user",
+			},
+			{
+				Name:    "pipe-to-shell",
+				Action:  PolicyDeny,
+				Pattern: `\b(curl|wget)\b[^|;&]*\|\s*(\S+/)?(ba|z|da|k|fi)?sh\b`,
+				Reason:  "downloaded scripts may not be piped into a shell",
+			},
+			{
+				Name:     "recursive-delete",
+				Action:   PolicyConfirm,
+				Programs: []string{"rm"},
+				Args:     []string{`^(-[a-zA-Z]*[rR][a-zA-Z]*|--recursive)$`},
+				Reason:   "recursive deletes need confirmation",
+			},
+			{
+				Name:        "git-push",
+				Action:      PolicyConfirm,
+				Programs:    []string{"git"},
+				Subcommands: []string{"push"},
+				Reason:      "pushes need confirmation",
+			},
+			{
+				Name:    "high-risk",
+				Action:  PolicyConfirm,
+				MinRisk: RiskHigh,
+				Reason:  "high risk commands need confirmation",
+			},
+		},
+		Default: PolicyAllow,
+	}
+}
+
+// Validate checks the policy's actions, risks and patterns.
+func (p *CommandPolicy) Validate() error {
+	validAction := func(action string) bool {
+		return slices.Contains([]string{PolicyAllow, PolicyDeny, PolicyConfirm}, action)
+	}
+	if p.Default != "" && !validAction(p.Default) {
+		return fmt.Errorf("invalid default action %q", p.Default)
+	}
+	for i, rule := range p.Rules {
+		name := rule.name(i)
+		if !validAction(rule.Action) {
+			return fmt.Errorf("%s: invalid action %q", name, rule.Action)
+		}
+		if rule.MinRisk != "" && !slices.Contains(riskLevels, rule.MinRisk) {
+			return fmt.Errorf("%s: invalid min_risk %q", name, rule.MinRisk)
+		}
+		for _, pattern := range append(slices.Clone(rule.Args), rule.Pattern) {
+			if _, err := regexp.Compile(pattern); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		}
+		for _, glob := range rule.Programs {
+			if _, err := path.Match(glob, ""); err != nil {
+				return fmt.Errorf("%s: invalid program glob %q: %w", name, glob, err)
+			}
+		}
+		for _, glob := range rule.Subcommands {
+			if _, err := path.Match(glob, ""); err != nil {
+				return fmt.Errorf("%s: invalid subcommand glob %q: %w", name, glob, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (r *CommandRule) name(index int) string {
+	if r.Name != "" {
+		return r.Name
+	}
+	return fmt.Sprintf("rule %d", index+1)
+}
+
+// A PolicyDecision is the outcome of checking a command against a
+// CommandPolicy.
+type PolicyDecision struct {
+	// One of PolicyAllow, PolicyDeny or PolicyConfirm
+	Action string `json:"action"`
+
+	// Name of the rule that matched ("default" if none did)
+	Rule   string `json:"rule"`
+	Reason string `json:"reason,omitempty"`
+
+	// Estimated risk of the command and why
+	Risk        string   `json:"risk"`
+	RiskReasons []string `json:"risk_reasons,omitempty"`
+}
+
+// Name of the (built in) rule asking for confirmation of commands whose
+// program is not a literal word
+const DynamicProgramRule = "dynamic-program"
+
+// Evaluate decides what to do with a command run (with a shell if shell is
+// set) in dir - the slash separated working directory relative to the
+// project root.  Commands the policy allows still need confirmation if a
+// program they run comes from a variable, a command substitution or a glob
+// (eg "$X ls" or "$(echo sudo) ls") as the rules cannot tell what it is.
+func (p *CommandPolicy) Evaluate(command string, shell bool, dir string) (*PolicyDecision, error) {
+	commands, err := parseCommandLine(command, shell)
+	if err != nil {
+		return nil, err
+	}
+	decision := &PolicyDecision{Action: p.Default, Rule: "default"}
+	if decision.Action == "" {
+		decision.Action = PolicyAllow
+	}
+	decision.Risk, decision.RiskReasons = EstimateCommandRisk(commands)
+	for i, rule := range p.Rules {
+		matched, err := rule.matches(command, commands, dir, decision.Risk)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", rule.name(i), err)
+		}
+		if matched {
+			decision.Action, decision.Rule, decision.Reason = rule.Action, rule.name(i), rule.Reason
+			break
+		}
+	}
+	if decision.Action == PolicyAllow {
+		if program, ok := dynamicProgram(commands); ok {
+			decision.Action, decision.Rule = PolicyConfirm, DynamicProgramRule
+			decision.Reason = fmt.Sprintf("the program %q is only known once the shell expands it", program)
+		}
+	}
+	return decision, nil
+}
+
+// parseCommandLine returns the simple commands a command line runs -
+// including those run by scripts passed to shells (sh -c) and eval.
+func parseCommandLine(command string, shell bool) ([]*ShellCommand, error) {
+	var commands []*ShellCommand
+	if shell {
+		var err error
+		if commands, err = SplitShellCommand(command); err != nil {
+			return nil, err
+		}
+	} else {
+		args, err := SplitCommand(command)
+		if err != nil {
+			return nil, err
+		}
+		commands = []*ShellCommand{{Args: args}}
+	}
+	return expandCommands(commands, 0)
+}
+
+// Deepest nesting of shell scripts (eg bash -c "eval '...'") looked into
+const maxCommandNesting = 8
+
+// expandCommands adds the commands run by the shell scripts (see
+// shellPayload) of each command after it.
+func expandCommands(commands []*ShellCommand, depth int) (out []*ShellCommand, err error) {
+	for _, cmd := range commands {
+		out = append(out, cmd)
+		for _, args := range unwrapCommand(cmd.Args) {
+			script, ok := shellPayload(args)
+			if !ok {
+				continue
+			}
+			if depth >= maxCommandNesting {
+				return nil, fmt.Errorf("%w: shell scripts are nested too deeply to check", ErrShellSyntax)
+			}
+			nested, err := SplitShellCommand(script)
+			if err != nil {
+				return nil, fmt.Errorf("script run by %s: %w", programName(args[0]), err)
+			}
+			if nested, err = expandCommands(nested, depth+1); err != nil {
+				return nil, err
+			}
+			out = append(out, nested...)
+		}
+	}
+	return out, nil
+}
+
+// Shells whose -c option runs a script
+var shells = []string{"sh", "bash", "zsh", "dash", "ksh", "fish"}
+
+// shellPayload returns the script run by "eval ..." or "<shell> -c <script>".
+func shellPayload(args []string) (string, bool) {
+	program := programName(args[0])
+	if program == "eval" {
+		return strings.Join(args[1:], " "), len(args) > 1
+	}
+	if !slices.Contains(shells, program) {
+		return "", false
+	}
+	sawC := false
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "-o" || arg == "+o" || arg == "-O" || arg == "+O":
+			// Options taking a value, eg -o pipefail
+			i++
+		case strings.HasPrefix(arg, "--"):
+		case strings.HasPrefix(arg, "-") || strings.HasPrefix(arg, "+"):
+			// Short options can be combined, eg -lc or -ec
+			sawC = sawC || strings.Contains(arg[1:], "c")
+		case sawC:
+			return arg, true
+		default:
+			// A script file - not inspected
+			return "", false
+		}
+	}
+	return "", false
+}
+
+func (r *CommandRule) matches(command string, commands []*ShellCommand, dir, risk string) (bool, error) {
+	if r.MinRisk != "" && slices.Index(riskLevels, risk) < slices.Index(riskLevels, r.MinRisk) {
+		return false, nil
+	}
+	if len(r.Dirs) > 0 && !slices.ContainsFunc(r.Dirs, func(glob string) bool {
+		return glob == dir || MatchGlob(glob, dir)
+	}) {
+		return false, nil
+	}
+	if r.Pattern != "" {
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return false, err
+		}
+		if !re.MatchString(command) {
+			return false, nil
+		}
+	}
+	if len(r.Programs) == 0 && len(r.Subcommands) == 0 && len(r.Args) == 0 {
+		return true, nil
+	}
+	for _, cmd := range commands {
+		for _, args := range unwrapCommand(cmd.Args) {
+			if ok, err := r.matchesArgs(args); ok || err != nil {
+				return ok, err
+			}
+		}
+	}
+	return false, nil
+}
+
+// matchesArgs checks the Programs, Subcommands and Args conditions against a
+// single program's arguments.
+func (r *CommandRule) matchesArgs(args []string) (bool, error) {
+	if len(r.Programs) > 0 && !slices.ContainsFunc(r.Programs, func(glob string) bool {
+		ok, _ := path.Match(glob, programName(args[0]))
+		return ok
+	}) {
+		return false, nil
+	}
+	if len(r.Subcommands) > 0 {
+		subcommand := subcommandOf(args)
+		if !slices.ContainsFunc(r.Subcommands, func(glob string) bool {
+			ok, _ := path.Match(glob, subcommand)
+			return ok
+		}) {
+			return false, nil
+		}
+	}
+	for _, pattern := range r.Args {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return false, err
+		}
+		if !slices.ContainsFunc(args[1:], re.MatchString) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Programs that run another program given as their arguments
+var commandWrappers = []string{"env", "nohup", "time", "nice", "xargs", "timeout", "command", "exec", "sudo", "doas"}
+
+// Options of xargs taking a separate value
+var xargsValueOptions = []string{"-I", "-n", "-L", "-P", "-d", "-E", "-s", "-a"}
+
+// Options of find running the command that follows (upto a ';' or '+')
+var findExecOptions = []string{"-exec", "-execdir", "-ok", "-okdir"}
+
+// unwrapCommand returns args along with the commands run by any wrappers (eg
+// "nohup rm -rf x" also yields "rm -rf x") and by find's -exec options.
+// Leading variable assignments are skipped.
+func unwrapCommand(args []string) (out [][]string) {
+	for len(args) > 0 && isAssignment(args[0]) {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return
+	}
+	out = append(out, args)
+	wrapper := programName(args[0])
+	if wrapper == "find" {
+		for i := 1; i < len(args); i++ {
+			if !slices.Contains(findExecOptions, args[i]) {
+				continue
+			}
+			end := i + 1
+			for end < len(args) && args[end] != ";" && args[end] != "+" {
+				end++
+			}
+			out = append(out, unwrapCommand(args[i+1:end])...)
+			i = end
+		}
+		return
+	}
+	if !slices.Contains(commandWrappers, wrapper) {
+		return
+	}
+	// Skip the wrapper's own options (and timeout's duration)
+	args = args[1:]
+	for len(args) > 0 && (strings.HasPrefix(args[0], "-") || isAssignment(args[0]) || (wrapper == "timeout" && isDuration(args[0]))) {
+		if wrapper == "xargs" && slices.Contains(xargsValueOptions, args[0]) && len(args) > 1 {
+			args = args[1:]
+		}
+		args = args[1:]
+	}
+	return append(out, unwrapCommand(args)...)
+}
+
+// gitSubcommand returns the subcommand (and its arguments) of git's
+// arguments skipping git's own options, eg "git -C dir push".
+func gitSubcommand(args []string) (string, []string) {
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		if slices.Contains([]string{"-C", "-c", "--git-dir", "--work-tree", "--namespace"}, args[0]) && len(args) > 1 {
+			args = args[1:]
+		}
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return "", nil
+	}
+	return args[0], args[1:]
+}
+
+// subcommandOf returns the subcommand of a program's arguments - the first
+// argument that is not an option ("" if there is none).
+func subcommandOf(args []string) string {
+	if programName(args[0]) == "git" {
+		subcommand, _ := gitSubcommand(args[1:])
+		return subcommand
+	}
+	for _, arg := range args[1:] {
+		if !strings.HasPrefix(arg, "-") {
+			return arg
+		}
+	}
+	return ""
+}
+
+// Words that look like they are expanded but are shell syntax
+var literalPrograms = []string{"[", "[[", "{", "}"}
+
+// dynamicProgram returns the first program run by the commands (looking
+// through wrappers) that the shell only knows once it has expanded it: one
+// containing variables, command substitutions, globs or braces.
+func dynamicProgram(commands []*ShellCommand) (string, bool) {
+	for _, cmd := range commands {
+		for _, args := range unwrapCommand(cmd.Args) {
+			if !slices.Contains(literalPrograms, args[0]) && strings.ContainsAny(args[0], "$`*?[{") {
+				return args[0], true
+			}
+		}
+	}
+	return "", false
+}
+
+var assignmentRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*=`)
+
+func isAssignment(arg string) bool {
+	return assignmentRegex.MatchString(arg)
+}
+
+func isDuration(arg string) bool {
+	return strings.TrimRight(strings.TrimLeft(arg, "0123456789."), "smhd") == ""
+}
+
+// programName returns the name of a program without its directory.
+func programName(program string) string {
+	return path.Base(strings.ReplaceAll(program, "\\", "/"))
+}
+
+// Programs interpreting their input as code
+var interpreters = []string{"sh", "bash", "zsh", "dash", "ksh", "fish", "python*", "perl", "ruby", "node", "php"}
+
+// EstimateCommandRisk estimates how risky running the given commands is along
+// with why: running as another user, destroying files or history and piping
+// into interpreters is high risk while deleting and moving files, network
+// access, installing packages and writing through redirects are medium risk.
+func EstimateCommandRisk(commands []*ShellCommand) (risk string, reasons []string) {
+	risk = RiskLow
+	flag := func(level, reason string) {
+		if slices.Index(riskLevels, level) > slices.Index(riskLevels, risk) {
+			risk = level
+		}
+		if !slices.Contains(reasons, reason) {
+			reasons = append(reasons, reason)
+		}
+	}
+	for _, cmd := range commands {
+		if len(cmd.Redirects) > 0 {
+			flag(RiskMedium, "writes files through redirects")
+		}
+		if cmd.After == "$(" || cmd.After == "`" {
+			flag(RiskMedium, "uses command substitution")
+		}
+		for _, args := range unwrapCommand(cmd.Args) {
+			program, rest := programName(args[0]), args[1:]
+			hasArg := func(patterns ...string) bool {
+				return slices.ContainsFunc(rest, func(arg string) bool {
+					return slices.ContainsFunc(patterns, func(p string) bool { return regexp.MustCompile(p).MatchString(arg) })
+				})
+			}
+			isInterpreter := slices.ContainsFunc(interpreters, func(glob string) bool {
+				ok, _ := path.Match(glob, program)
+				return ok
+			})
+			switch {
+			case slices.Contains([]string{"sudo", "su", "doas", "pkexec"}, program):
+				flag(RiskHigh, "runs as another user")
+			case cmd.After == "|" && isInterpreter:
+				flag(RiskHigh, "pipes into an interpreter")
+			case program == "rm" && hasArg(`^-[a-zA-Z]*[rR]`, `^--recursive$`):
+				flag(RiskHigh, "deletes files recursively")
+			case program == "rm", program == "mv", program == "shred", program == "unlink":
+				flag(RiskMedium, "deletes or moves files")
+			case slices.Contains([]string{"dd", "fdisk", "parted", "shutdown", "reboot", "halt", "poweroff"}, program) || strings.HasPrefix(program, "mkfs"):
+				flag(RiskHigh, "changes disks or the system")
+			case (program == "chmod" || program == "chown") && hasArg(`^-[a-zA-Z]*R`, `^--recursive$`):
+				flag(RiskHigh, "changes permissions recursively")
+			case program == "git":
+				sub, subArgs := gitSubcommand(rest)
+				hasArg := func(patterns ...string) bool {
+					return slices.ContainsFunc(subArgs, func(arg string) bool {
+						return slices.ContainsFunc(patterns, func(p string) bool { return regexp.MustCompile(p).MatchString(arg) })
+					})
+				}
+				switch {
+				case sub == "push" && hasArg(`^(-f|--force.*|--delete|-d|--mirror)$`):
+					flag(RiskHigh, "rewrites remote history")
+				case sub == "push":
+					flag(RiskMedium, "pushes to a remote")
+				case sub == "reset" && hasArg(`^--hard$`), sub == "clean" && hasArg(`^-[a-zA-Z]*f`), sub == "checkout" && hasArg(`^(--|\.)$`):
+					flag(RiskHigh, "discards uncommitted work")
+				}
+			case slices.Contains([]string{"curl", "wget", "ssh", "scp", "rsync", "nc"}, program):
+				flag(RiskMedium, "accesses the network")
+			case slices.Contains([]string{"npm", "pnpm", "yarn", "pip", "pip3", "gem", "cargo", "brew", "apt", "apt-get"}, program) && hasArg(`^(install|add|i)$`):
+				flag(RiskMedium, "installs packages")
+			case program == "find" && hasArg(`^-delete$`):
+				flag(RiskMedium, "deletes files")
+			case slices.Contains([]string{"kill", "pkill", "killall"}, program):
+				flag(RiskMedium, "kills processes")
+			case program == "eval":
+				flag(RiskMedium, "evaluates code")
+			}
+		}
+	}
+	return risk, reasons
+}
+
+// A PolicyError is returned for commands a CommandPolicy denied (or that were
+// not confirmed).  It names the rule responsible so the command can be
+// adjusted.
+type PolicyError struct {
+	Command string `json:"command"`
+	*PolicyDecision
+
+	// Why the command was not run (eg what the user said when declining)
+	Message string `json:"message"`
+}
+
+func (e *PolicyError) Error() string {
+	msg := fmt.Sprintf("%v (rule %q", ErrCommandDenied, e.Rule)
+	if e.Reason != "" {
+		msg += ": " + e.Reason
+	}
+	msg += ")"
+	if e.Message != "" {
+		msg += " - " + e.Message
+	}
+	return msg
+}
+
+func (e *PolicyError) Unwrap() error {
+	return ErrCommandDenied
+}
+
+// A CommandConfirmer is asked about commands a CommandPolicy wants confirmed.
+type CommandConfirmer interface {
+	// ConfirmCommand returns whether the command may run and if not, why
+	ConfirmCommand(command string, decision *PolicyDecision) (ok bool, reason string, err error)
+}
+
+type commandConfirmerKey struct{}
+
+// WithCommandConfirmer returns a context whose commands needing confirmation
+// are confirmed by confirmer.  Without one they are denied.
+func WithCommandConfirmer(ctx context.Context, confirmer CommandConfirmer) context.Context {
+	return context.WithValue(ctx, commandConfirmerKey{}, confirmer)
+}
+
+// CommandConfirmerFrom returns the confirmer set with WithCommandConfirmer (if
+// any).
+func CommandConfirmerFrom(ctx context.Context) CommandConfirmer {
+	confirmer, _ := ctx.Value(commandConfirmerKey{}).(CommandConfirmer)
+	return confirmer
+}
+
+// CheckCommand checks whether a command may be run in the working directory
+// workingDir (as passed to the tool) resolved to dir.  A *PolicyError is
+// returned if the workspace's CommandPolicy (or DefaultCommandPolicy) denies
+// it or it needs confirmation that is not given.
+func (b *BaseFileTool) CheckCommand(ctx context.Context, command string, shell bool, workingDir, dir string) error {
+	decision, err := b.EvaluateCommand(command, shell, workingDir, dir)
+	if err != nil {
+		return err
+	}
+	switch decision.Action {
+	case PolicyAllow:
+		return nil
+	case PolicyConfirm:
+		confirmer := CommandConfirmerFrom(ctx)
+		if confirmer == nil {
+			return &PolicyError{Command: command, PolicyDecision: decision, Message: "the command needs confirmation and no one is available to confirm it"}
+		}
+		ok, reason, err := confirmer.ConfirmCommand(command, decision)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			if reason == "" {
+				reason = "the user declined to run the command"
+			}
+			return &PolicyError{Command: command, PolicyDecision: decision, Message: reason}
+		}
+		return nil
+	}
+	return &PolicyError{Command: command, PolicyDecision: decision}
+}
+
+// EvaluateCommand returns what the workspace's CommandPolicy (or
+// DefaultCommandPolicy) decides for a command run from workingDir resolved to
+// dir.
+func (b *BaseFileTool) EvaluateCommand(command string, shell bool, workingDir, dir string) (*PolicyDecision, error) {
+	policy := DefaultCommandPolicy()
+	if b.Workspace != nil && b.Workspace.Commands != nil {
+		policy = b.Workspace.Commands
+	}
+	sandbox, _ := b.Sandbox(workingDir)
+	rel, err := sandbox.Rel(dir)
+	if err != nil {
+		return nil, err
+	}
+	if rel == "" {
+		rel = "."
+	}
+	return policy.Evaluate(command, shell, rel)
+}
+
+// PreviewCommand describes the command a tool is about to run along with what
+// the command policy makes of it.
+func (b *BaseFileTool) PreviewCommand(args map[string]any) (string, error) {
+	working_dir := "."
+	if val, ok := args["working_dir"].(string); ok && val != "" {
+		working_dir = val
+	}
+	dir, err := b.ResolvePath(working_dir)
+	if err != nil {
+		return "", err
+	}
+	command, _ := args["command"].(string)
+	shell, _ := args["shell"].(bool)
+	decision, err := b.EvaluateCommand(command, shell, working_dir, dir)
+	if err != nil {
+		return "", err
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "Run\n\n\t%s\n\nin %s", command, working_dir)
+	if shell {
+		out.WriteString(" with the system shell")
+	}
+	if env := stringsArg(args, "env"); len(env) > 0 {
+		fmt.Fprintf(&out, " with %s", strings.Join(env, " "))
+	}
+	fmt.Fprintf(&out, "\n\nCommand policy: %s (rule %q", decision.Action, decision.Rule)
+	if decision.Reason != "" {
+		fmt.Fprintf(&out, ": %s", decision.Reason)
+	}
+	fmt.Fprintf(&out, ", %s risk", decision.Risk)
+	if len(decision.RiskReasons) > 0 {
+		fmt.Fprintf(&out, " - %s", strings.Join(decision.RiskReasons, ", "))
+	}
+	out.WriteString(")\n")
+	return out.String(), nil
+}
+
+// TerminalConfirmer asks on a terminal whether commands may run.
+type TerminalConfirmer struct {
+	In  *bufio.Reader
+	Out io.Writer
+}
+
+// NewTerminalConfirmer creates a confirmer prompting on in/out.
+func NewTerminalConfirmer(in io.Reader, out io.Writer) *TerminalConfirmer {
+	return &TerminalConfirmer{In: bufio.NewReader(in), Out: out}
+}
+
+// ConfirmCommand shows the command with why it needs confirming and asks
+// whether to run it.  No answer (eg stdin being closed) declines.
+func (t *TerminalConfirmer) ConfirmCommand(command string, decision *PolicyDecision) (bool, string, error) {
+	fmt.Fprintf(t.Out, "\nThe command\n\n\t%s\n\nneeds confirmation (rule %q", command, decision.Rule)
+	if decision.Reason != "" {
+		fmt.Fprintf(t.Out, ": %s", decision.Reason)
+	}
+	fmt.Fprintf(t.Out, ", %s risk", decision.Risk)
+	if len(decision.RiskReasons) > 0 {
+		fmt.Fprintf(t.Out, " - %s", strings.Join(decision.RiskReasons, ", "))
+	}
+	fmt.Fprintln(t.Out, ")")
+	for {
+		fmt.Fprint(t.Out, "Run it [y,n]? ")
+		answer, err := t.In.ReadString('\n')
+		if err != nil && answer == "" {
+			if err == io.EOF {
+				return false, "no confirmation was given", nil
+			}
+			return false, "", err
+		}
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "y", "yes":
+			return true, "", nil
+		case "n", "no":
+			fmt.Fprint(t.Out, "Reason (optional, passed back to the AI): ")
+			reason, _ := t.In.ReadString('\n')
+			return false, strings.TrimSpace(reason), nil
+		}
+	}
+}
@@ -0,0 +1,321 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Defaults for processes started by a ProcessManager
+const (
+	DefaultProcessBufferSize = 256 * 1024
+	DefaultProcessStopGrace  = 5 * time.Second
+)
+
+// Status of a managed process
+const (
+	ProcessRunning = "running"
+	ProcessExited  = "exited"
+)
+
+// ErrUnknownProcess is returned (wrapped) for process ids a ProcessManager
+// does not know about.
+var ErrUnknownProcess = errors.New("unknown process")
+
+// RingBuffer is an io.Writer keeping the last bytes written to it.  Bytes are
+// addressed by their offset in everything ever written so readers can follow
+// the output incrementally (see ReadAt).
+type RingBuffer struct {
+	mu    sync.Mutex
+	data  []byte
+	size  int
+	total int64
+}
+
+// NewRingBuffer creates a RingBuffer keeping the last size bytes.
+func NewRingBuffer(size int) *RingBuffer {
+	return &RingBuffer{data: make([]byte, 0, size), size: size}
+}
+
+func (b *RingBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.total += int64(len(p))
+	b.data = append(b.data, p...)
+	if over := len(b.data) - b.size; over > 0 {
+		// Compact rather than wrap - reads are far rarer than writes are
+		// large so the copy is cheap
+		b.data = append(b.data[:0], b.data[over:]...)
+	}
+	return len(p), nil
+}
+
+// Total returns the number of bytes ever written.
+func (b *RingBuffer) Total() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.total
+}
+
+// ReadAt returns up to limit bytes starting at offset along with the offset the
+// returned bytes start at (later than offset if those bytes were overwritten)
+// and the offset to read from next.
+func (b *RingBuffer) ReadAt(offset int64, limit int) (data []byte, start, next int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	first := b.total - int64(len(b.data))
+	start = min(b.total, max(offset, first))
+	end := min(b.total, start+int64(limit))
+	data = slices.Clone(b.data[start-first : end-first])
+	return data, start, end
+}
+
+// A Process is a command started in the background by a ProcessManager.  Its
+// stdout and stderr are interleaved into a single RingBuffer.
+type Process struct {
+	ID        string
+	Command   string
+	Dir       string
+	StartedAt time.Time
+
+	seq    int
+	cmd    *exec.Cmd
+	cancel context.CancelFunc
+	stdin  io.WriteCloser
+	output *RingBuffer
+	done   chan struct{}
+
+	// Set once the process exits
+	exitCode int
+	exitedAt time.Time
+}
+
+// ProcessInfo describes a managed process.
+type ProcessInfo struct {
+	ID        string    `json:"id"`
+	Command   string    `json:"command"`
+	Dir       string    `json:"working_dir"`
+	Pid       int       `json:"pid"`
+	Status    string    `json:"status"`
+	StartedAt time.Time `json:"started_at"`
+
+	// Only set once the process has exited
+	ExitCode *int       `json:"exit_code,omitempty"`
+	ExitedAt *time.Time `json:"exited_at,omitempty"`
+
+	// Total bytes of output written so far
+	OutputBytes int64 `json:"output_bytes"`
+}
+
+// Running returns true until the process exits.
+func (p *Process) Running() bool {
+	select {
+	case <-p.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// Info returns a snapshot of the process' state.
+func (p *Process) Info() *ProcessInfo {
+	info := &ProcessInfo{
+		ID:          p.ID,
+		Command:     p.Command,
+		Dir:         p.Dir,
+		Pid:         p.cmd.Process.Pid,
+		Status:      ProcessRunning,
+		StartedAt:   p.StartedAt,
+		OutputBytes: p.output.Total(),
+	}
+	if !p.Running() {
+		info.Status = ProcessExited
+		info.ExitCode, info.ExitedAt = Ptr(p.exitCode), Ptr(p.exitedAt)
+	}
+	return info
+}
+
+// Output returns the process' output (see RingBuffer.ReadAt).
+func (p *Process) Output(offset int64, limit int) (data []byte, start, next int64) {
+	return p.output.ReadAt(offset, limit)
+}
+
+// Wait waits for the process to exit or ctx to be done.
+func (p *Process) Wait(ctx context.Context) error {
+	select {
+	case <-p.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ProcessManager runs commands in the background (eg dev servers and test
+// watchers) keeping the tail of their output so tools can check on them
+// between calls.  Processes run in their own process group and are killed by
+// StopAll.
+type ProcessManager struct {
+	// Bytes of output kept per process.  Defaults to DefaultProcessBufferSize.
+	BufferSize int
+
+	mu     sync.Mutex
+	procs  map[string]*Process
+	nextID int
+	closed bool
+}
+
+// NewProcessManager creates an empty ProcessManager.
+func NewProcessManager() *ProcessManager {
+	return &ProcessManager{BufferSize: DefaultProcessBufferSize, procs: map[string]*Process{}}
+}
+
+// Start starts a command in the background (see CommandOptions - Timeout,
+// MaxOutput and Stdin are ignored as the process runs until stopped).
+func (m *ProcessManager) Start(command string, opts CommandOptions) (*Process, error) {
+	argv := shellCommand(command)
+	if !opts.Shell {
+		var err error
+		if argv, err = SplitCommand(command); err != nil {
+			return nil, err
+		}
+		if len(argv) == 0 {
+			return nil, fmt.Errorf("empty command")
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil, fmt.Errorf("the process manager has been stopped")
+	}
+
+	// The process outlives the tool call starting it so it is not tied to
+	// the call's context
+	ctx, cancel := context.WithCancel(context.Background())
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Dir = opts.Dir
+	if len(opts.Env) > 0 {
+		cmd.Env = append(os.Environ(), opts.Env...)
+	}
+	size := m.BufferSize
+	if size <= 0 {
+		size = DefaultProcessBufferSize
+	}
+	proc := &Process{Command: command, Dir: opts.Dir, cmd: cmd, cancel: cancel, output: NewRingBuffer(size), done: make(chan struct{})}
+	cmd.Stdout, cmd.Stderr = proc.output, proc.output
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	proc.stdin = stdin
+	killProcessGroup(cmd)
+	cmd.WaitDelay = time.Second
+	if err := cmd.Start(); err != nil {
+		cancel()
+		return nil, err
+	}
+
+	m.nextID++
+	proc.ID, proc.seq, proc.StartedAt = fmt.Sprintf("p%d", m.nextID), m.nextID, time.Now()
+	m.procs[proc.ID] = proc
+	go func() {
+		cmd.Wait()
+		proc.exitCode, proc.exitedAt = cmd.ProcessState.ExitCode(), time.Now()
+		cancel()
+		close(proc.done)
+	}()
+	return proc, nil
+}
+
+// Get returns the process with the given id.
+func (m *ProcessManager) Get(id string) (*Process, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	proc, ok := m.procs[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownProcess, id)
+	}
+	return proc, nil
+}
+
+// List returns all processes (running or exited) in the order they were
+// started.
+func (m *ProcessManager) List() (out []*Process) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, proc := range m.procs {
+		out = append(out, proc)
+	}
+	slices.SortFunc(out, func(a, b *Process) int { return a.seq - b.seq })
+	return
+}
+
+// SendInput writes input to a process' stdin, closing it afterwards if
+// closeStdin is set.
+func (m *ProcessManager) SendInput(id, input string, closeStdin bool) error {
+	proc, err := m.Get(id)
+	if err != nil {
+		return err
+	}
+	if !proc.Running() {
+		return fmt.Errorf("process %s has exited", id)
+	}
+	if input != "" {
+		if _, err := io.WriteString(proc.stdin, input); err != nil {
+			return err
+		}
+	}
+	if closeStdin {
+		return proc.stdin.Close()
+	}
+	return nil
+}
+
+// Stop asks a process (and its process group) to terminate and kills it if
+// it has not exited after grace (or straight away if grace is 0).
+func (m *ProcessManager) Stop(ctx context.Context, id string, grace time.Duration) (*Process, error) {
+	proc, err := m.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if !proc.Running() {
+		return proc, nil
+	}
+	if grace > 0 && terminateProcessGroup(proc.cmd) == nil {
+		waitCtx, cancel := context.WithTimeout(ctx, grace)
+		defer cancel()
+		if proc.Wait(waitCtx) == nil {
+			return proc, nil
+		}
+	}
+	proc.cancel()
+	return proc, proc.Wait(ctx)
+}
+
+// StopAll kills every running process.  No processes can be started
+// afterwards.
+func (m *ProcessManager) StopAll() {
+	m.mu.Lock()
+	m.closed = true
+	procs := make([]*Process, 0, len(m.procs))
+	for _, proc := range m.procs {
+		procs = append(procs, proc)
+	}
+	m.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, proc := range procs {
+		proc.cancel()
+	}
+	for _, proc := range procs {
+		proc.Wait(ctx)
+	}
+}
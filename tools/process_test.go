@@ -0,0 +1,91 @@
+package tools
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestRingBuffer(t *testing.T) {
+	buf := NewRingBuffer(8)
+	buf.Write([]byte("hello"))
+	if data, start, next := buf.ReadAt(0, 100); string(data) != "hello" || start != 0 || next != 5 {
+		t.Errorf("unexpected read %q %d %d", data, start, next)
+	}
+	buf.Write([]byte(" world"))
+	if data, start, next := buf.ReadAt(5, 100); string(data) != " world" || start != 5 || next != 11 {
+		t.Errorf("unexpected incremental read %q %d %d", data, start, next)
+	}
+	// The start was overwritten
+	if data, start, next := buf.ReadAt(0, 4); string(data) != "lo w" || start != 3 || next != 7 {
+		t.Errorf("unexpected read of dropped output %q %d %d", data, start, next)
+	}
+	if data, start, next := buf.ReadAt(20, 4); len(data) != 0 || start != 11 || next != 11 {
+		t.Errorf("unexpected read past the end %q %d %d", data, start, next)
+	}
+}
+
+func TestProcessTools(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses POSIX commands")
+	}
+	registry := NewRegistry(NewWorkspace(t.TempDir()))
+	if _, ok := registry.Get("start_process"); ok {
+		t.Errorf("expected the process tools to only be added on request")
+	}
+	// One shot registries say why they are missing
+	if env := registry.Invoke(t.Context(), "list_processes", nil); env.Ok || env.Error.Code != ErrCodeUnknownTool || !strings.Contains(env.Error.Message, "tools mcp") {
+		t.Errorf("expected the missing process tools to be explained, got %s", env)
+	}
+	registry.AddProcessTools()
+	defer registry.Close()
+	call := func(name string, args map[string]any) *Envelope {
+		env := registry.Invoke(t.Context(), name, args)
+		if !env.Ok {
+			t.Fatalf("%s failed: %s", name, env)
+		}
+		return env
+	}
+
+	// A process echoing its input back until stdin is closed
+	proc := call("start_process", map[string]any{"command": "echo ready; cat; echo done >&2", "shell": true}).Result.(*ProcessInfo)
+	if proc.Status != ProcessRunning || proc.ID == "" {
+		t.Fatalf("expected a running process, got %+v", proc)
+	}
+	out := call("read_process_output", map[string]any{"id": proc.ID, "wait_seconds": 5}).Result.(*ProcessOutput)
+	if out.Output != "ready\n" || out.NextOffset != 6 {
+		t.Errorf("unexpected output %+v", out)
+	}
+
+	call("send_process_input", map[string]any{"id": proc.ID, "input": "ping\n"})
+	out = call("read_process_output", map[string]any{"id": proc.ID, "offset": out.NextOffset, "wait_seconds": 5}).Result.(*ProcessOutput)
+	if out.Output != "ping\n" || out.Offset != 6 {
+		t.Errorf("expected only the new output, got %+v", out)
+	}
+
+	call("send_process_input", map[string]any{"id": proc.ID, "input": "", "close_stdin": true})
+
+	// A process that has to be stopped
+	sleeper := call("start_process", map[string]any{"command": "sleep 30"}).Result.(*ProcessInfo)
+	listed := call("list_processes", map[string]any{}).Result.([]*ProcessInfo)
+	if len(listed) != 2 || listed[0].ID != proc.ID || listed[1].ID != sleeper.ID {
+		t.Errorf("unexpected processes %+v", listed)
+	}
+	stopped := call("stop_process", map[string]any{"id": sleeper.ID}).Result.(*ProcessInfo)
+	if stopped.Status != ProcessExited || stopped.ExitCode == nil {
+		t.Errorf("expected the process to be stopped, got %+v", stopped)
+	}
+
+	if p, err := registry.Processes.Get(proc.ID); err != nil || p.Wait(t.Context()) != nil {
+		t.Fatalf("expected the first process to exit once its input was closed: %v", err)
+	}
+	final := call("read_process_output", map[string]any{"id": proc.ID}).Result.(*ProcessOutput)
+	if !strings.HasSuffix(final.Output, "done\n") || final.Status != ProcessExited || *final.ExitCode != 0 {
+		t.Errorf("expected the first process to have finished, got %+v", final)
+	}
+
+	env := registry.Invoke(t.Context(), "read_process_output", map[string]any{"id": "p99"})
+	if env.Ok || env.Error.Code != ErrCodeNotFound {
+		t.Errorf("expected an unknown process to not be found, got %s", env)
+	}
+}
@@ -0,0 +1,397 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Default bytes of output returned by read_process_output
+const DefaultProcessReadBytes = 16 * 1024
+
+// BaseProcessTool is embedded by the tools managing background processes.
+type BaseProcessTool struct {
+	BaseFileTool
+	Processes *ProcessManager
+}
+
+var processIdParameter = &Parameter{
+	Name:        "id",
+	Description: "Id of the process (as returned by start_process)",
+	Type:        "string",
+	Required:    true,
+	MinLength:   Ptr(1),
+}
+
+// StartProcess starts a command in the background.
+type StartProcess struct {
+	BaseProcessTool
+}
+
+func (s *StartProcess) Name() string {
+	return "start_process"
+}
+
+func (s *StartProcess) Description() string {
+	return `
+	Starts a long running command (eg a dev server, a file watcher or a test watcher) in the background and returns straight away with the id of
+	the process.   Use read_process_output to check its output, send_process_input to write to its standard input and stop_process to stop it.
+	Use run_shell_command instead for commands that finish on their own.
+
+	Its stdout and stderr are interleaved and only the most recent output is kept.   Processes are stopped when the vibrant session serving the
+	tools exits, so these tools are only offered by long running sessions (eg 'vibrant tools mcp').
+	Commands are checked against the project's command policy just like with run_shell_command.
+	`
+}
+
+func (s *StartProcess) Parameters() []*Parameter {
+	return []*Parameter{
+		{
+			Name:        "command",
+			Description: "The command along with all its arguments",
+			Type:        "string",
+			Required:    true,
+			MinLength:   Ptr(1),
+		},
+		{
+			Name:        "working_dir",
+			Description: "Directory from which to run the command.  If this is not specified the command will be run from the project's root directory",
+			Type:        "string",
+			Default:     ".",
+		},
+		{
+			Name:        "shell",
+			Description: "Run the command with the system shell so pipes, redirects, globs, variables and '&&' work",
+			Type:        "boolean",
+			Default:     false,
+		},
+		{
+			Name:        "env",
+			Description: "Environment variables to set for the command as 'NAME=value' strings",
+			Type:        "array",
+			Items:       &Parameter{Type: "string", Pattern: `^[A-Za-z_][A-Za-z0-9_]*=`},
+		},
+	}
+}
+
+func (s *StartProcess) Returns() []*Parameter {
+	return []*Parameter{
+		{
+			Name:        "process",
+			Description: "The id, pid and status of the started process",
+			Type:        "object",
+		},
+	}
+}
+
+// Preview shows the command and what the command policy makes of it.
+func (s *StartProcess) Preview(ctx context.Context, args map[string]any) (string, error) {
+	return s.PreviewCommand(args)
+}
+
+func (s *StartProcess) Run(args map[string]any) (any, error) {
+	return s.RunContext(context.Background(), args)
+}
+
+func (s *StartProcess) RunContext(ctx context.Context, args map[string]any) (any, error) {
+	working_dir := "."
+	if val, ok := args["working_dir"].(string); ok && val != "" {
+		working_dir = val
+	}
+	dir, err := s.ResolvePath(working_dir)
+	if err != nil {
+		return nil, err
+	}
+	command, _ := args["command"].(string)
+	if strings.TrimSpace(command) == "" {
+		return nil, fmt.Errorf("please provide a command to start")
+	}
+	opts := CommandOptions{Dir: dir, Env: stringsArg(args, "env")}
+	opts.Shell, _ = args["shell"].(bool)
+	if err := s.CheckCommand(ctx, command, opts.Shell, working_dir, dir); err != nil {
+		return nil, err
+	}
+	proc, err := s.Processes.Start(command, opts)
+	if err != nil {
+		return nil, err
+	}
+	return proc.Info(), nil
+}
+
+// ReadProcessOutput returns the output of a background process.
+type ReadProcessOutput struct {
+	BaseProcessTool
+}
+
+// ProcessOutput is the result of read_process_output.
+type ProcessOutput struct {
+	*ProcessInfo
+
+	Output string `json:"output"`
+
+	// Offset of the first byte returned and of the next byte to read
+	Offset     int64 `json:"offset"`
+	NextOffset int64 `json:"next_offset"`
+
+	// Bytes between the requested offset and Offset that were no longer kept
+	Dropped int64 `json:"dropped,omitempty"`
+}
+
+func (r *ReadProcessOutput) Name() string {
+	return "read_process_output"
+}
+
+func (r *ReadProcessOutput) ReadOnly() bool {
+	return true
+}
+
+func (r *ReadProcessOutput) Description() string {
+	return `
+	Returns the output (stdout and stderr interleaved) of a process started with start_process along with whether it is still running.
+
+	Output is read incrementally - pass the 'next_offset' returned by the previous call as 'offset' to only get what was written since.
+	If older output was discarded (only the most recent output of each process is kept) 'dropped' is the number of bytes skipped.
+	`
+}
+
+func (r *ReadProcessOutput) Parameters() []*Parameter {
+	return []*Parameter{
+		processIdParameter,
+		{
+			Name:        "offset",
+			Description: "Offset (in bytes of all the output written so far) to read from - the next_offset of a previous call",
+			Type:        "integer",
+			Default:     0.0,
+			Minimum:     Ptr(0.0),
+		},
+		{
+			Name:        "max_bytes",
+			Description: "Maximum bytes of output to return",
+			Type:        "integer",
+			Default:     float64(DefaultProcessReadBytes),
+			Minimum:     Ptr(1.0),
+			Maximum:     Ptr(1048576.0),
+		},
+		{
+			Name:        "wait_seconds",
+			Description: "If there is no new output yet, wait up to this many seconds for some (or for the process to exit)",
+			Type:        "number",
+			Default:     0.0,
+			Minimum:     Ptr(0.0),
+			Maximum:     Ptr(60.0),
+		},
+	}
+}
+
+func (r *ReadProcessOutput) Returns() []*Parameter {
+	return []*Parameter{
+		{
+			Name:        "output",
+			Description: "The process' status and output along with the offset to read from next",
+			Type:        "object",
+		},
+	}
+}
+
+func (r *ReadProcessOutput) Run(args map[string]any) (any, error) {
+	return r.RunContext(context.Background(), args)
+}
+
+func (r *ReadProcessOutput) RunContext(ctx context.Context, args map[string]any) (any, error) {
+	proc, err := r.Processes.Get(args["id"].(string))
+	if err != nil {
+		return nil, err
+	}
+	offset := int64(intArg(args, "offset", 0))
+	limit := intArg(args, "max_bytes", DefaultProcessReadBytes)
+	if secs, ok := toFloat(args["wait_seconds"]); ok && secs > 0 {
+		deadline := time.Now().Add(time.Duration(secs * float64(time.Second)))
+		for proc.Running() && proc.output.Total() <= offset && time.Now().Before(deadline) {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-proc.done:
+			case <-time.After(50 * time.Millisecond):
+			}
+		}
+	}
+
+	data, start, next := proc.Output(offset, limit)
+	out := &ProcessOutput{ProcessInfo: proc.Info(), Output: string(data), Offset: start, NextOffset: next, Dropped: max(0, start-offset)}
+	if out.Dropped > 0 {
+		Warn(ctx, "%d bytes of output before offset %d were discarded", out.Dropped, start)
+	}
+	return out, nil
+}
+
+// SendProcessInput writes to the stdin of a background process.
+type SendProcessInput struct {
+	BaseProcessTool
+}
+
+func (s *SendProcessInput) Name() string {
+	return "send_process_input"
+}
+
+func (s *SendProcessInput) Description() string {
+	return `
+	Writes text to the standard input of a process started with start_process (eg to answer a prompt or drive a REPL).   Nothing is added to the
+	text so include a trailing newline where one is expected.
+	`
+}
+
+func (s *SendProcessInput) Parameters() []*Parameter {
+	return []*Parameter{
+		processIdParameter,
+		{
+			Name:        "input",
+			Description: "Text to write to the process' standard input",
+			Type:        "string",
+			Required:    true,
+		},
+		{
+			Name:        "close_stdin",
+			Description: "Close the process' standard input after writing (signalling the end of input)",
+			Type:        "boolean",
+			Default:     false,
+		},
+	}
+}
+
+func (s *SendProcessInput) Returns() []*Parameter {
+	return []*Parameter{
+		{
+			Name:        "process",
+			Description: "The status of the process",
+			Type:        "object",
+		},
+	}
+}
+
+func (s *SendProcessInput) Run(args map[string]any) (any, error) {
+	return s.RunContext(context.Background(), args)
+}
+
+func (s *SendProcessInput) RunContext(ctx context.Context, args map[string]any) (any, error) {
+	id := args["id"].(string)
+	closeStdin, _ := args["close_stdin"].(bool)
+	if err := s.Processes.SendInput(id, args["input"].(string), closeStdin); err != nil {
+		return nil, err
+	}
+	proc, err := s.Processes.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	return proc.Info(), nil
+}
+
+// ListProcesses lists the background processes.
+type ListProcesses struct {
+	BaseProcessTool
+}
+
+func (l *ListProcesses) Name() string {
+	return "list_processes"
+}
+
+func (l *ListProcesses) ReadOnly() bool {
+	return true
+}
+
+func (l *ListProcesses) Description() string {
+	return `
+	Lists the processes started with start_process (running or exited) with their ids, status, exit codes and the bytes of output written.
+	`
+}
+
+func (l *ListProcesses) Parameters() []*Parameter {
+	return []*Parameter{}
+}
+
+func (l *ListProcesses) Returns() []*Parameter {
+	return []*Parameter{
+		{
+			Name:        "processes",
+			Description: "The processes in the order they were started",
+			Type:        "array",
+		},
+	}
+}
+
+func (l *ListProcesses) Run(args map[string]any) (any, error) {
+	return l.RunContext(context.Background(), args)
+}
+
+func (l *ListProcesses) RunContext(ctx context.Context, args map[string]any) (any, error) {
+	out := []*ProcessInfo{}
+	for _, proc := range l.Processes.List() {
+		out = append(out, proc.Info())
+	}
+	return out, nil
+}
+
+// StopProcess stops a background process.
+type StopProcess struct {
+	BaseProcessTool
+}
+
+func (s *StopProcess) Name() string {
+	return "stop_process"
+}
+
+func (s *StopProcess) Description() string {
+	return `
+	Stops a process started with start_process along with every process it started.   It is first asked to terminate and is killed if it
+	has not exited within 'grace_seconds' (or straight away with 'force').   Its output can still be read once it has stopped.
+	`
+}
+
+func (s *StopProcess) Parameters() []*Parameter {
+	return []*Parameter{
+		processIdParameter,
+		{
+			Name:        "force",
+			Description: "Kill the process straight away instead of asking it to terminate first",
+			Type:        "boolean",
+			Default:     false,
+		},
+		{
+			Name:        "grace_seconds",
+			Description: "Seconds to wait for the process to exit after asking it to terminate before killing it",
+			Type:        "number",
+			Default:     DefaultProcessStopGrace.Seconds(),
+			Minimum:     Ptr(0.0),
+			Maximum:     Ptr(60.0),
+		},
+	}
+}
+
+func (s *StopProcess) Returns() []*Parameter {
+	return []*Parameter{
+		{
+			Name:        "process",
+			Description: "The status and exit code of the stopped process",
+			Type:        "object",
+		},
+	}
+}
+
+func (s *StopProcess) Run(args map[string]any) (any, error) {
+	return s.RunContext(context.Background(), args)
+}
+
+func (s *StopProcess) RunContext(ctx context.Context, args map[string]any) (any, error) {
+	grace := DefaultProcessStopGrace
+	if secs, ok := toFloat(args["grace_seconds"]); ok {
+		grace = time.Duration(secs * float64(time.Second))
+	}
+	if force, _ := args["force"].(bool); force {
+		grace = 0
+	}
+	proc, err := s.Processes.Stop(ctx, args["id"].(string), grace)
+	if err != nil {
+		return nil, err
+	}
+	return proc.Info(), nil
+}
@@ -1,23 +1,82 @@
 package tools
 
-import "os"
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Default cap on the bytes of content returned by read_file
+const DefaultReadMaxBytes = 256 * 1024
 
 type ReadFile struct {
 	BaseFileTool
 }
 
+// FileMetadata describes a file as returned by read_file.
+type FileMetadata struct {
+	Size     int64  `json:"size"`
+	Modified string `json:"modified"`
+	Mode     string `json:"mode"`
+	Sha256   string `json:"sha256"`
+	Lines    int    `json:"lines,omitempty"`
+}
+
+// ReadFileResult is the result of a read_file call.
+type ReadFileResult struct {
+	Path     string       `json:"path"`
+	Content  string       `json:"content"`
+	Encoding string       `json:"encoding"`
+	MimeType string       `json:"mime_type"`
+	Metadata FileMetadata `json:"metadata"`
+
+	// Hash of the whole file (even if only a window of it was read) to pass
+	// as expected_hash when modifying the file
+	Hash string `json:"hash"`
+
+	// 1-based (inclusive) range of lines included in Content (text files only)
+	StartLine int `json:"start_line,omitempty"`
+	EndLine   int `json:"end_line,omitempty"`
+
+	// Whether Content stops short of the requested range because of max_bytes
+	Truncated bool `json:"truncated"`
+}
+
 func (r *ReadFile) Name() string {
 	return "read_file"
 }
 
+func (r *ReadFile) ReadOnly() bool {
+	return true
+}
+
 func (r *ReadFile) Description() string {
 	return `
 	Reads a file with the path given in the 'path' parameter.  The path of the file is ALWAYS relative to the project root.
 
-	Returns 2 values as json:
+	Use 'offset' and 'limit' to read a window of lines from large files and 'line_numbers' to prefix each line with its line number.
+	Content beyond 'max_bytes' is cut off and 'truncated' is set.  Binary files are returned base64 encoded.
+
+	Returns json with the following fields:
 
-	content: The content bytes of the file
-	metadata: Metadata of the file as a json dictionary.
+	content: The content of the file (or the requested window of it)
+	encoding: 'utf-8' for text files or 'base64' for binary files
+	mime_type: The detected MIME type of the file
+	start_line, end_line: The range of lines returned (for text files)
+	truncated: Whether the content was cut short because of max_bytes
+	hash: Hash of the file contents.  Pass this as 'expected_hash' to tools that modify the file so changes made since the read are not clobbered.
+	metadata: Metadata of the file (size, modified, mode, sha256, lines) as a json dictionary.
 	`
 }
 
@@ -27,6 +86,33 @@ func (r *ReadFile) Parameters() []*Parameter {
 			Name:        "path",
 			Description: "Path of the file to read contents for.  Path will be resolved to a relative path in the current project",
 			Type:        "string",
+			Required:    true,
+		},
+		{
+			Name:        "offset",
+			Description: "1-based line number to start reading from",
+			Type:        "integer",
+			Default:     1.0,
+			Minimum:     Ptr(1.0),
+		},
+		{
+			Name:        "limit",
+			Description: "Maximum number of lines to read.  If not specified all lines till the end of the file are read",
+			Type:        "integer",
+			Minimum:     Ptr(1.0),
+		},
+		{
+			Name:        "line_numbers",
+			Description: "Whether to prefix each line with its line number (followed by a tab)",
+			Type:        "boolean",
+			Default:     false,
+		},
+		{
+			Name:        "max_bytes",
+			Description: "Maximum number of bytes of content to return",
+			Type:        "integer",
+			Default:     float64(DefaultReadMaxBytes),
+			Minimum:     Ptr(1.0),
 		},
 	}
 }
@@ -35,8 +121,8 @@ func (r *ReadFile) Returns() []*Parameter {
 	return []*Parameter{
 		{
 			Name:        "contents",
-			Description: "Contents of the file if it exists.",
-			Type:        "string",
+			Description: "Contents of the file if it exists along with its metadata.",
+			Type:        "object",
 		},
 		{
 			Name:        "error",
@@ -53,9 +139,136 @@ func (r *ReadFile) Run(args map[string]any) (any, error) {
 		return nil, err
 	}
 
-	content, err := os.ReadFile(fullpath)
+	offset := intArg(args, "offset", 1)
+	limit := intArg(args, "limit", 0)
+	maxBytes := intArg(args, "max_bytes", DefaultReadMaxBytes)
+	lineNumbers, _ := args["line_numbers"].(bool)
+
+	file, err := os.Open(fullpath)
 	if err != nil {
 		return nil, err
 	}
-	return string(content), nil
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, fmt.Errorf("%s is a directory", path)
+	}
+
+	result := &ReadFileResult{
+		Path: path,
+		Metadata: FileMetadata{
+			Size:     info.Size(),
+			Modified: info.ModTime().Format(time.RFC3339),
+			Mode:     info.Mode().String(),
+		},
+	}
+
+	// Everything read goes through the hasher so the hash covers the whole
+	// file even if only a window of it is returned
+	hasher := sha256.New()
+	reader := bufio.NewReader(io.TeeReader(file, hasher))
+	head, _ := reader.Peek(8000)
+	result.MimeType = detectMimeType(fullpath, head)
+
+	if isBinary(head) {
+		data, err := io.ReadAll(io.LimitReader(reader, int64(maxBytes)))
+		if err != nil {
+			return nil, err
+		}
+		io.Copy(io.Discard, reader)
+		result.Encoding = "base64"
+		result.Content = base64.StdEncoding.EncodeToString(data)
+		result.Truncated = int64(len(data)) < info.Size()
+	} else {
+		result.Encoding = "utf-8"
+		if err := readLines(reader, result, offset, limit, maxBytes, lineNumbers); err != nil {
+			return nil, err
+		}
+		if result.Truncated {
+			result.Content += fmt.Sprintf("\n... [truncated at %d bytes - use offset/limit to read the rest] ...\n", maxBytes)
+		}
+	}
+	result.Metadata.Sha256 = hex.EncodeToString(hasher.Sum(nil))
+	result.Hash = result.Metadata.Sha256
+	r.Files.Record(fullpath, result.Hash)
+	return result, nil
+}
+
+// readLines reads all lines from the reader and collects the ones in the
+// requested window (till maxBytes of content is collected) into the result.
+func readLines(reader *bufio.Reader, result *ReadFileResult, offset, limit, maxBytes int, lineNumbers bool) error {
+	var content strings.Builder
+	lineno := 0
+	for {
+		line, err := reader.ReadString('\n')
+		if len(line) > 0 {
+			lineno++
+			inWindow := lineno >= offset && (limit <= 0 || lineno < offset+limit)
+			if inWindow && !result.Truncated {
+				if lineNumbers {
+					line = fmt.Sprintf("%6d\t%s", lineno, line)
+				}
+				if content.Len()+len(line) > maxBytes {
+					// Include as much of the line as fits without splitting a rune
+					rest := line[:maxBytes-content.Len()]
+					for len(rest) > 0 && !utf8.ValidString(rest) {
+						rest = rest[:len(rest)-1]
+					}
+					content.WriteString(rest)
+					result.Truncated = true
+				} else {
+					content.WriteString(line)
+				}
+				if result.StartLine == 0 {
+					result.StartLine = lineno
+				}
+				result.EndLine = lineno
+			}
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+	result.Metadata.Lines = lineno
+	if offset > 1 && offset > lineno {
+		return fmt.Errorf("offset %d is beyond the end of the file (%d lines)", offset, lineno)
+	}
+	result.Content = content.String()
+	return nil
+}
+
+// isBinary guesses whether content (usually the first few KB of a file) is
+// binary - ie it contains NUL bytes or is not valid UTF-8.
+func isBinary(content []byte) bool {
+	if bytes.IndexByte(content, 0) >= 0 {
+		return true
+	}
+	// The sample may end in the middle of a multi byte rune
+	for i := 0; i < utf8.UTFMax && i <= len(content); i++ {
+		if utf8.Valid(content[:len(content)-i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func detectMimeType(path string, head []byte) string {
+	if mimeType := mime.TypeByExtension(filepath.Ext(path)); mimeType != "" {
+		return mimeType
+	}
+	return http.DetectContentType(head)
+}
+
+// intArg returns an integer argument (which JSON decodes as a float64) or the
+// default if the argument was not provided.
+func intArg(args map[string]any, name string, def int) int {
+	if num, ok := toFloat(args[name]); ok {
+		return int(num)
+	}
+	return def
 }
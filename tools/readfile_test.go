@@ -0,0 +1,68 @@
+package tools
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadFile(t *testing.T) {
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, "abc.txt"), []byte("one\ntwo\nthree\n"), 0644)
+	os.WriteFile(filepath.Join(root, "accent.txt"), []byte("aé\n"), 0644)
+	os.WriteFile(filepath.Join(root, "image.bin"), []byte{0, 1, 2, 0xff}, 0644)
+	registry := NewRegistry(NewWorkspace(root))
+	read := func(args map[string]any) *Envelope {
+		return registry.Invoke(t.Context(), "read_file", args)
+	}
+
+	env := read(map[string]any{"path": "abc.txt"})
+	if !env.Ok {
+		t.Fatalf("read failed: %s", env)
+	}
+	result := env.Result.(*ReadFileResult)
+	if result.Content != "one\ntwo\nthree\n" || result.StartLine != 1 || result.EndLine != 3 || result.Truncated || result.Metadata.Lines != 3 {
+		t.Errorf("unexpected result: %+v", result)
+	}
+	if result.Hash != HashContent([]byte("one\ntwo\nthree\n")) || result.Encoding != "utf-8" {
+		t.Errorf("unexpected hash or encoding: %+v", result)
+	}
+
+	env = read(map[string]any{"path": "abc.txt", "offset": 2, "limit": 1, "line_numbers": true})
+	if result := env.Result.(*ReadFileResult); result.Content != "     2\ttwo\n" || result.StartLine != 2 || result.EndLine != 2 {
+		t.Errorf("unexpected window with line numbers: %+v", result)
+	}
+	// The hash is always of the whole file
+	if result := env.Result.(*ReadFileResult); result.Hash != HashContent([]byte("one\ntwo\nthree\n")) {
+		t.Errorf("expected the hash of the whole file, got %s", result.Hash)
+	}
+
+	env = read(map[string]any{"path": "abc.txt", "offset": 5})
+	if env.Ok || !strings.Contains(env.Error.Message, "offset 5 is beyond the end of the file (3 lines)") {
+		t.Errorf("expected an offset past the end to fail, got %s", env)
+	}
+	env = read(map[string]any{"path": "abc.txt", "limit": 0})
+	if env.Ok || env.Error.Code != ErrCodeInvalidArguments || !strings.Contains(env.Error.Message, "limit") {
+		t.Errorf("expected a limit of 0 to be rejected, got %s", env)
+	}
+
+	// max_bytes cuts lines short (but never in the middle of a rune)
+	env = read(map[string]any{"path": "abc.txt", "max_bytes": 6})
+	if result := env.Result.(*ReadFileResult); !result.Truncated || !strings.HasPrefix(result.Content, "one\ntw\n... [truncated at 6 bytes") || result.EndLine != 2 {
+		t.Errorf("unexpected truncated result: %+v", result)
+	}
+	env = read(map[string]any{"path": "accent.txt", "max_bytes": 2})
+	if result := env.Result.(*ReadFileResult); !result.Truncated || !strings.HasPrefix(result.Content, "a\n... [truncated") {
+		t.Errorf("expected the rune to be left out, got %q", result.Content)
+	}
+
+	env = read(map[string]any{"path": "image.bin"})
+	if result := env.Result.(*ReadFileResult); result.Encoding != "base64" || result.Content != "AAEC/w==" || result.Truncated {
+		t.Errorf("expected binary files to be base64 encoded, got %+v", result)
+	}
+	env = read(map[string]any{"path": "image.bin", "max_bytes": 2})
+	if result := env.Result.(*ReadFileResult); result.Content != "AAE=" || !result.Truncated {
+		t.Errorf("expected binary content to be truncated, got %+v", result)
+	}
+}
@@ -1,6 +1,7 @@
 package tools
 
 import (
+	"context"
 	"fmt"
 	"os"
 )
@@ -44,7 +45,16 @@ func (r *RenameFile) Returns() []*Parameter {
 	}
 }
 
+// Preview describes the rename.
+func (r *RenameFile) Preview(ctx context.Context, args map[string]any) (string, error) {
+	return fmt.Sprintf("Rename %s to %s", args["src"], args["dest"]), nil
+}
+
 func (r *RenameFile) Run(args map[string]any) (any, error) {
+	return r.RunContext(context.Background(), args)
+}
+
+func (r *RenameFile) RunContext(ctx context.Context, args map[string]any) (any, error) {
 	srcpath := args["src"].(string)
 	fullsrcpath, err := r.ResolvePath(srcpath)
 	if err != nil {
@@ -55,11 +65,19 @@ func (r *RenameFile) Run(args map[string]any) (any, error) {
 	if err != nil {
 		return nil, err
 	}
+	tx := r.Journal.Begin(r.Name())
+	trackChange(ctx, tx, srcpath, fullsrcpath)
+	trackChange(ctx, tx, destpath, fulldestpath)
 	err = os.Rename(fullsrcpath, fulldestpath)
 
 	if err != nil {
 		return "", err
 	}
+	commitChange(ctx, tx)
+	if hash, ok := r.Files.LastSeen(fullsrcpath); ok {
+		r.Files.Forget(fullsrcpath)
+		r.Files.Record(fulldestpath, hash)
+	}
 
 	return fmt.Sprintf("Successfully renamed to %s to %s", srcpath, destpath), nil
 }
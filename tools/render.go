@@ -0,0 +1,351 @@
+package tools
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// A PatchExplanation records how each hunk of a patch was aligned against a
+// file (and what the fuzzy matcher made of it) so it can be rendered.
+type PatchExplanation struct {
+	Path   string        `json:"path"`
+	Format string        `json:"format"`
+	Hunks  []*HunkResult `json:"hunks"`
+}
+
+// ExplainPatch applies the hunks of a patch (in any format ParsePatch
+// understands) for the file at path to its contents in memory, recording how
+// each hunk was aligned.  Nothing is written.  Patches covering several files
+// are narrowed down to the one for path.
+func ExplainPatch(path string, contents string, patch string, costs *CostModel, minConfidence float64) (*PatchExplanation, error) {
+	patches, format, err := ParsePatch(patch)
+	if err != nil {
+		return nil, err
+	}
+	fp := patches[0]
+	if len(patches) > 1 {
+		var paths []string
+		fp = nil
+		for _, candidate := range patches {
+			paths = append(paths, candidate.Path())
+			if candidate.Path() == path || strings.HasSuffix(path, "/"+candidate.Path()) {
+				fp = candidate
+			}
+		}
+		if fp == nil {
+			return nil, fmt.Errorf("the patch does not change %s (it changes %s)", path, strings.Join(paths, ", "))
+		}
+	}
+	_, results := ApplyHunks(SplitLines(contents), fp.Hunks, costs, minConfidence)
+	return &PatchExplanation{Path: path, Format: format, Hunks: results}, nil
+}
+
+// RenderOptions control how a PatchExplanation is rendered.
+type RenderOptions struct {
+	// Whether to use ANSI colors (ignored by the json and html renderers)
+	Color bool
+
+	// Total width of the side by side view
+	Width int
+}
+
+// An AlignmentRenderer writes a PatchExplanation in some format.
+type AlignmentRenderer func(w io.Writer, explanation *PatchExplanation, opts RenderOptions) error
+
+// Renderers for PatchExplanations by name
+var AlignmentRenderers = map[string]AlignmentRenderer{
+	"diff":         RenderAlignmentDiff,
+	"side-by-side": RenderAlignmentSideBySide,
+	"json":         RenderAlignmentJSON,
+	"html":         RenderAlignmentHTML,
+}
+
+// AlignmentRendererNames returns the names of the AlignmentRenderers.
+func AlignmentRendererNames() []string {
+	var names []string
+	for name := range AlignmentRenderers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+const (
+	ansiReset   = "\033[0m"
+	ansiBold    = "\033[1m"
+	ansiDim     = "\033[2m"
+	ansiRed     = "\033[31m"
+	ansiGreen   = "\033[32m"
+	ansiYellow  = "\033[33m"
+	ansiMagenta = "\033[35m"
+)
+
+// How each alignment op is presented
+type opStyle struct {
+	Name   string // stable name used in the json trace and html classes
+	Marker string // diff style marker
+	Color  string // ansi color
+	Label  string // what the op means
+}
+
+var opStyles = map[EditOp]opStyle{
+	KEPT_INPUT_UNAFFECTED:         {"kept", " ", ansiDim, "line of the file the patch does not mention"},
+	CONTEXT_MATCHED:               {"context", " ", "", "context line found in the file"},
+	CONTEXT_SKIPPED_PATCH_OP:      {"context_missing", "?", ansiYellow, "context line not found in the file"},
+	DELETED_INPUT_MATCHED_PATCH:   {"deleted", "-", ansiRed, "line deleted"},
+	DELETED_INPUT_UNMATCHED_PATCH: {"deleted_unmatched", "!", ansiMagenta, "line deleted although it does not match the patch"},
+	DELETE_SKIPPED_PATCH_OP:       {"delete_missing", "?", ansiYellow, "line to delete not found in the file"},
+	ADDED_FROM_PATCH:              {"added", "+", ansiGreen, "line added"},
+	NOOP:                          {"invalid", "#", ansiYellow, "patch line without a ' ', '+' or '-' prefix (ignored)"},
+}
+
+func styleOf(op EditOp) opStyle {
+	if style, ok := opStyles[op]; ok {
+		return style
+	}
+	return opStyle{fmt.Sprintf("op_%d", op), "#", ansiYellow, "unknown operation"}
+}
+
+// String returns the name of the op as used in rendered alignments.
+func (op EditOp) String() string {
+	return styleOf(op).Name
+}
+
+// text returns the line an aligned op is about - the file's line if it has
+// one and the patch's otherwise.
+func (a AlignedLine) text() string {
+	switch a.Type {
+	case KEPT_INPUT_UNAFFECTED, CONTEXT_MATCHED, DELETED_INPUT_MATCHED_PATCH, DELETED_INPUT_UNMATCHED_PATCH:
+		return a.InputLine
+	}
+	return a.PatchLine
+}
+
+// hasInput returns true if the aligned op consumed a line of the file.
+func (a AlignedLine) hasInput() bool {
+	switch a.Type {
+	case KEPT_INPUT_UNAFFECTED, CONTEXT_MATCHED, DELETED_INPUT_MATCHED_PATCH, DELETED_INPUT_UNMATCHED_PATCH:
+		return true
+	}
+	return false
+}
+
+func colorize(s, color string, on bool) string {
+	if !on || color == "" {
+		return s
+	}
+	return color + s + ansiReset
+}
+
+func hunkSummary(i int, hunk *HunkResult) string {
+	summary := fmt.Sprintf("hunk %d %s: %s (%.0f%% confidence", i, hunk.Header, hunk.Status, hunk.Confidence*100)
+	if hunk.StartLine > 0 {
+		summary += fmt.Sprintf(", at line %d", hunk.StartLine)
+	}
+	summary += ")"
+	if hunk.Message != "" {
+		summary += " - " + hunk.Message
+	}
+	return summary
+}
+
+func statusColor(status string) string {
+	switch status {
+	case HunkClean:
+		return ansiGreen
+	case HunkFuzzy:
+		return ansiYellow
+	}
+	return ansiRed
+}
+
+// RenderAlignmentDiff renders each hunk as a unified diff annotated with how
+// the patch lines were matched: '?' marks patch lines missing from the file
+// and '!' lines deleted that did not match the patch.  Lines are numbered
+// with the file's line numbers.
+func RenderAlignmentDiff(w io.Writer, explanation *PatchExplanation, opts RenderOptions) error {
+	fmt.Fprintln(w, colorize(fmt.Sprintf("%s (%s patch)", explanation.Path, explanation.Format), ansiBold, opts.Color))
+	for i, hunk := range explanation.Hunks {
+		renderHunkDiff(w, i, hunk, opts)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, alignmentLegend)
+	return nil
+}
+
+const alignmentLegend = "Legend: ' ' context/unchanged, '-' deleted, '+' added, '?' not found in the file, '!' deleted without matching, '#' ignored"
+
+func renderHunkDiff(w io.Writer, i int, hunk *HunkResult, opts RenderOptions) {
+	fmt.Fprintln(w, colorize(hunkSummary(i, hunk), statusColor(hunk.Status)+ansiBold, opts.Color))
+	for _, aligned := range hunk.Alignment {
+		style := styleOf(aligned.Type)
+		lineNo := "     "
+		if aligned.hasInput() {
+			lineNo = fmt.Sprintf("%5d", aligned.InputLineNo)
+		}
+		fmt.Fprintf(w, "%s %s\n", colorize(lineNo, ansiDim, opts.Color), colorize(style.Marker+aligned.text(), style.Color, opts.Color))
+	}
+}
+
+// RenderAlignmentSideBySide renders each hunk in two columns - the file's
+// lines on the left and the patch lines they were aligned with on the right.
+func RenderAlignmentSideBySide(w io.Writer, explanation *PatchExplanation, opts RenderOptions) error {
+	width := opts.Width
+	if width <= 0 {
+		width = 160
+	}
+	// "nnnnn " + text + " x " + text
+	column := max(10, (width-9)/2)
+	fmt.Fprintln(w, colorize(fmt.Sprintf("%s (%s patch)", explanation.Path, explanation.Format), ansiBold, opts.Color))
+	for i, hunk := range explanation.Hunks {
+		fmt.Fprintln(w, colorize(hunkSummary(i, hunk), statusColor(hunk.Status)+ansiBold, opts.Color))
+		fmt.Fprintf(w, "%s %s\n", colorize(padRight("      file", column+6), ansiDim, opts.Color), colorize("   patch", ansiDim, opts.Color))
+		for _, aligned := range hunk.Alignment {
+			style := styleOf(aligned.Type)
+			left := strings.Repeat(" ", column+6)
+			if aligned.hasInput() {
+				left = fmt.Sprintf("%5d %s", aligned.InputLineNo, padRight(clipColumn(aligned.InputLine, column), column))
+			}
+			right := ""
+			if aligned.Type != KEPT_INPUT_UNAFFECTED {
+				right = clipColumn(aligned.PatchLine, column)
+			}
+			fmt.Fprintln(w, colorize(strings.TrimRight(fmt.Sprintf("%s %s %s", left, style.Marker, right), " "), style.Color, opts.Color))
+		}
+	}
+	return nil
+}
+
+// clipColumn expands tabs and cuts s to fit in width columns.
+func clipColumn(s string, width int) string {
+	s = strings.ReplaceAll(s, "\t", "    ")
+	if utf8.RuneCountInString(s) <= width {
+		return s
+	}
+	return string([]rune(s)[:width-1]) + "…"
+}
+
+func padRight(s string, width int) string {
+	if n := utf8.RuneCountInString(s); n < width {
+		return s + strings.Repeat(" ", width-n)
+	}
+	return s
+}
+
+type alignedLineJSON struct {
+	Op          string  `json:"op"`
+	InputLineNo int     `json:"input_line_no,omitempty"`
+	InputLine   *string `json:"input_line,omitempty"`
+	PatchLineNo int     `json:"patch_line_no,omitempty"`
+	PatchLine   *string `json:"patch_line,omitempty"`
+}
+
+type hunkJSON struct {
+	*HunkResult
+	Trace []alignedLineJSON `json:"trace"`
+}
+
+func explanationTraces(explanation *PatchExplanation) []hunkJSON {
+	var hunks []hunkJSON
+	for _, hunk := range explanation.Hunks {
+		out := hunkJSON{HunkResult: hunk, Trace: []alignedLineJSON{}}
+		for _, aligned := range hunk.Alignment {
+			line := alignedLineJSON{Op: aligned.Type.String()}
+			if aligned.hasInput() {
+				line.InputLineNo, line.InputLine = aligned.InputLineNo, &aligned.InputLine
+			}
+			if aligned.Type != KEPT_INPUT_UNAFFECTED {
+				line.PatchLineNo, line.PatchLine = aligned.PatchLineNo, &aligned.PatchLine
+			}
+			out.Trace = append(out.Trace, line)
+		}
+		hunks = append(hunks, out)
+	}
+	return hunks
+}
+
+// RenderAlignmentJSON writes the explanation along with the trace of
+// alignment ops of each hunk as JSON.
+func RenderAlignmentJSON(w io.Writer, explanation *PatchExplanation, opts RenderOptions) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(map[string]any{
+		"path":   explanation.Path,
+		"format": explanation.Format,
+		"hunks":  explanationTraces(explanation),
+	})
+}
+
+var alignmentHTMLTemplate = template.Must(template.New("explanation").Funcs(template.FuncMap{
+	"style": styleOf,
+	"pct":   func(f float64) string { return fmt.Sprintf("%.0f%%", f*100) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Path}} - patch explanation</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; font-family: monospace; white-space: pre; width: 100%; margin-bottom: 2em; }
+td { padding: 0 0.5em; vertical-align: top; }
+td.no { color: #888; text-align: right; width: 4em; }
+td.marker { width: 1em; font-weight: bold; }
+.kept { color: #777; }
+.deleted { background: #fdd; }
+.added { background: #dfd; }
+.context_missing, .delete_missing, .invalid { background: #ffd; }
+.deleted_unmatched { background: #fdf; }
+h2 { font-size: 1em; }
+h2.clean { color: #080; } h2.fuzzy { color: #a60; } h2.conflicted, h2.failed { color: #c00; }
+</style>
+</head>
+<body>
+<h1>{{.Path}} <small>({{.Format}} patch)</small></h1>
+{{range $i, $hunk := .Hunks}}
+<h2 class="{{$hunk.Status}}">Hunk {{$i}} <code>{{$hunk.Header}}</code>: {{$hunk.Status}} ({{pct $hunk.Confidence}} confidence{{if $hunk.StartLine}}, at line {{$hunk.StartLine}}{{end}})</h2>
+{{if $hunk.Message}}<p>{{$hunk.Message}}</p>{{end}}
+<table>
+<tr><th>File</th><th></th><th></th><th>Patch</th><th></th></tr>
+{{range $hunk.Trace}}{{$style := style .Type}}<tr class="{{$style.Name}}" title="{{$style.Label}}">
+<td class="no">{{if .InputLineNo}}{{.InputLineNo}}{{end}}</td><td>{{.InputLine}}</td><td class="marker">{{$style.Marker}}</td><td>{{.PatchLine}}</td><td class="no">{{if .PatchLineNo}}{{.PatchLineNo}}{{end}}</td>
+</tr>
+{{end}}</table>
+{{end}}
+</body>
+</html>
+`))
+
+type hunkHTML struct {
+	*HunkResult
+	Trace []AlignedLine
+}
+
+// RenderAlignmentHTML writes the explanation as a standalone HTML page with
+// a table per hunk of the file's lines next to the patch lines they were
+// aligned with.
+func RenderAlignmentHTML(w io.Writer, explanation *PatchExplanation, opts RenderOptions) error {
+	var hunks []hunkHTML
+	for _, hunk := range explanation.Hunks {
+		out := hunkHTML{HunkResult: hunk}
+		for _, aligned := range hunk.Alignment {
+			if !aligned.hasInput() {
+				aligned.InputLine, aligned.InputLineNo = "", 0
+			}
+			if aligned.Type == KEPT_INPUT_UNAFFECTED {
+				aligned.PatchLine = ""
+			}
+			out.Trace = append(out.Trace, aligned)
+		}
+		hunks = append(hunks, out)
+	}
+	return alignmentHTMLTemplate.Execute(w, map[string]any{
+		"Path":   explanation.Path,
+		"Format": explanation.Format,
+		"Hunks":  hunks,
+	})
+}
@@ -0,0 +1,72 @@
+package tools
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestExplainPatch(t *testing.T) {
+	contents := "package main\n\n// main is the entry point\nfunc main() {\n\tfmt.Println(\"hello\")\n}\n"
+	patch := "--- main.go\n+++ main.go\n@@ -3,4 +3,4 @@\n // main is where it all starts\n func main() {\n-\tfmt.Println(\"hello\")\n+\tfmt.Println(\"hi\")\n }\n"
+	explanation, err := ExplainPatch("main.go", contents, patch, nil, DefaultMinConfidence)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(explanation.Hunks) != 1 || explanation.Hunks[0].Status != HunkFuzzy || explanation.Format != PatchFormatUnified {
+		t.Fatalf("unexpected explanation: %+v", explanation.Hunks[0])
+	}
+
+	tests := []struct {
+		format   string
+		expected []string
+	}{
+		{"diff", []string{"fuzzy (80% confidence, at line 4)", "?// main is where it all starts", "    5 -\tfmt.Println(\"hello\")", "      +\tfmt.Println(\"hi\")"}},
+		{"side-by-side", []string{"    4 func main() {", "? // main is where it all starts", "+     fmt.Println(\"hi\")"}},
+		{"html", []string{"<h2 class=\"fuzzy\">", "<tr class=\"context_missing\"", "fmt.Println(&#34;hi&#34;)"}},
+	}
+	for _, tc := range tests {
+		var out bytes.Buffer
+		if err := AlignmentRenderers[tc.format](&out, explanation, RenderOptions{Width: 80}); err != nil {
+			t.Fatal(err)
+		}
+		for _, expected := range tc.expected {
+			if !strings.Contains(out.String(), expected) {
+				t.Errorf("%s: expected %q in:\n%s", tc.format, expected, out.String())
+			}
+		}
+	}
+
+	var out bytes.Buffer
+	if err := RenderAlignmentJSON(&out, explanation, RenderOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	var trace struct {
+		Hunks []struct {
+			Status string
+			Trace  []struct {
+				Op        string
+				InputLine *string `json:"input_line"`
+				PatchLine *string `json:"patch_line"`
+			}
+		}
+	}
+	if err := json.Unmarshal(out.Bytes(), &trace); err != nil {
+		t.Fatal(err)
+	}
+	var ops []string
+	for _, line := range trace.Hunks[0].Trace {
+		ops = append(ops, line.Op)
+	}
+	if got := strings.Join(ops, " "); got != "kept kept kept context_missing context deleted added context" {
+		t.Errorf("unexpected trace: %s", got)
+	}
+	if blank := trace.Hunks[0].Trace[1]; blank.InputLine == nil || *blank.InputLine != "" || blank.PatchLine != nil {
+		t.Errorf("expected the blank kept line to be in the trace, got %+v", blank)
+	}
+
+	if _, err := ExplainPatch("other.go", contents, multiFileDiff, nil, DefaultMinConfidence); err == nil {
+		t.Errorf("expected an error for a file the patch does not change")
+	}
+}
@@ -0,0 +1,251 @@
+package tools
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Decisions a reviewer can make about a hunk
+const (
+	DecisionAccept = "accepted"
+	DecisionReject = "rejected"
+	DecisionEdit   = "edited"
+	DecisionSkip   = "skipped"
+)
+
+// A HunkReview is a reviewer's decision about a hunk.
+type HunkReview struct {
+	// One of DecisionAccept, DecisionReject, DecisionEdit or DecisionSkip
+	Decision string
+
+	// Why the hunk was rejected (passed back to the AI)
+	Reason string
+
+	// The replacement hunk when Decision is DecisionEdit - it is reviewed
+	// again before being applied
+	Hunk *Hunk
+}
+
+// A HunkReviewer decides which hunks of a patch get applied.  It is shown
+// each hunk along with how (and how confidently) it aligned against the file.
+type HunkReviewer interface {
+	ReviewHunk(path string, index int, hunk *Hunk, result *HunkResult) (*HunkReview, error)
+}
+
+type hunkReviewerKey struct{}
+
+// WithHunkReviewer returns a context asking tools that apply patches (see
+// ApplyFileDiff) to have every hunk reviewed before it is written.
+func WithHunkReviewer(ctx context.Context, reviewer HunkReviewer) context.Context {
+	return context.WithValue(ctx, hunkReviewerKey{}, reviewer)
+}
+
+// HunkReviewerFrom returns the reviewer set with WithHunkReviewer (if any).
+func HunkReviewerFrom(ctx context.Context) HunkReviewer {
+	reviewer, _ := ctx.Value(hunkReviewerKey{}).(HunkReviewer)
+	return reviewer
+}
+
+// reviewHunks applies hunks to lines one at a time, asking the reviewer about
+// each.  Only accepted (or edited and then accepted) hunks are applied.
+func reviewHunks(lines []string, path string, hunks []*Hunk, costs *CostModel, minConfidence float64, reviewer HunkReviewer) ([]string, []*HunkResult, error) {
+	var results []*HunkResult
+	for i, hunk := range hunks {
+		edited := false
+		for {
+			patched, result := ApplyHunk(lines, hunk, costs, minConfidence)
+			review, err := reviewer.ReviewHunk(path, i, hunk, result)
+			if err != nil {
+				return nil, nil, err
+			}
+			if review.Decision == DecisionEdit && review.Hunk != nil {
+				hunk, edited = review.Hunk, true
+				continue
+			}
+
+			result.Decision, result.Reason = review.Decision, review.Reason
+			if review.Decision == DecisionAccept && result.Status != HunkFailed {
+				lines = patched
+				if edited {
+					result.Decision = DecisionEdit
+				}
+			} else if review.Decision == DecisionAccept {
+				result.Decision = DecisionSkip
+			}
+			if edited {
+				result.Edited = strings.Join(append([]string{hunk.Header()}, hunk.Lines...), "\n")
+			}
+			results = append(results, result)
+			break
+		}
+	}
+	return lines, results, nil
+}
+
+// TerminalReviewer reviews hunks interactively on a terminal, git add -p
+// style: each hunk is shown with its alignment and can be accepted, rejected
+// (with a reason), edited or skipped.
+type TerminalReviewer struct {
+	In  *bufio.Reader
+	Out io.Writer
+
+	// Whether to color the hunks shown
+	Color bool
+
+	// Opens text in an editor and returns the edited text.  Defaults to
+	// running $VISUAL or $EDITOR (or vi) on a temporary file.
+	Edit func(text string) (string, error)
+
+	// Set once the user quits - remaining hunks are skipped
+	quit bool
+}
+
+// NewTerminalReviewer creates a reviewer prompting on in/out.
+func NewTerminalReviewer(in io.Reader, out io.Writer) *TerminalReviewer {
+	return &TerminalReviewer{In: bufio.NewReader(in), Out: out, Edit: editInEditor}
+}
+
+const reviewHelp = `y - apply this hunk
+n - do not apply this hunk (optionally saying why)
+e - edit this hunk before applying it
+s - skip this hunk (decide later)
+q - quit - skip this and all remaining hunks
+? - print help`
+
+// ReviewHunk shows a hunk and asks what to do with it.
+func (t *TerminalReviewer) ReviewHunk(path string, index int, hunk *Hunk, result *HunkResult) (*HunkReview, error) {
+	if t.quit {
+		return &HunkReview{Decision: DecisionSkip}, nil
+	}
+	fmt.Fprintf(t.Out, "\n%s\n", colorize(path, ansiBold, t.Color))
+	renderHunkDiff(t.Out, index, result, RenderOptions{Color: t.Color})
+
+	choices := "y,n,e,s,q,?"
+	if result.Status == HunkFailed {
+		// Nothing to apply unless it is edited
+		choices = "n,e,s,q,?"
+	}
+	for {
+		fmt.Fprintf(t.Out, "Apply this hunk [%s]? ", choices)
+		answer, err := t.readLine()
+		if err != nil {
+			return nil, err
+		}
+		switch strings.ToLower(answer) {
+		case "y":
+			if result.Status != HunkFailed {
+				return &HunkReview{Decision: DecisionAccept}, nil
+			}
+		case "n":
+			fmt.Fprint(t.Out, "Reason (optional, passed back to the AI): ")
+			reason, err := t.readLine()
+			if err != nil {
+				return nil, err
+			}
+			return &HunkReview{Decision: DecisionReject, Reason: reason}, nil
+		case "e":
+			edited, err := t.editHunk(hunk)
+			if err != nil {
+				fmt.Fprintf(t.Out, "Could not edit the hunk: %v\n", err)
+				continue
+			}
+			if edited == nil {
+				fmt.Fprintln(t.Out, "The edited hunk is empty - keeping the original")
+				continue
+			}
+			return &HunkReview{Decision: DecisionEdit, Hunk: edited}, nil
+		case "s":
+			return &HunkReview{Decision: DecisionSkip}, nil
+		case "q":
+			t.quit = true
+			return &HunkReview{Decision: DecisionSkip}, nil
+		}
+		fmt.Fprintln(t.Out, reviewHelp)
+	}
+}
+
+func (t *TerminalReviewer) readLine() (string, error) {
+	line, err := t.In.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
+
+const editHunkHelp = `# Edit the hunk above.  Lines starting with ' ' are context, '-' lines are
+# removed and '+' lines are added.  To not remove a '-' line make it a context
+# line (' ') and to not add a '+' line delete it.  Lines starting with '#' are
+# ignored.  Save an empty hunk to keep the original.`
+
+// editHunk lets the user edit a hunk in their editor returning nil if the
+// edited hunk is empty.
+func (t *TerminalReviewer) editHunk(hunk *Hunk) (*Hunk, error) {
+	edit := t.Edit
+	if edit == nil {
+		edit = editInEditor
+	}
+	text := strings.Join(append([]string{hunk.Header()}, hunk.Lines...), "\n") + "\n" + editHunkHelp + "\n"
+	edited, err := edit(text)
+	if err != nil {
+		return nil, err
+	}
+	out := &Hunk{Section: hunk.Section}
+	for _, line := range SplitLines(edited) {
+		if strings.HasPrefix(line, "#") || strings.HasPrefix(line, "@@") {
+			continue
+		}
+		if line == "" {
+			line = " "
+		}
+		out.Lines = append(out.Lines, line)
+	}
+	trimHunk(out)
+	if len(out.Lines) == 0 {
+		return nil, nil
+	}
+	out.OldStart, out.NewStart = hunk.OldStart, hunk.NewStart
+	return out, nil
+}
+
+// editInEditor opens text in $VISUAL or $EDITOR (or vi) and returns what was
+// saved.
+func editInEditor(text string) (string, error) {
+	return editFileInEditor("vibrant-hunk-*.diff", text)
+}
+
+// editFileInEditor is editInEditor with the pattern (see os.CreateTemp) of
+// the temporary file edited - its extension helps editors highlight it.
+func editFileInEditor(pattern, text string) (string, error) {
+	file, err := os.CreateTemp("", pattern)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.WriteString(text); err != nil {
+		file.Close()
+		return "", err
+	}
+	file.Close()
+
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+	// The editor may come with arguments, eg "code --wait"
+	args := append(strings.Fields(editor), file.Name())
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("running %s: %w", editor, err)
+	}
+	out, err := os.ReadFile(file.Name())
+	return string(out), err
+}
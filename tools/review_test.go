@@ -0,0 +1,111 @@
+package tools
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const reviewSample = `package main
+
+func one() {
+	return 1
+}
+
+func two() {
+	return 2
+}
+
+func three() {
+	return 3
+}
+`
+
+const reviewDiff = `--- a/main.go
++++ b/main.go
+@@ -3,3 +3,3 @@
+ func one() {
+-	return 1
++	return 10
+ }
+@@ -7,3 +7,3 @@
+ func two() {
+-	return 2
++	return 20
+ }
+@@ -11,3 +11,3 @@
+ func three() {
+-	return 3
++	return 30
+ }
+`
+
+func TestTerminalReviewer(t *testing.T) {
+	root := t.TempDir()
+	full := filepath.Join(root, "main.go")
+	registry := NewRegistry(NewWorkspace(root))
+	review := func(input, diff string, edit func(string) (string, error)) *Envelope {
+		reviewer := NewTerminalReviewer(strings.NewReader(input), io.Discard)
+		reviewer.Edit = edit
+		return registry.Invoke(WithHunkReviewer(t.Context(), reviewer), "apply_file_diff", map[string]any{"diff": diff})
+	}
+	contents := func() string {
+		data, _ := os.ReadFile(full)
+		return string(data)
+	}
+
+	// Accept the first hunk, reject the second (saying why) and edit the third
+	os.WriteFile(full, []byte(reviewSample), 0644)
+	edit := func(text string) (string, error) {
+		if !strings.Contains(text, "+\treturn 30") {
+			t.Errorf("expected the hunk to be edited, got %q", text)
+		}
+		return strings.Replace(text, "return 30", "return 33", 1), nil
+	}
+	env := review("?\ny\nn\ntwo stays\ne\ny\n", reviewDiff, edit)
+	if !env.Ok {
+		t.Fatalf("expected the reviewed diff to apply, got %s", env)
+	}
+	got := contents()
+	if !strings.Contains(got, "return 10") || !strings.Contains(got, "return 2\n") || !strings.Contains(got, "return 33") {
+		t.Errorf("expected only the accepted hunks to be applied, got %q", got)
+	}
+	hunks := env.Result.(*ApplyDiffResult).Files[0].Hunks
+	decisions := []string{hunks[0].Decision, hunks[1].Decision, hunks[2].Decision}
+	if strings.Join(decisions, ",") != "accepted,rejected,edited" || hunks[1].Reason != "two stays" || !strings.Contains(hunks[2].Edited, "+\treturn 33") {
+		t.Errorf("unexpected decisions: %s", env)
+	}
+	if len(env.Warnings) != 1 || !strings.Contains(env.Warnings[0], "two stays") {
+		t.Errorf("expected the rejection to be reported, got %v", env.Warnings)
+	}
+
+	// Quitting skips everything so nothing is written
+	os.WriteFile(full, []byte(reviewSample), 0644)
+	env = review("q\n", reviewDiff, nil)
+	if !env.Ok || contents() != reviewSample || env.Result.(*ApplyDiffResult).Files[0].Action != "unchanged" {
+		t.Errorf("expected nothing to be applied, got %s", env)
+	}
+
+	// Hunks that cannot be found can only be rejected, edited or skipped
+	// and do not fail the others
+	bad := reviewDiff + "@@ -20,3 +20,3 @@\n func four() {\n-\treturn 4\n+\treturn 40\n }\n"
+	env = review("y\ny\ny\ny\ns\n", bad, nil)
+	if !env.Ok || !strings.Contains(contents(), "return 30") {
+		t.Errorf("expected the other hunks to apply, got %s", env)
+	}
+	if hunk := env.Result.(*ApplyDiffResult).Files[0].Hunks[3]; hunk.Status != HunkFailed || hunk.Decision != DecisionSkip {
+		t.Errorf("expected the missing hunk to be skipped, got %+v", hunk)
+	}
+
+	// Deletions are reviewed too
+	env = review("n\n\n", "--- a/main.go\n+++ /dev/null\n@@ -1,1 +0,0 @@\n-package main\n", nil)
+	if _, err := os.Stat(full); err != nil || !env.Ok || env.Result.(*ApplyDiffResult).Files[0].Action != "unchanged" {
+		t.Errorf("expected the rejected deletion to keep the file, got %s", env)
+	}
+	env = review("y\n", "--- a/main.go\n+++ /dev/null\n@@ -1,1 +0,0 @@\n-package main\n", nil)
+	if _, err := os.Stat(full); !os.IsNotExist(err) || !env.Ok {
+		t.Errorf("expected the accepted deletion to remove the file, got %s", env)
+	}
+}
@@ -1,8 +1,10 @@
 package tools
 
 import (
-	"io"
-	"path/filepath"
+	"context"
+	"fmt"
+	"strings"
+	"time"
 )
 
 type RunShellCommand struct {
@@ -15,9 +17,16 @@ func (r *RunShellCommand) Name() string {
 
 func (r *RunShellCommand) Description() string {
 	return `
-	Lists the files in a given folder provided by the 'path' parameter.   The 'recurse' parameter enables recursive file listing.
+	Runs a command in the project and returns its exit code along with its standard output and standard error.
 
-	Returns an error or a list of entries.  Each entry is a file or a folder.   Folder entries could recursively contain other entries.
+	By default the command is split into arguments like a shell would (respecting quotes and backslashes) but run directly, without a shell -
+	so pipes, redirects, '&&', globs and variables are not supported.   Set 'shell' to run the command with the system shell (sh -c) when these are needed.
+
+	The command is killed (along with every process it started) if it runs longer than 'timeout_seconds'.   Output beyond 'max_output_bytes' is
+	truncated from the middle, keeping the start and the end, and the total number of bytes written is reported.
+
+	Commands are checked against the project's command policy first.   A command it denies (or that needs confirmation the user does not give)
+	fails with a 'policy_denied' error naming the rule that matched and why - adjust the command rather than retrying it as is.
 	`
 }
 
@@ -28,11 +37,46 @@ func (r *RunShellCommand) Parameters() []*Parameter {
 			Description: "The command along with all the arguments to run.  Any files should be relative to the working_dir parameter",
 			Type:        "string",
 			Required:    true,
+			MinLength:   Ptr(1),
 		},
 		{
 			Name:        "working_dir",
 			Description: "Directory from which to run the command.  If this is not specified then '.' is assumed and the command will be run from the project's root directory",
+			Type:        "string",
+			Default:     ".",
+		},
+		{
+			Name:        "shell",
+			Description: "Run the command with the system shell so pipes, redirects, globs, variables and '&&' work",
 			Type:        "boolean",
+			Default:     false,
+		},
+		{
+			Name:        "timeout_seconds",
+			Description: "Seconds the command may run before it is killed",
+			Type:        "number",
+			Default:     DefaultCommandTimeout.Seconds(),
+			Minimum:     Ptr(1.0),
+			Maximum:     Ptr(3600.0),
+		},
+		{
+			Name:        "env",
+			Description: "Environment variables to set for the command as 'NAME=value' strings",
+			Type:        "array",
+			Items:       &Parameter{Type: "string", Pattern: `^[A-Za-z_][A-Za-z0-9_]*=`},
+		},
+		{
+			Name:        "stdin",
+			Description: "Text passed to the command's standard input",
+			Type:        "string",
+		},
+		{
+			Name:        "max_output_bytes",
+			Description: "Maximum bytes of stdout (and of stderr) returned - the middle of longer output is dropped",
+			Type:        "integer",
+			Default:     float64(DefaultCommandMaxOutput),
+			Minimum:     Ptr(256.0),
+			Maximum:     Ptr(1048576.0),
 		},
 	}
 }
@@ -40,28 +84,60 @@ func (r *RunShellCommand) Parameters() []*Parameter {
 func (r *RunShellCommand) Returns() []*Parameter {
 	return []*Parameter{
 		{
-			Name:        "output",
-			Description: "Returns all output - including standard output and standard error",
+			Name:        "result",
+			Description: "The command's exit_code, stdout and stderr (and their sizes in bytes) and whether it timed out or its output was truncated",
 			Type:        "object",
 		},
 	}
 }
 
+// Preview shows the command and what the command policy makes of it.
+func (r *RunShellCommand) Preview(ctx context.Context, args map[string]any) (string, error) {
+	return r.PreviewCommand(args)
+}
+
 func (r *RunShellCommand) Run(args map[string]any) (any, error) {
+	return r.RunContext(context.Background(), args)
+}
+
+func (r *RunShellCommand) RunContext(ctx context.Context, args map[string]any) (any, error) {
 	working_dir := "."
-	if args != nil && args["working_dir"] != nil {
-		working_dir = args["working_dir"].(string)
+	if val, ok := args["working_dir"].(string); ok && val != "" {
+		working_dir = val
 	}
-	dir, err := filepath.Abs(filepath.Join(r.ProjectRoot, working_dir))
+	dir, err := r.ResolvePath(working_dir)
 	if err != nil {
 		return nil, err
 	}
-	if args["command"] == "" || args["command"] == nil {
-		return "Please provide a command to execute", nil
+	command, _ := args["command"].(string)
+	if strings.TrimSpace(command) == "" {
+		return nil, fmt.Errorf("please provide a command to execute")
+	}
+
+	opts := CommandOptions{
+		Dir:       dir,
+		Env:       stringsArg(args, "env"),
+		MaxOutput: intArg(args, "max_output_bytes", DefaultCommandMaxOutput),
+		Timeout:   DefaultCommandTimeout,
+	}
+	opts.Shell, _ = args["shell"].(bool)
+	opts.Stdin, _ = args["stdin"].(string)
+	if err := r.CheckCommand(ctx, command, opts.Shell, working_dir, dir); err != nil {
+		return nil, err
+	}
+	if secs, ok := toFloat(args["timeout_seconds"]); ok {
+		opts.Timeout = time.Duration(secs * float64(time.Second))
 	}
-	cmd := args["command"].(string)
 
-	_, _, combined := RunCommand(cmd, dir)
-	output, err := io.ReadAll(combined)
-	return output, err
+	result, err := ExecCommand(ctx, command, opts)
+	if err != nil {
+		return nil, err
+	}
+	if result.TimedOut {
+		Warn(ctx, "the command was killed after running for %s", opts.Timeout)
+	}
+	if result.Truncated {
+		Warn(ctx, "the output was truncated - %d bytes of stdout and %d bytes of stderr were written", result.StdoutBytes, result.StderrBytes)
+	}
+	return result, nil
 }
@@ -1,32 +1,196 @@
 package tools
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"os"
+	"slices"
+	"sort"
+	"strings"
 
 	"golang.design/x/clipboard"
 )
 
-var tools map[string]Tool
+// A Registry holds the set of tools available to an agent session along with
+// the workspace they operate in.
+type Registry struct {
+	Workspace *Workspace
 
-func init() {
-	tools = map[string]Tool{
-		"read_file":         &ReadFile{BaseFileTool{ProjectRoot: "./"}},
-		"list_files":        &ListFiles{BaseFileTool{ProjectRoot: "./"}},
-		"write_file":        &WriteFile{BaseFileTool{ProjectRoot: "./"}},
-		"rename_file":       &RenameFile{BaseFileTool{ProjectRoot: "./"}},
-		"run_shell_command": &RunShellCommand{BaseFileTool{ProjectRoot: "./"}},
-		// "apply_file_diff": &ApplyFileDiff{BaseFileTool{ProjectRoot: "./"}},
+	// Hashes of the files read and written through the registry's tools
+	Files *FileTracker
+
+	// Background processes started by start_process (see Close)
+	Processes *ProcessManager
+
+	// Prior state of the files changed by the registry's tools (so they can
+	// be undone)
+	Journal *Journal
+
+	// Which calls need the user's approval (see ToolApprover).  Every call is
+	// run straight away when nil.
+	Approvals *ApprovalPolicy
+
+	tools map[string]Tool
+}
+
+// NewRegistry creates a registry with all the default tools operating in the
+// given workspace.  Changes made by the tools are journaled in the workspace's
+// JournalDir and the hashes of the files they read and write are kept in its
+// FileTrackerPath (for the session set on Files).
+func NewRegistry(workspace *Workspace) *Registry {
+	if workspace == nil {
+		workspace = NewWorkspace(".")
+	}
+	r := &Registry{Workspace: workspace, Files: OpenFileTracker(workspace.FileTrackerPath(), ""), Processes: NewProcessManager(), tools: map[string]Tool{}}
+	r.Journal = NewJournal(workspace.JournalDir())
+	base := BaseFileTool{Workspace: workspace, Files: r.Files, Journal: r.Journal}
+	r.Register(&ReadFile{base})
+	r.Register(&ListFiles{base})
+	r.Register(&GrepFiles{base})
+	r.Register(&WriteFile{base})
+	r.Register(&EditFile{base})
+	r.Register(&RenameFile{base})
+	r.Register(&RunShellCommand{base})
+	r.Register(&ApplyFileDiff{base})
+	return r
+}
+
+// ProcessToolNames are the tools added by AddProcessTools.
+var ProcessToolNames = []string{"start_process", "read_process_output", "send_process_input", "list_processes", "stop_process"}
+
+// processToolsUnavailable explains why registries without the process tools
+// (tools json, tools run and calls respond) do not offer them.
+const processToolsUnavailable = "only offered by long running sessions ('vibrant tools mcp') - a one shot call would kill the processes it started as soon as it returned"
+
+// AddProcessTools registers the tools running commands in the background
+// (start_process and the tools working with its processes).  Processes only
+// live as long as the registry (see Close) so these are only meant for long
+// lived registries like the MCP server's - in a one shot call (tools run,
+// calls respond) a process would be killed as soon as the call returned.
+// Calling them on other registries fails with an ErrUnknownTool saying so.
+func (r *Registry) AddProcessTools() {
+	base := BaseFileTool{Workspace: r.Workspace, Files: r.Files, Journal: r.Journal}
+	procs := BaseProcessTool{BaseFileTool: base, Processes: r.Processes}
+	r.Register(&StartProcess{procs})
+	r.Register(&ReadProcessOutput{procs})
+	r.Register(&SendProcessInput{procs})
+	r.Register(&ListProcesses{procs})
+	r.Register(&StopProcess{procs})
+}
+
+// Close stops the background processes started by the registry's tools.
+func (r *Registry) Close() {
+	r.Processes.StopAll()
+}
+
+// Register adds (or replaces) a tool in the registry.
+func (r *Registry) Register(tool Tool) {
+	r.tools[tool.Name()] = tool
+}
+
+// Get returns the tool with the given name.
+func (r *Registry) Get(name string) (tool Tool, ok bool) {
+	tool, ok = r.tools[name]
+	return
+}
+
+// Tools returns all registered tools sorted by name.
+func (r *Registry) Tools() (out []Tool) {
+	for _, tool := range r.tools {
+		out = append(out, tool)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name() < out[j].Name() })
+	return
+}
+
+// ErrUnknownTool is returned when calling a tool that is not registered.
+var ErrUnknownTool = errors.New("unknown tool")
+
+// Call runs a tool without a deadline.  See CallContext.
+func (r *Registry) Call(name string, args map[string]any) (result any, err error) {
+	return r.CallContext(context.Background(), name, args)
+}
+
+// CallContext validates the arguments against the tool's parameters (after
+// filling in defaults) and runs the tool.  Arguments that had to be coerced
+// into their declared types are reported as warnings (see WithWarnings).  A *ValidationError listing every
+// violation is returned instead of running the tool with bad arguments and a
+// panic in a tool is returned as an error instead of taking the caller down.
+// Calls the registry's ApprovalPolicy does not allow fail with an
+// *ApprovalError.  Tools implementing ContextTool are stopped when ctx is cancelled.
+func (r *Registry) CallContext(ctx context.Context, name string, args map[string]any) (result any, err error) {
+	tool, ok := r.Get(name)
+	if !ok {
+		if slices.Contains(ProcessToolNames, name) {
+			return nil, fmt.Errorf("%w: %s is %s", ErrUnknownTool, name, processToolsUnavailable)
+		}
+		return nil, fmt.Errorf("%w: %s", ErrUnknownTool, name)
 	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if args == nil {
+		args = map[string]any{}
+	}
+
+	params := tool.Parameters()
+	for _, coercion := range CoerceArgs(params, args) {
+		Warn(ctx, "argument %s", coercion)
+	}
+	ApplyDefaults(params, args)
+	if violations := ValidateArgs(params, args); len(violations) > 0 {
+		return nil, &ValidationError{Tool: name, Violations: violations}
+	}
+
+	defer func() {
+		if rec := recover(); rec != nil {
+			result, err = nil, fmt.Errorf("%w: %s: %v", ErrToolPanicked, name, rec)
+		}
+	}()
+	if r.Approvals != nil {
+		if args, ctx, err = r.approveCall(ctx, tool, args); err != nil {
+			return nil, err
+		}
+	}
+	if ctxTool, ok := tool.(ContextTool); ok {
+		return ctxTool.RunContext(ctx, args)
+	}
+	return tool.Run(args)
+}
 
-	err := clipboard.Init()
+// ResultString converts a tool result into the text handed back to the AI.
+// Strings are returned as is and everything else is JSON encoded.
+func ResultString(result any) string {
+	switch val := result.(type) {
+	case string:
+		return val
+	case []byte:
+		return string(val)
+	}
+	out, err := json.MarshalIndent(result, "", "  ")
 	if err != nil {
-		panic(err)
+		return fmt.Sprintf("%v", result)
 	}
+	return string(out)
+}
+
+// RunTool runs a tool and prints its result envelope (which is also copied to
+// the clipboard).  If params is nil the tool call json is read from stdin or
+// the clipboard.
+func (r *Registry) RunTool(fromClipboard bool, name string, params map[string]any) (env *Envelope, err error) {
+	return r.RunToolContext(context.Background(), fromClipboard, name, params)
 }
 
-func RunTool(fromClipboard bool, name string, params map[string]any) (result any, err error) {
+// RunToolContext is RunTool with a context (eg one with a HunkReviewer).
+// Commands the command policy wants confirmed are confirmed on the terminal
+// unless ctx has its own CommandConfirmer.
+func (r *Registry) RunToolContext(ctx context.Context, fromClipboard bool, name string, params map[string]any) (env *Envelope, err error) {
+	if CommandConfirmerFrom(ctx) == nil {
+		ctx = WithCommandConfirmer(ctx, NewTerminalConfirmer(os.Stdin, os.Stdout))
+	}
 	if params == nil {
 		var input string
 		input, err = GetInputFromUserOrClipboard(fromClipboard, "")
@@ -40,55 +204,41 @@ func RunTool(fromClipboard bool, name string, params map[string]any) (result any
 		}
 	}
 
-	// log.Println("Got Input: ", input)
-
-	// log.Println("Params: ", params)
-	result, err = tools[name].Run(params)
-	if err != nil {
-		log.Printf("error: %v", err)
-	} else {
+	env = r.Invoke(ctx, name, params)
+	val := env.String()
+	if env.Ok {
 		fmt.Println("\nTOOL CALLED SUCCESSFULLY.  Result: ")
-		fmt.Println(result)
-		if val, ok := result.(string); ok {
-			clipboard.Write(clipboard.FmtText, []byte(val))
-		}
+	} else {
+		fmt.Println("\nTOOL CALL FAILED.  Result: ")
+	}
+	fmt.Println(val)
+	if InitClipboard() == nil {
+		clipboard.Write(clipboard.FmtText, []byte(val))
 	}
 	return
 }
 
-func ToolsJson() {
-	var out []any
-	for _, tool := range tools {
-		props := map[string]any{}
-		var required []string
-		for _, param := range tool.Parameters() {
-			props[param.Name] = param.Json()
-			if param.Required {
-				required = append(required, param.Name)
-			}
-		}
-		tj := map[string]any{
-			"name":        tool.Name(),
-			"description": tool.Description(),
-			"parameters":  map[string]any{"type": "object", "properties": props},
-		}
-		if required != nil {
-			tj["required"] = required
-		}
-		out = append(out, tj)
+// ToolsJson prints all the tools in the given format (see ExportFormats).
+func (r *Registry) ToolsJson(format string) error {
+	out, err := ExportTools(r.Tools(), format)
+	if err != nil {
+		return err
 	}
-
-	b, err := json.Marshal(out)
+	b, err := json.MarshalIndent(out, "", "  ")
 	if err != nil {
-		panic(err)
+		return err
 	}
 	fmt.Println(string(b))
+	if _, ok := r.Get(ProcessToolNames[0]); !ok {
+		fmt.Fprintf(os.Stderr, "Note: %s are %s\n", strings.Join(ProcessToolNames, ", "), processToolsUnavailable)
+	}
+	return nil
 }
 
-func PrintTools() {
+func (r *Registry) PrintTools() {
 	fmt.Println("Tools:")
-	for name, tool := range tools {
-		fmt.Printf("	%s: \n", name)
+	for _, tool := range r.Tools() {
+		fmt.Printf("	%s: \n", tool.Name())
 		fmt.Println(tool.Description())
 		fmt.Println("	Parameters:")
 		for _, param := range tool.Parameters() {
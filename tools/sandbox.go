@@ -0,0 +1,238 @@
+package tools
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Errors returned (wrapped in a SandboxError) when a path cannot be used by a tool.
+var (
+	ErrPathEscapesRoot = errors.New("path escapes the project root")
+	ErrPathDenied      = errors.New("path matches a deny pattern")
+	ErrPathNotAllowed  = errors.New("path does not match any allow pattern")
+)
+
+// Patterns that are denied when a Sandbox does not specify its own Deny list.
+// These protect VCS internals and the most common kinds of secrets.
+var DefaultDenyPatterns = []string{
+	".git/**",
+	".vibrant/journal/**",
+	".vibrant/state/**",
+	".env*",
+	"*.pem",
+	"*.key",
+	"id_rsa*",
+	"id_ed25519*",
+}
+
+// Patterns that are always denied, on top of whatever Deny is set to.  They
+// keep tools from changing vibrant's own config, journal and state (and so
+// from granting themselves more permissions).
+var ProtectedPatterns = []string{
+	".vibrant/**",
+}
+
+// A SandboxError describes why a path was rejected by a Sandbox.
+type SandboxError struct {
+	// The path as it was passed in by the caller (usually the AI)
+	Path string
+
+	// The glob pattern responsible for the rejection (if any)
+	Rule string
+
+	// One of ErrPathEscapesRoot, ErrPathDenied or ErrPathNotAllowed
+	Err error
+}
+
+func (e *SandboxError) Error() string {
+	if e.Rule != "" {
+		return fmt.Sprintf("%s: %v (%s)", e.Path, e.Err, e.Rule)
+	}
+	return fmt.Sprintf("%s: %v", e.Path, e.Err)
+}
+
+func (e *SandboxError) Unwrap() error {
+	return e.Err
+}
+
+// A Sandbox confines paths to a root directory.  All paths are resolved
+// relative to the root (after following symlinks) and rejected if they end up
+// outside it or match the configured deny globs.
+type Sandbox struct {
+	Root string
+
+	// Glob patterns (relative to Root) of files that may be accessed.  An empty
+	// list allows everything that is not denied.  Directories are always
+	// traversable so that allowed files below them can be reached.
+	Allow []string
+
+	// Glob patterns (relative to Root) of paths that may never be accessed.
+	// Deny always wins over Allow.  If nil, DefaultDenyPatterns is used - set it
+	// to an empty (non nil) slice to disable denials.  ProtectedPatterns are
+	// denied regardless.
+	Deny []string
+
+	// Absolute paths of files that may never be accessed whatever the patterns
+	// say, eg the config file when it is not under .vibrant.
+	Protected []string
+}
+
+// RealRoot returns the absolute, symlink free path of the sandbox root.
+func (s *Sandbox) RealRoot() (string, error) {
+	root := s.Root
+	if root == "" {
+		root = "."
+	}
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(root)
+}
+
+// Resolve turns a caller supplied path (relative to the root or absolute) into
+// an absolute path with all symlinks resolved, ensuring the result is inside
+// the root and permitted by the allow/deny patterns.  The path does not have
+// to exist (so it can be used for creating files) but any existing prefix of
+// it is resolved.
+func (s *Sandbox) Resolve(path string) (fullpath string, err error) {
+	root, err := s.RealRoot()
+	if err != nil {
+		return "", err
+	}
+
+	lexical := filepath.Clean(path)
+	if !filepath.IsAbs(lexical) {
+		lexical = filepath.Join(root, lexical)
+	}
+
+	fullpath, err = resolveExisting(lexical, 0)
+	if err != nil {
+		return "", err
+	}
+
+	rel, ok := relativeTo(root, fullpath)
+	if !ok {
+		return "", &SandboxError{Path: path, Err: ErrPathEscapesRoot}
+	}
+
+	// A symlink with an innocuous name could point at a denied file and a
+	// denied name could point at an innocuous file - check both.
+	if lexrel, ok := relativeTo(root, lexical); ok && lexrel != rel {
+		if err := s.Check(path, lexrel); err != nil {
+			return "", err
+		}
+	}
+	if err := s.Check(path, rel); err != nil {
+		return "", err
+	}
+	return fullpath, nil
+}
+
+// Rel returns the slash separated path of fullpath relative to the sandbox
+// root.  fullpath is expected to have been returned by Resolve.
+func (s *Sandbox) Rel(fullpath string) (string, error) {
+	root, err := s.RealRoot()
+	if err != nil {
+		return "", err
+	}
+	rel, ok := relativeTo(root, fullpath)
+	if !ok {
+		return "", &SandboxError{Path: fullpath, Err: ErrPathEscapesRoot}
+	}
+	return rel, nil
+}
+
+// Check applies the allow and deny patterns to a slash separated path relative
+// to the root.  path is only used for reporting.
+func (s *Sandbox) Check(path, rel string) error {
+	if rel == "." || rel == "" {
+		return nil
+	}
+	deny := s.Deny
+	if deny == nil {
+		deny = DefaultDenyPatterns
+	}
+	if rule, ok := MatchAnyGlob(deny, rel); ok {
+		return &SandboxError{Path: path, Rule: rule, Err: ErrPathDenied}
+	}
+	if rule, ok := MatchAnyGlob(ProtectedPatterns, rel); ok {
+		return &SandboxError{Path: path, Rule: rule, Err: ErrPathDenied}
+	}
+	if len(s.Protected) > 0 {
+		if root, err := s.RealRoot(); err == nil {
+			for _, protected := range s.Protected {
+				if prel, ok := relativeTo(root, protected); ok && prel == rel {
+					return &SandboxError{Path: path, Rule: protected, Err: ErrPathDenied}
+				}
+			}
+		}
+	}
+	if len(s.Allow) > 0 {
+		if _, ok := MatchAnyGlob(s.Allow, rel); !ok && !s.isDir(rel) {
+			return &SandboxError{Path: path, Err: ErrPathNotAllowed}
+		}
+	}
+	return nil
+}
+
+func (s *Sandbox) isDir(rel string) bool {
+	root, err := s.RealRoot()
+	if err != nil {
+		return false
+	}
+	info, err := os.Stat(filepath.Join(root, filepath.FromSlash(rel)))
+	return err == nil && info.IsDir()
+}
+
+// relativeTo returns the slash separated path of target relative to root and
+// whether target is actually inside root.
+func relativeTo(root, target string) (string, bool) {
+	rel, err := filepath.Rel(root, target)
+	if err != nil || filepath.IsAbs(rel) || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
+
+// Symlink chains longer than this are treated as loops.
+const maxSymlinkDepth = 32
+
+// resolveExisting resolves symlinks in the longest existing prefix of an
+// absolute path and re-attaches the non existent remainder.  Dangling links
+// are followed manually so a write through them cannot land outside the root.
+func resolveExisting(abspath string, depth int) (string, error) {
+	if depth > maxSymlinkDepth {
+		return "", fmt.Errorf("%s: too many levels of symbolic links", abspath)
+	}
+
+	existing := abspath
+	var rest []string
+	for {
+		if _, err := os.Lstat(existing); err == nil {
+			break
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			break
+		}
+		rest = append([]string{filepath.Base(existing)}, rest...)
+		existing = parent
+	}
+
+	resolved, err := filepath.EvalSymlinks(existing)
+	if err != nil {
+		target, lerr := os.Readlink(existing)
+		if lerr != nil {
+			return "", err
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(existing), target)
+		}
+		return resolveExisting(filepath.Join(append([]string{target}, rest...)...), depth+1)
+	}
+	return filepath.Join(append([]string{resolved}, rest...)...), nil
+}
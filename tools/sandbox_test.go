@@ -0,0 +1,144 @@
+package tools
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMatchGlob(t *testing.T) {
+	cases := []struct {
+		pattern, path string
+		want          bool
+	}{
+		{".git/**", ".git", true},
+		{".git/**", ".git/config", true},
+		{".git/**", ".github/workflows/ci.yml", false},
+		{".env*", ".env", true},
+		{".env*", "deploy/.env.prod", true},
+		{"*.pem", "certs/server.pem", true},
+		{"*.pem", "certs/server.pem.txt", false},
+		{"src/**/*.go", "src/main.go", true},
+		{"src/**/*.go", "src/a/b/main.go", true},
+		{"src/**/*.go", "lib/main.go", false},
+		{"./docs/*.md", "docs/README.md", true},
+	}
+	for _, c := range cases {
+		if got := MatchGlob(c.pattern, c.path); got != c.want {
+			t.Errorf("MatchGlob(%q, %q) = %v, want %v", c.pattern, c.path, got, c.want)
+		}
+	}
+}
+
+func TestSandbox_Resolve(t *testing.T) {
+	outside := t.TempDir()
+	root := t.TempDir()
+	mustWrite := func(path string) {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mustWrite(filepath.Join(outside, "secret.txt"))
+	mustWrite(filepath.Join(root, "src", "main.go"))
+	mustWrite(filepath.Join(root, ".git", "config"))
+	if err := os.Symlink(outside, filepath.Join(root, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(outside, "new.txt"), filepath.Join(root, "dangling")); err != nil {
+		t.Fatal(err)
+	}
+
+	sandbox := &Sandbox{Root: root}
+	cases := []struct {
+		path    string
+		wantErr error
+	}{
+		{"src/main.go", nil},
+		{"./src/../src/new.go", nil},
+		{filepath.Join(root, "src", "main.go"), nil},
+		{"../outside.txt", ErrPathEscapesRoot},
+		{"src/../../outside.txt", ErrPathEscapesRoot},
+		{filepath.Join(outside, "secret.txt"), ErrPathEscapesRoot},
+		{"escape/secret.txt", ErrPathEscapesRoot},
+		{"escape/not/yet/created.txt", ErrPathEscapesRoot},
+		{"dangling", ErrPathEscapesRoot},
+		{".git/config", ErrPathDenied},
+		{".env.local", ErrPathDenied},
+		{"certs/server.pem", ErrPathDenied},
+	}
+	for _, c := range cases {
+		_, err := sandbox.Resolve(c.path)
+		if c.wantErr == nil && err != nil {
+			t.Errorf("Resolve(%q): unexpected error: %v", c.path, err)
+		} else if c.wantErr != nil && !errors.Is(err, c.wantErr) {
+			t.Errorf("Resolve(%q): expected %v, got %v", c.path, c.wantErr, err)
+		}
+	}
+
+	restricted := &Sandbox{Root: root, Allow: []string{"src/**"}}
+	if _, err := restricted.Resolve("src/main.go"); err != nil {
+		t.Errorf("expected src/main.go to be allowed: %v", err)
+	}
+	if _, err := restricted.Resolve("README.md"); !errors.Is(err, ErrPathNotAllowed) {
+		t.Errorf("expected README.md to be rejected, got %v", err)
+	}
+	var serr *SandboxError
+	if _, err := sandbox.Resolve(".git/config"); !errors.As(err, &serr) || serr.Rule != ".git/**" {
+		t.Errorf("expected a SandboxError naming the .git/** rule, got %v", err)
+	}
+}
+
+func TestWorkspace_NamedRoots(t *testing.T) {
+	main, backend := t.TempDir(), t.TempDir()
+	config := &Config{Root: main}
+	config.SetRoots([]string{"backend=" + backend})
+	ws, err := config.NewWorkspace()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	realBackend, _ := filepath.EvalSymlinks(backend)
+	if got, err := ws.Resolve("backend:go.mod"); err != nil || got != filepath.Join(realBackend, "go.mod") {
+		t.Errorf("expected backend:go.mod to resolve into %s, got %s (%v)", realBackend, got, err)
+	}
+	realMain, _ := filepath.EvalSymlinks(main)
+	if got, err := ws.Resolve("unknown:go.mod"); err != nil || got != filepath.Join(realMain, "unknown:go.mod") {
+		t.Errorf("expected unknown root prefixes to be treated as plain paths, got %s (%v)", got, err)
+	}
+	if _, err := ws.Resolve("backend:../escape.txt"); !errors.Is(err, ErrPathEscapesRoot) {
+		t.Errorf("expected escaping a named root to fail, got %v", err)
+	}
+}
+
+func TestConfig_ProtectsItself(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, ".vibrant"), 0755)
+	os.WriteFile(filepath.Join(root, ".vibrant", "config.json"), []byte(`{"deny": ["secrets/**"]}`), 0644)
+	os.WriteFile(filepath.Join(root, "tools.json"), []byte(`{"deny": ["secrets/**"]}`), 0644)
+
+	for _, path := range []string{".vibrant/config.json", "tools.json"} {
+		config, err := LoadConfig(filepath.Join(root, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		config.Root = root
+		ws, err := config.NewWorkspace()
+		if err != nil {
+			t.Fatal(err)
+		}
+		registry := NewRegistry(ws)
+		env := registry.Invoke(t.Context(), "write_file", map[string]any{
+			"path": path, "contents": `{"approvals": {"*": "auto"}}`, "encoding": "plain",
+		})
+		if env.Ok || env.Error.Code != ErrCodeSandbox {
+			t.Errorf("expected writing %s to be denied with a custom deny list, got %s", path, env)
+		}
+		if _, err := ws.Resolve("secrets/key.txt"); !errors.Is(err, ErrPathDenied) {
+			t.Errorf("expected the custom deny list to apply, got %v", err)
+		}
+	}
+}
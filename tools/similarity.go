@@ -0,0 +1,142 @@
+package tools
+
+import (
+	"slices"
+	"strings"
+)
+
+// LineSimilarity scores how alike two lines are from 0 (nothing in common)
+// to 1 (identical).  Lines that only differ in leading/trailing whitespace
+// score just under 1 and everything else is scored by edit distance.
+func LineSimilarity(a, b string) float64 {
+	if a == b {
+		return 1
+	}
+	ta, tb := strings.TrimSpace(a), strings.TrimSpace(b)
+	if ta == tb {
+		return 0.95
+	}
+	return 0.9 * LevenshteinRatio(ta, tb)
+}
+
+// LevenshteinRatio returns 1 - (edit distance / length of the longer string)
+// comparing runes.
+func LevenshteinRatio(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	longest := max(len(ra), len(rb))
+	if longest == 0 {
+		return 1
+	}
+	return 1 - float64(levenshtein(ra, rb))/float64(longest)
+}
+
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+// TokenJaccard returns the Jaccard index (size of the intersection over the
+// size of the union) of the sets of whitespace separated tokens of a and b.
+func TokenJaccard(a, b string) float64 {
+	ta, tb := strings.Fields(a), strings.Fields(b)
+	if len(ta) == 0 && len(tb) == 0 {
+		return 1
+	}
+	set := map[string]int{}
+	for _, token := range ta {
+		set[token] |= 1
+	}
+	for _, token := range tb {
+		set[token] |= 2
+	}
+	common := 0
+	for _, in := range set {
+		if in == 3 {
+			common++
+		}
+	}
+	return float64(common) / float64(len(set))
+}
+
+// fuzzyCandidates returns (upto limit of) the starts of the blocks of lines
+// most likely to match needle, best first (closest to hint on ties).  Every trigram a line of the file
+// shares with a line of needle votes for the block lining the two lines up
+// so this is linear in the size of the file.  Trigrams common to many lines
+// of needle (eg of keywords) do not vote.  Blocks without any votes are never
+// returned.
+func fuzzyCandidates(lines, needle []string, hint, limit int) []int {
+	if len(needle) == 0 || len(needle) > len(lines) {
+		return nil
+	}
+	index := map[uint32][]int{}
+	for i, line := range needle {
+		for _, t := range lineTrigrams(line) {
+			if at := index[t]; len(at) == 0 || at[len(at)-1] != i {
+				index[t] = append(at, i)
+			}
+		}
+	}
+	common := max(4, len(needle)/8)
+	votes := make([]int, len(lines)-len(needle)+1)
+	for j, line := range lines {
+		for _, t := range lineTrigrams(line) {
+			if at := index[t]; len(at) <= common {
+				for _, i := range at {
+					if start := j - i; start >= 0 && start < len(votes) {
+						votes[start]++
+					}
+				}
+			}
+		}
+	}
+
+	var starts []int
+	for start, count := range votes {
+		if count > 0 {
+			starts = append(starts, start)
+		}
+	}
+	slices.SortFunc(starts, func(a, b int) int {
+		if votes[a] != votes[b] {
+			return votes[b] - votes[a]
+		}
+		return abs(a-hint) - abs(b-hint)
+	})
+	return starts[:min(limit, len(starts))]
+}
+
+// lineTrigrams returns the (byte) trigrams of a line ignoring surrounding
+// whitespace.  Lines shorter than 3 bytes are a trigram of their own.
+func lineTrigrams(line string) []uint32 {
+	line = strings.TrimSpace(line)
+	if len(line) < 3 {
+		if line == "" {
+			return nil
+		}
+		key := uint32(1) << 24
+		for k := range len(line) {
+			key |= uint32(line[k]) << (8 * k)
+		}
+		return []uint32{key}
+	}
+	out := make([]uint32, 0, len(line)-2)
+	for k := 0; k+3 <= len(line); k++ {
+		out = append(out, uint32(line[k])<<16|uint32(line[k+1])<<8|uint32(line[k+2]))
+	}
+	return out
+}
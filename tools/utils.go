@@ -2,12 +2,12 @@ package tools
 
 import (
 	"bufio"
-	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"os"
-	"os/exec"
 	"path"
+	"path/filepath"
 	"strings"
 	"sync"
 
@@ -23,7 +23,7 @@ func createNewFile(filePath, content string) (string, error) {
 		}
 	}
 
-	err := os.WriteFile(filePath, []byte(content), 0644)
+	err := WriteFileAtomic(filePath, []byte(content), 0644)
 	if err != nil {
 		return "", fmt.Errorf("failed to create file: %w", err)
 	}
@@ -31,6 +31,90 @@ func createNewFile(filePath, content string) (string, error) {
 	return fmt.Sprintf("Successfully created file %s", filePath), nil
 }
 
+// WriteFileAtomic writes data to a temporary file in the same folder and
+// renames it over filePath so readers (and crashes) never see a partially
+// written file.  An existing file keeps its permissions, otherwise perm is
+// used.  Symlinks are written through rather than replaced.
+func WriteFileAtomic(filePath string, data []byte, perm os.FileMode) (err error) {
+	if target, err := filepath.EvalSymlinks(filePath); err == nil {
+		filePath = target
+	}
+	if info, err := os.Stat(filePath); err == nil {
+		perm = info.Mode().Perm()
+	}
+
+	tmp, err := writeTempFile(filePath, data, perm)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filePath); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// CreateFileAtomic is WriteFileAtomic for a file that must not exist yet.
+// Rather than replace a file created in the meantime it fails with an error
+// wrapping os.ErrExist.  The temporary file is hard linked into place so the
+// check and the write are one step.
+func CreateFileAtomic(filePath string, data []byte, perm os.FileMode) error {
+	tmp, err := writeTempFile(filePath, data, perm)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	err = os.Link(tmp, filePath)
+	if err == nil || errors.Is(err, os.ErrExist) {
+		return err
+	}
+
+	// Not every file system has hard links - an exclusive create at least
+	// never replaces a file (though it may be seen partially written)
+	file, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(data); err == nil {
+		err = file.Sync()
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(filePath)
+	}
+	return err
+}
+
+// writeTempFile writes data to a new temporary file (with permissions perm)
+// in the folder of filePath returning its path.
+func writeTempFile(filePath string, data []byte, perm os.FileMode) (name string, err error) {
+	tmp, err := os.CreateTemp(filepath.Dir(filePath), "."+filepath.Base(filePath)+".tmp*")
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+	if _, err = tmp.Write(data); err != nil {
+		return "", err
+	}
+	if err = tmp.Sync(); err != nil {
+		return "", err
+	}
+	if err = tmp.Chmod(perm); err != nil {
+		return "", err
+	}
+	if err = tmp.Close(); err != nil {
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
 func getUserMessageTillEOF() (string, error) {
 	// Create a new reader from stdin
 	reader := bufio.NewReader(os.Stdin)
@@ -53,8 +137,26 @@ func getUserMessageTillEOF() (string, error) {
 	}
 }
 
+var (
+	clipboardOnce sync.Once
+	clipboardErr  error
+)
+
+// InitClipboard initializes the system clipboard on first use.  This is done
+// lazily so that commands (and tests) that never touch the clipboard work on
+// machines without one (eg headless servers).
+func InitClipboard() error {
+	clipboardOnce.Do(func() {
+		clipboardErr = clipboard.Init()
+	})
+	return clipboardErr
+}
+
 func GetInputFromUserOrClipboard(fromClipboard bool, prompt string) (input string, err error) {
 	if fromClipboard {
+		if err = InitClipboard(); err != nil {
+			return
+		}
 		input = string(clipboard.Read(clipboard.FmtText))
 	} else {
 		if prompt == "" {
@@ -65,49 +167,3 @@ func GetInputFromUserOrClipboard(fromClipboard bool, prompt string) (input strin
 	}
 	return
 }
-
-func RunCommand(fullCommand, workingDir string) (stdout io.Reader, stderr io.Reader, stdall io.Reader) {
-	// Parse the command - split on spaces (basic parsing)
-	parts := strings.Fields(fullCommand)
-	if len(parts) == 0 {
-		// Return empty readers for invalid command
-		return strings.NewReader(""), strings.NewReader(""), strings.NewReader("")
-	}
-
-	cmd := exec.Command(parts[0], parts[1:]...)
-	cmd.Dir = workingDir
-
-	// Buffers to capture output
-	var stdoutBuf, stderrBuf, combinedBuf bytes.Buffer
-
-	// Create writers that write to both individual buffers and combined buffer
-	stdoutWriter := io.MultiWriter(&stdoutBuf, &combinedBuf)
-	stderrWriter := io.MultiWriter(&stderrBuf, &combinedBuf)
-
-	// Use a mutex to ensure combined output is written in correct order
-	var mu sync.Mutex
-
-	// Wrap the writers with mutex protection for the combined buffer
-	cmd.Stdout = &synchronizedWriter{writer: stdoutWriter, mu: &mu}
-	cmd.Stderr = &synchronizedWriter{writer: stderrWriter, mu: &mu}
-
-	// Run the command
-	cmd.Run() // Ignoring error since we're capturing stderr anyway
-
-	// Return readers for the captured output
-	return strings.NewReader(stdoutBuf.String()),
-		strings.NewReader(stderrBuf.String()),
-		strings.NewReader(combinedBuf.String())
-}
-
-// synchronizedWriter wraps an io.Writer with mutex protection
-type synchronizedWriter struct {
-	writer io.Writer
-	mu     *sync.Mutex
-}
-
-func (sw *synchronizedWriter) Write(p []byte) (n int, err error) {
-	sw.mu.Lock()
-	defer sw.mu.Unlock()
-	return sw.writer.Write(p)
-}
@@ -0,0 +1,226 @@
+package tools
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// A Violation is a single way in which a tool's arguments do not match its
+// parameter schema.
+type Violation struct {
+	// Path of the offending value, eg "edits[2].old_text"
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// A ValidationError is returned when a tool is called with arguments that do
+// not match its parameters.  It lists every violation (not just the first) so
+// the caller (usually an AI) can fix them all in one go.
+type ValidationError struct {
+	Tool       string      `json:"tool"`
+	Violations []Violation `json:"violations"`
+}
+
+func (e *ValidationError) Error() string {
+	var msgs []string
+	for _, v := range e.Violations {
+		msgs = append(msgs, fmt.Sprintf("%s: %s", v.Path, v.Message))
+	}
+	return fmt.Sprintf("invalid arguments for %s: %s", e.Tool, strings.Join(msgs, "; "))
+}
+
+// ApplyDefaults sets every missing parameter that has a Default value.
+func ApplyDefaults(params []*Parameter, args map[string]any) {
+	for _, param := range params {
+		if _, ok := args[param.Name]; !ok && param.Default != nil {
+			args[param.Name] = param.Default
+		}
+	}
+}
+
+// ValidateArgs checks args against the parameter schema and returns all the
+// violations found.  Arguments that are not declared are ignored.
+func ValidateArgs(params []*Parameter, args map[string]any) (violations []Violation) {
+	for _, param := range params {
+		value, ok := args[param.Name]
+		if !ok || value == nil {
+			if param.Required {
+				violations = append(violations, Violation{param.Name, "is required"})
+			}
+			continue
+		}
+		violations = append(violations, validateValue(param, param.Name, value)...)
+	}
+	return
+}
+
+func validateValue(param *Parameter, path string, value any) (violations []Violation) {
+	fail := func(format string, args ...any) []Violation {
+		return append(violations, Violation{path, fmt.Sprintf(format, args...)})
+	}
+
+	if !matchesType(param.Type, value) {
+		return fail("expected %s, got %s", param.Type, jsonTypeOf(value))
+	}
+
+	if len(param.Enum) > 0 {
+		found := false
+		for _, allowed := range param.Enum {
+			if reflect.DeepEqual(normalizeNumber(allowed), normalizeNumber(value)) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			violations = fail("must be one of %v", param.Enum)
+		}
+	}
+
+	switch param.Type {
+	case "integer", "number":
+		num, _ := toFloat(value)
+		if param.Minimum != nil && num < *param.Minimum {
+			violations = fail("must be >= %v", *param.Minimum)
+		}
+		if param.Maximum != nil && num > *param.Maximum {
+			violations = fail("must be <= %v", *param.Maximum)
+		}
+	case "string":
+		str := value.(string)
+		length := utf8.RuneCountInString(str)
+		if param.MinLength != nil && length < *param.MinLength {
+			violations = fail("must be at least %d characters long", *param.MinLength)
+		}
+		if param.MaxLength != nil && length > *param.MaxLength {
+			violations = fail("must be at most %d characters long", *param.MaxLength)
+		}
+		if param.Pattern != "" {
+			if re, err := regexp.Compile(param.Pattern); err != nil {
+				violations = fail("has an invalid pattern in its schema: %v", err)
+			} else if !re.MatchString(str) {
+				violations = fail("must match the pattern %s", param.Pattern)
+			}
+		}
+	case "array":
+		items := reflect.ValueOf(value)
+		if param.MinLength != nil && items.Len() < *param.MinLength {
+			violations = fail("must have at least %d items", *param.MinLength)
+		}
+		if param.MaxLength != nil && items.Len() > *param.MaxLength {
+			violations = fail("must have at most %d items", *param.MaxLength)
+		}
+		if param.Items != nil {
+			for i := 0; i < items.Len(); i++ {
+				violations = append(violations, validateValue(param.Items, fmt.Sprintf("%s[%d]", path, i), items.Index(i).Interface())...)
+			}
+		}
+	case "object":
+		obj := value.(map[string]any)
+		for _, child := range param.Properties {
+			childPath := path + "." + child.Name
+			childValue, ok := obj[child.Name]
+			if !ok || childValue == nil {
+				if child.Required {
+					violations = append(violations, Violation{childPath, "is required"})
+				}
+				continue
+			}
+			violations = append(violations, validateValue(child, childPath, childValue)...)
+		}
+	}
+	return
+}
+
+func matchesType(paramType string, value any) bool {
+	switch paramType {
+	case "", "any":
+		return true
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "number":
+		_, ok := toFloat(value)
+		return ok
+	case "integer":
+		num, ok := toFloat(value)
+		return ok && num == math.Trunc(num)
+	case "array":
+		if value == nil {
+			return false
+		}
+		kind := reflect.TypeOf(value).Kind()
+		return kind == reflect.Slice || kind == reflect.Array
+	case "object":
+		_, ok := value.(map[string]any)
+		return ok
+	}
+	return false
+}
+
+func jsonTypeOf(value any) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case map[string]any:
+		return "object"
+	}
+	if num, ok := toFloat(value); ok {
+		if num == math.Trunc(num) {
+			return "integer"
+		}
+		return "number"
+	}
+	if kind := reflect.TypeOf(value).Kind(); kind == reflect.Slice || kind == reflect.Array {
+		return "array"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// toFloat converts any Go numeric value to a float64.
+func toFloat(value any) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int8:
+		return float64(v), true
+	case int16:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	case uint8:
+		return float64(v), true
+	case uint16:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+func normalizeNumber(value any) any {
+	if num, ok := toFloat(value); ok {
+		return num
+	}
+	return value
+}
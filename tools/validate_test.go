@@ -0,0 +1,145 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateArgs(t *testing.T) {
+	params := []*Parameter{
+		{Name: "path", Type: "string", Required: true, Pattern: `^[^/]`},
+		{Name: "mode", Type: "string", Enum: []any{"create_only", "overwrite"}},
+		{Name: "limit", Type: "integer", Minimum: Ptr(1.0), Maximum: Ptr(100.0)},
+		{Name: "edits", Type: "array", MinLength: Ptr(1), Items: &Parameter{
+			Type: "object",
+			Properties: []*Parameter{
+				{Name: "old_text", Type: "string", Required: true},
+				{Name: "replace_all", Type: "boolean"},
+			},
+		}},
+	}
+
+	valid := map[string]any{
+		"path":  "src/main.go",
+		"mode":  "overwrite",
+		"limit": float64(10),
+		"edits": []any{map[string]any{"old_text": "a", "replace_all": true}},
+	}
+	if violations := ValidateArgs(params, valid); len(violations) != 0 {
+		t.Errorf("expected no violations, got %v", violations)
+	}
+
+	invalid := map[string]any{
+		"mode":  "append",
+		"limit": 2.5,
+		"edits": []any{map[string]any{"replace_all": "yes"}},
+	}
+	expected := []Violation{
+		{"path", "is required"},
+		{"mode", "must be one of [create_only overwrite]"},
+		{"limit", "expected integer, got number"},
+		{"edits[0].old_text", "is required"},
+		{"edits[0].replace_all", "expected boolean, got string"},
+	}
+	if violations := ValidateArgs(params, invalid); !reflect.DeepEqual(violations, expected) {
+		t.Errorf("violation mismatch:\nExpected: %v\nGot:      %v", expected, violations)
+	}
+}
+
+type panickyTool struct{}
+
+func (p *panickyTool) Name() string             { return "panicky" }
+func (p *panickyTool) Description() string      { return "" }
+func (p *panickyTool) Returns() []*Parameter    { return nil }
+func (p *panickyTool) Parameters() []*Parameter { return nil }
+func (p *panickyTool) Run(args map[string]any) (any, error) {
+	return args["missing"].(string), nil
+}
+
+func TestRegistry_CallValidatesAndRecovers(t *testing.T) {
+	registry := NewRegistry(NewWorkspace(t.TempDir()))
+	registry.Register(&panickyTool{})
+
+	var verr *ValidationError
+	if _, err := registry.Call("read_file", map[string]any{"recurse": true}); !errors.As(err, &verr) {
+		t.Errorf("expected a ValidationError, got %v", err)
+	}
+	if _, err := registry.Call("panicky", nil); err == nil {
+		t.Errorf("expected the panic to be returned as an error")
+	}
+}
+
+func TestRegistry_InvokeEnvelope(t *testing.T) {
+	registry := NewRegistry(NewWorkspace(t.TempDir()))
+	registry.Register(&panickyTool{})
+	ctx := context.Background()
+
+	env := registry.Invoke(ctx, "list_files", map[string]any{"path": ".", "recurse": "true"})
+	if !env.Ok || env.Error != nil || len(env.Warnings) != 1 || !strings.Contains(env.Warnings[0], "recurse") {
+		t.Errorf("expected a successful call with a coercion warning, got %s", env)
+	}
+
+	expectedCodes := map[string]*Envelope{
+		ErrCodeInvalidArguments: registry.Invoke(ctx, "read_file", nil),
+		ErrCodeNotFound:         registry.Invoke(ctx, "read_file", map[string]any{"path": "missing.txt"}),
+		ErrCodeSandbox:          registry.Invoke(ctx, "read_file", map[string]any{"path": "../outside.txt"}),
+		ErrCodeUnknownTool:      registry.Invoke(ctx, "nope", nil),
+		ErrCodePanic:            registry.Invoke(ctx, "panicky", nil),
+	}
+	for code, env := range expectedCodes {
+		if env.Ok || env.Error == nil || env.Error.Code != code {
+			t.Errorf("expected error code %s, got %s", code, env)
+		}
+	}
+	if _, ok := expectedCodes[ErrCodeInvalidArguments].Error.Details.([]Violation); !ok {
+		t.Errorf("expected violations in the error details")
+	}
+
+	var decoded map[string]any
+	if err := json.Unmarshal([]byte(expectedCodes[ErrCodePanic].String()), &decoded); err != nil || decoded["ok"] != false {
+		t.Errorf("expected a parseable envelope, got %v (%v)", decoded, err)
+	}
+	// The shape is the same whatever the outcome
+	if result, ok := decoded["result"]; !ok || result != nil {
+		t.Errorf("expected a null result in failed envelopes, got %v", decoded)
+	}
+}
+
+func TestCoerceArgs(t *testing.T) {
+	params := []*Parameter{
+		{Name: "recurse", Type: "boolean"},
+		{Name: "limit", Type: "integer"},
+		{Name: "contents", Type: "string"},
+		{Name: "include", Type: "array", Items: &Parameter{Type: "string"}},
+		{Name: "options", Type: "object", Properties: []*Parameter{{Name: "depth", Type: "integer"}}},
+	}
+	args := map[string]any{
+		"recurse":  "true",
+		"limit":    "3",
+		"contents": map[string]any{"a": 1.0},
+		"include":  "*.go",
+		"options":  `{"depth": "2"}`,
+	}
+	coercions := CoerceArgs(params, args)
+
+	expected := map[string]any{
+		"recurse":  true,
+		"limit":    3.0,
+		"contents": `{"a":1}`,
+		"include":  []any{"*.go"},
+		"options":  map[string]any{"depth": 2.0},
+	}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("coerced args mismatch:\nExpected: %v\nGot:      %v", expected, args)
+	}
+	if len(coercions) != 6 {
+		t.Errorf("expected 6 coercions, got %v", coercions)
+	}
+	if violations := ValidateArgs(params, args); len(violations) != 0 {
+		t.Errorf("expected coerced args to validate, got %v", violations)
+	}
+}
@@ -0,0 +1,236 @@
+package tools
+
+import (
+	"bufio"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Files whose (gitignore style) patterns are honoured when walking a tree.
+var IgnoreFileNames = []string{".gitignore", ".vibrantignore"}
+
+// Directories that are never descended into when ignore files are honoured.
+var DefaultIgnoredDirs = []string{".git", "node_modules"}
+
+type ignoreRule struct {
+	// slash separated dir (relative to the sandbox root) of the ignore file
+	base     string
+	parts    []string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+// An IgnoreMatcher matches paths against the gitignore style rules collected
+// from the ignore files of the directories walked so far.
+type IgnoreMatcher struct {
+	rules []ignoreRule
+}
+
+// AddPatterns adds the patterns of an ignore file located in the directory
+// base (slash separated and relative to the sandbox root).
+func (m *IgnoreMatcher) AddPatterns(base string, lines []string) {
+	for _, line := range lines {
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule := ignoreRule{base: strings.Trim(base, "/")}
+		if rule.base == "." {
+			rule.base = ""
+		}
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		}
+		line = strings.TrimPrefix(line, "\\")
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		rule.anchored = strings.Contains(line, "/")
+		line = strings.TrimPrefix(line, "/")
+		if line == "" {
+			continue
+		}
+		rule.parts = strings.Split(line, "/")
+		m.rules = append(m.rules, rule)
+	}
+}
+
+// AddFile adds the patterns in the ignore file at fullpath.  Missing files
+// are silently ignored.
+func (m *IgnoreMatcher) AddFile(fullpath, base string) error {
+	file, err := os.Open(fullpath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	m.AddPatterns(base, lines)
+	return scanner.Err()
+}
+
+// Ignored reports whether the slash separated path (relative to the sandbox
+// root) is ignored.  As with git the last matching rule wins.
+func (m *IgnoreMatcher) Ignored(rel string, isDir bool) bool {
+	ignored := false
+	for _, rule := range m.rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		sub := rel
+		if rule.base != "" {
+			if !strings.HasPrefix(rel, rule.base+"/") {
+				continue
+			}
+			sub = rel[len(rule.base)+1:]
+		}
+		parts := strings.Split(sub, "/")
+		matched := false
+		if rule.anchored {
+			matched = matchSegments(rule.parts, parts)
+		} else {
+			matched = matchSegments(rule.parts, parts[len(parts)-1:])
+		}
+		if matched {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+// Options controlling how a directory tree is walked.
+type WalkOptions struct {
+	// Maximum depth to descend to (1 = only the entries of the directory itself).  0 means no limit.
+	MaxDepth int
+
+	// Globs (relative to the walked directory) files must match to be
+	// reported.  Directories are always traversed.
+	Include []string
+
+	// Globs (relative to the walked directory) of files and directories to skip.
+	Exclude []string
+
+	// Whether to honour IgnoreFileNames and skip DefaultIgnoredDirs
+	RespectIgnoreFiles bool
+}
+
+// WalkFunc is called for every entry that passes the filters with the
+// absolute path, the slash separated path relative to the walked directory
+// and the depth of the entry.  Returning filepath.SkipDir or filepath.SkipAll
+// behaves as in filepath.WalkDir.
+type WalkFunc func(fullpath, rel string, depth int, entry fs.DirEntry) error
+
+// WalkTree walks the directory dir (an absolute path inside the sandbox)
+// honouring the sandbox's deny rules, ignore files and the given options.
+func WalkTree(sandbox *Sandbox, dir string, opts WalkOptions, fn WalkFunc) error {
+	matcher := &IgnoreMatcher{}
+	if opts.RespectIgnoreFiles {
+		// Ignore files in the directories above dir (up to the root) apply too
+		if dirRel, err := sandbox.Rel(dir); err == nil && dirRel != "." {
+			root, _ := sandbox.RealRoot()
+			ancestors := strings.Split(dirRel, "/")
+			for i := range ancestors {
+				base := strings.Join(ancestors[:i], "/")
+				for _, name := range IgnoreFileNames {
+					matcher.AddFile(filepath.Join(root, filepath.FromSlash(base), name), base)
+				}
+			}
+		}
+	}
+
+	return filepath.WalkDir(dir, func(fullpath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			// Unreadable entries are skipped rather than aborting the whole
+			// walk - unless there is nothing to walk
+			switch {
+			case fullpath == dir:
+				return err
+			case entry != nil && entry.IsDir():
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		rootRel, err := sandbox.Rel(fullpath)
+		if err != nil {
+			return err
+		}
+		isDir := entry.IsDir()
+		if fullpath == dir {
+			if isDir && opts.RespectIgnoreFiles {
+				for _, name := range IgnoreFileNames {
+					matcher.AddFile(filepath.Join(fullpath, name), rootRel)
+				}
+			}
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, fullpath)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		depth := strings.Count(rel, "/") + 1
+
+		skip := func() error {
+			if isDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if sandbox.Check(rel, rootRel) != nil {
+			return skip()
+		}
+		if opts.RespectIgnoreFiles {
+			if isDir && slices.Contains(DefaultIgnoredDirs, entry.Name()) {
+				return skip()
+			}
+			if matcher.Ignored(rootRel, isDir) {
+				return skip()
+			}
+		}
+		if _, ok := MatchAnyGlob(opts.Exclude, rel); ok {
+			return skip()
+		}
+		if !isDir && len(opts.Include) > 0 {
+			if _, ok := MatchAnyGlob(opts.Include, rel); !ok {
+				return nil
+			}
+		}
+
+		if isDir && opts.RespectIgnoreFiles {
+			for _, name := range IgnoreFileNames {
+				matcher.AddFile(filepath.Join(fullpath, name), rootRel)
+			}
+		}
+
+		if err := fn(fullpath, rel, depth, entry); err != nil {
+			return err
+		}
+		if isDir && opts.MaxDepth > 0 && depth >= opts.MaxDepth {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+}
+
+// parentDir returns the parent of a slash separated relative path ("" for top level entries).
+func parentDir(rel string) string {
+	if dir := path.Dir(rel); dir != "." {
+		return dir
+	}
+	return ""
+}
@@ -0,0 +1,165 @@
+package tools
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func writeTree(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		full := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestListFiles(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		".gitignore":             "build/\n*.log\n!keep.log\n",
+		"main.go":                "package main",
+		"debug.log":              "noise",
+		"keep.log":               "kept",
+		"build/out.bin":          "binary",
+		"node_modules/x/y.js":    "js",
+		"pkg/a.go":               "package pkg",
+		"pkg/sub/b.go":           "package sub",
+		"pkg/sub/.vibrantignore": "generated.go\n",
+		"pkg/sub/generated.go":   "package sub",
+		"docs/readme.md":         "# docs",
+	})
+	lf := &ListFiles{BaseFileTool{Workspace: NewWorkspace(root)}}
+
+	paths := func(args map[string]any) (out []string) {
+		args["path"] = "."
+		result, err := lf.Run(args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, entry := range result.(*ListFilesResult).Entries {
+			out = append(out, entry.Path)
+		}
+		return
+	}
+	check := func(name string, got []string, expected ...string) {
+		t.Helper()
+		if len(got) != len(expected) {
+			t.Errorf("%s: expected %v, got %v", name, expected, got)
+			return
+		}
+		for i := range got {
+			if got[i] != expected[i] {
+				t.Errorf("%s: expected %v, got %v", name, expected, got)
+				return
+			}
+		}
+	}
+
+	check("top level", paths(map[string]any{}), ".gitignore", "docs", "keep.log", "main.go", "pkg")
+	check("flat", paths(map[string]any{"recurse": true, "flat": true}),
+		".gitignore", "docs", "docs/readme.md", "keep.log", "main.go", "pkg", "pkg/a.go", "pkg/sub", "pkg/sub/.vibrantignore", "pkg/sub/b.go")
+	check("include", paths(map[string]any{"recurse": true, "flat": true, "include": []any{"**/*.go"}}),
+		"main.go", "pkg", "pkg/a.go", "pkg/sub", "pkg/sub/b.go")
+	check("exclude", paths(map[string]any{"recurse": true, "flat": true, "max_depth": 1.0, "exclude": []any{"*.log", "pkg"}}),
+		".gitignore", "docs", "main.go")
+	check("no ignore", paths(map[string]any{"respect_ignore": false}),
+		".gitignore", "build", "debug.log", "docs", "keep.log", "main.go", "node_modules", "pkg")
+
+	result, _ := lf.Run(map[string]any{"path": ".", "recurse": true})
+	listing := result.(*ListFilesResult)
+	if pkg := listing.Entries[4]; pkg.Type != "dir" || len(pkg.Children) != 2 || pkg.Children[1].Name != "sub" {
+		t.Errorf("expected nested children for pkg, got %+v", pkg)
+	}
+
+	result, _ = lf.Run(map[string]any{"path": ".", "recurse": true, "flat": true, "max_entries": 3.0})
+	if listing := result.(*ListFilesResult); !listing.Truncated || listing.Count != 3 {
+		t.Errorf("expected a truncated listing of 3 entries, got %+v", listing)
+	}
+}
+
+func TestGrepFiles(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		".gitignore":   "vendor/\n",
+		"main.go":      "package main\n\nfunc main() {\n\tHello()\n}\n",
+		"hello.go":     "package main\n\n// Hello says hello\nfunc Hello() {}\n",
+		"vendor/x.go":  "func Hello() {}\n",
+		"data.bin":     "Hello\x00World",
+		"docs/help.md": "Say hello\n",
+	})
+	registry := NewRegistry(NewWorkspace(root))
+
+	result, err := registry.Call("grep_files", map[string]any{"pattern": `func \w+\(`, "context_lines": 1.0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	grep := result.(*GrepFilesResult)
+	if len(grep.Matches) != 2 || grep.FilesMatched != 2 {
+		t.Fatalf("expected 2 matches in 2 files, got %+v", grep)
+	}
+	if m := grep.Matches[0]; m.File != "hello.go" || m.Line != 4 || m.Column != 1 ||
+		len(m.Before) != 1 || m.Before[0] != "// Hello says hello" || len(m.After) != 0 {
+		t.Errorf("unexpected first match: %+v", m)
+	}
+	if m := grep.Matches[1]; m.File != "main.go" || m.Line != 3 || len(m.After) != 1 || m.After[0] != "\tHello()" {
+		t.Errorf("unexpected second match: %+v", m)
+	}
+
+	result, _ = registry.Call("grep_files", map[string]any{"pattern": "hello", "case_sensitive": false, "include": []any{"**/*.md"}})
+	if grep := result.(*GrepFilesResult); len(grep.Matches) != 1 || grep.Matches[0].File != "docs/help.md" || grep.Matches[0].Column != 5 {
+		t.Errorf("expected a single case insensitive match in docs, got %+v", grep.Matches)
+	}
+
+	result, _ = registry.Call("grep_files", map[string]any{"pattern": "Hello", "max_matches": 2.0})
+	if grep := result.(*GrepFilesResult); len(grep.Matches) != 2 || !grep.Truncated {
+		t.Errorf("expected a truncated search, got %+v", grep)
+	}
+	// Exactly max_matches matches are not a truncated search
+	result, _ = registry.Call("grep_files", map[string]any{"pattern": "Hello", "max_matches": 3.0})
+	if grep := result.(*GrepFilesResult); len(grep.Matches) != 3 || grep.Truncated || grep.FilesMatched != 2 {
+		t.Errorf("expected all 3 matches without truncation, got %+v", grep)
+	}
+
+	if _, err := registry.Call("grep_files", map[string]any{"pattern": "("}); err == nil {
+		t.Error("expected invalid patterns to fail")
+	}
+}
+
+func TestWalkTree_Unreadable(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses POSIX permissions")
+	}
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"a.txt":        "hello",
+		"locked.txt":   "hello",
+		"private/b.go": "hello",
+		"z.txt":        "hello",
+	})
+	os.Chmod(filepath.Join(root, "locked.txt"), 0)
+	os.Chmod(filepath.Join(root, "private"), 0)
+	defer os.Chmod(filepath.Join(root, "private"), 0755)
+	registry := NewRegistry(NewWorkspace(root))
+
+	result, err := registry.Call("list_files", map[string]any{"path": ".", "recurse": true, "flat": true})
+	if err != nil {
+		t.Fatalf("expected unreadable entries to be skipped, got %v", err)
+	}
+	if listing := result.(*ListFilesResult); listing.Count < 4 {
+		t.Errorf("expected the readable files to be listed, got %+v", listing)
+	}
+	result, err = registry.Call("grep_files", map[string]any{"pattern": "hello"})
+	if err != nil {
+		t.Fatalf("expected unreadable files to be skipped, got %v", err)
+	}
+	if grep := result.(*GrepFilesResult); grep.FilesMatched < 2 || grep.Matches[len(grep.Matches)-1].File != "z.txt" {
+		t.Errorf("expected the files after the unreadable ones to be searched, got %+v", grep)
+	}
+}
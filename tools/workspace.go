@@ -0,0 +1,99 @@
+package tools
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// RootSeparator separates a named root from the path inside it, eg "backend:src/main.go".
+const RootSeparator = ":"
+
+var rootNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]+$`)
+
+// A Workspace is the set of directories tools are allowed to operate in.  Paths
+// without a root prefix are resolved in the Default root.  Paths of the form
+// "<name>:<path>" are resolved in the named root so a single session can work
+// across sibling checkouts (eg "backend:go.mod" and "frontend:package.json").
+type Workspace struct {
+	Default *Sandbox
+	Roots   map[string]*Sandbox
+
+	// Decides which commands may be run.  If nil, DefaultCommandPolicy is used.
+	Commands *CommandPolicy
+}
+
+// NewWorkspace creates a workspace with the given default root directory.
+func NewWorkspace(root string) *Workspace {
+	return &Workspace{
+		Default: &Sandbox{Root: root},
+		Roots:   map[string]*Sandbox{},
+	}
+}
+
+// JournalDir returns the directory of the change journal (see Journal) - the
+// DefaultJournalDir of the default root.
+func (w *Workspace) JournalDir() string {
+	return w.defaultRootPath(DefaultJournalDir)
+}
+
+// FileTrackerPath returns the file the hashes of the files read and written
+// by the tools are kept in (see FileTracker) - the DefaultFileTrackerPath of
+// the default root.
+func (w *Workspace) FileTrackerPath() string {
+	return w.defaultRootPath(DefaultFileTrackerPath)
+}
+
+func (w *Workspace) defaultRootPath(path string) string {
+	root, err := w.Default.RealRoot()
+	if err != nil {
+		root = w.Default.Root
+	}
+	return filepath.Join(root, filepath.FromSlash(path))
+}
+
+// AddRoot registers a named root.  Root names must start with a letter or
+// underscore and be at least 2 characters long (so they are never confused
+// with drive letters).
+func (w *Workspace) AddRoot(name string, sandbox *Sandbox) error {
+	if !rootNameRegex.MatchString(name) {
+		return fmt.Errorf("invalid root name: %q", name)
+	}
+	if w.Roots == nil {
+		w.Roots = map[string]*Sandbox{}
+	}
+	w.Roots[name] = sandbox
+	return nil
+}
+
+// RootNames returns the names of all the named roots in sorted order.
+func (w *Workspace) RootNames() (out []string) {
+	for name := range w.Roots {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return
+}
+
+// Split returns the sandbox a path belongs to along with the path inside that
+// sandbox (ie with any root prefix removed).
+func (w *Workspace) Split(path string) (*Sandbox, string) {
+	if idx := strings.Index(path, RootSeparator); idx > 0 {
+		if sandbox, ok := w.Roots[path[:idx]]; ok {
+			return sandbox, path[idx+len(RootSeparator):]
+		}
+	}
+	if w.Default == nil {
+		w.Default = &Sandbox{Root: "."}
+	}
+	return w.Default, path
+}
+
+// Resolve resolves a (possibly root prefixed) path to an absolute path inside
+// its root.  See Sandbox.Resolve.
+func (w *Workspace) Resolve(path string) (string, error) {
+	sandbox, relpath := w.Split(path)
+	return sandbox.Resolve(relpath)
+}
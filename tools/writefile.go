@@ -1,10 +1,14 @@
 package tools
 
 import (
-	"encoding/json"
+	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
-	"log"
 	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
 )
 
 type WriteFile struct {
@@ -16,7 +20,40 @@ func (r *WriteFile) Name() string {
 }
 
 func (r *WriteFile) Description() string {
-	return `Creates and makes sure a file exists with the given contents.  If a given file already exists, then it is overridden with the new contents.`
+	return `
+	Creates and makes sure a file exists with the given contents.
+
+	The 'mode' parameter controls what happens if the file already exists:
+		"overwrite":	The file is replaced with the new contents (the default).
+		"create_only":	The write fails if the file already exists.
+		"append":		The contents are added to the end of the file.
+
+	Files are written atomically - a partially written file is never left behind.
+	Pass the 'hash' returned by read_file as 'expected_hash' to make sure changes made to the file since it was read are not overwritten.
+
+	Returns the number of bytes written along with a summary of the lines added and removed (and a unified diff of the change for text files).
+	`
+}
+
+// Number of diff lines to return in a write summary
+const writeDiffMaxLines = 200
+
+// WriteFileResult is the result of a write_file call.
+type WriteFileResult struct {
+	Path         string `json:"path"`
+	Mode         string `json:"mode"`
+	Created      bool   `json:"created"`
+	BytesWritten int    `json:"bytes_written"`
+	Size         int    `json:"size"`
+
+	// Hash of the new contents (to pass as expected_hash in later writes)
+	Hash string `json:"hash"`
+
+	// Lines added and removed (text files only)
+	Stats *DiffStats `json:"stats,omitempty"`
+
+	// Unified diff of the change (cut short after writeDiffMaxLines lines)
+	Diff string `json:"diff,omitempty"`
 }
 
 func (r *WriteFile) Parameters() []*Parameter {
@@ -32,6 +69,7 @@ func (r *WriteFile) Parameters() []*Parameter {
 			Description: "This should be one of 'plain', 'base64' or 'json' to specify the encoding",
 			Type:        "string",
 			Required:    true,
+			Enum:        []any{"plain", "base64", "json"},
 		},
 		{
 			Name: "contents",
@@ -46,6 +84,18 @@ func (r *WriteFile) Parameters() []*Parameter {
 			Type:     "string",
 			Required: true,
 		},
+		{
+			Name:        "expected_hash",
+			Description: "Hash of the file (from read_file) the new contents are based on.  If the file has changed since, the write is rejected and the current contents are returned instead.",
+			Type:        "string",
+		},
+		{
+			Name:        "mode",
+			Description: "One of 'overwrite' (replace an existing file), 'create_only' (fail if the file exists) or 'append' (add to the end of an existing file)",
+			Type:        "string",
+			Default:     "overwrite",
+			Enum:        []any{"overwrite", "create_only", "append"},
+		},
 	}
 }
 
@@ -53,52 +103,159 @@ func (r *WriteFile) Returns() []*Parameter {
 	return []*Parameter{
 		{
 			Name:        "result",
-			Description: "Error or Number of bytes written",
+			Description: "Error or the number of bytes written along with a summary of the change",
 			Type:        "object",
 		},
 	}
 }
 
 func (r *WriteFile) Run(args map[string]any) (any, error) {
+	return r.RunContext(context.Background(), args)
+}
+
+func (r *WriteFile) RunContext(ctx context.Context, args map[string]any) (any, error) {
 	path := args["path"].(string)
 	fullpath, err := r.ResolvePath(path)
 	if err != nil {
 		return nil, err
 	}
+	mode := "overwrite"
+	if val, ok := args["mode"].(string); ok && val != "" {
+		mode = val
+	}
+	contents, err := decodeWriteContents(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := os.ReadFile(fullpath)
+	exists := err == nil
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if exists && mode == "create_only" {
+		return nil, fmt.Errorf("%s already exists: %w", path, os.ErrExist)
+	}
+	expectedHash, _ := args["expected_hash"].(string)
+	if err := r.CheckWrite(ctx, path, fullpath, expectedHash, existing, exists); err != nil {
+		return nil, err
+	}
+
+	result := &WriteFileResult{Path: path, Mode: mode, Created: !exists, BytesWritten: len(contents)}
+	newContents := contents
+	if mode == "append" {
+		newContents = append(append([]byte{}, existing...), contents...)
+	}
+	result.Size = len(newContents)
+
+	if !exists {
+		if err := os.MkdirAll(filepath.Dir(fullpath), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create directory: %w", err)
+		}
+	}
+	tx := r.Journal.Begin(r.Name())
+	trackChange(ctx, tx, path, fullpath)
+	if mode == "create_only" {
+		// The file may have been created since it was checked for
+		err = CreateFileAtomic(fullpath, newContents, 0644)
+		if errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("%s already exists: %w", path, os.ErrExist)
+		}
+	} else {
+		err = WriteFileAtomic(fullpath, newContents, 0644)
+	}
+	if err != nil {
+		return nil, err
+	}
+	commitChange(ctx, tx)
+	result.Hash = HashContent(newContents)
+	r.Files.Record(fullpath, result.Hash)
+
+	if !isBinary(existing) && !isBinary(newContents) {
+		diffs := DiffLineSlices(SplitLines(string(existing)), SplitLines(string(newContents)))
+		stats := Stats(diffs)
+		result.Stats = &stats
+		result.Diff = truncateLines(UnifiedDiff("a/"+path, "b/"+path, string(existing), string(newContents), 3), writeDiffMaxLines)
+	}
+	return result, nil
+}
+
+// decodeWriteContents decodes the contents argument as per its encoding.
+func decodeWriteContents(ctx context.Context, args map[string]any) ([]byte, error) {
 	encoding := "json"
 	if args["encoding"] != nil {
 		encoding = args["encoding"].(string)
 	}
-	contents := args["contents"].(string)
+	contents := []byte(args["contents"].(string))
 
 	if encoding == "plain" {
 		// do nothign
 	} else if encoding == "base64" {
-		// TODO
+		decoded, err := decodeBase64(string(contents))
+		if err != nil {
+			return nil, fmt.Errorf("contents are not valid base64: %w", err)
+		}
+		contents = decoded
 	} else {
 		// json encoding
-		var out string
-		if err := json.Unmarshal([]byte(contents), &out); err != nil {
-			log.Print("Using as is.  Cannot unmarshall json: ", err)
+		if decoded, ok := DecodeJSONString(string(contents)); ok {
+			contents = []byte(decoded)
 		} else {
-			log.Println("Here.....")
-			contents = out
+			Warn(ctx, "contents are not a JSON encoded string so were written as is - use encoding 'plain' for raw text")
 		}
 	}
+	return contents, nil
+}
 
-	// TODO - replace with stat
-	_, err = os.ReadFile(fullpath)
+// Preview returns the diff the write would make.
+func (r *WriteFile) Preview(ctx context.Context, args map[string]any) (string, error) {
+	path := args["path"].(string)
+	fullpath, err := r.ResolvePath(path)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return createNewFile(fullpath, contents)
-		}
 		return "", err
 	}
-
-	err = os.WriteFile(fullpath, []byte(contents), 0644)
+	contents, err := decodeWriteContents(context.Background(), args)
 	if err != nil {
 		return "", err
 	}
+	existing, err := os.ReadFile(fullpath)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	if mode, _ := args["mode"].(string); mode == "append" {
+		contents = append(append([]byte{}, existing...), contents...)
+	}
+	if isBinary(existing) || isBinary(contents) {
+		return fmt.Sprintf("Write %d bytes of binary data to %s", len(contents), path), nil
+	}
+	from := "a/" + path
+	if existing == nil {
+		from = DevNull
+	}
+	return UnifiedDiff(from, "b/"+path, string(existing), string(contents), 3), nil
+}
 
-	return fmt.Sprintf("Written %d bytes to %s", len([]byte(contents)), path), nil
+// decodeBase64 decodes standard or URL safe base64 with or without padding
+// (and ignoring any line breaks) as models produce all of them.
+func decodeBase64(data string) ([]byte, error) {
+	data = strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, data)
+	data = strings.TrimRight(data, "=")
+	if strings.ContainsAny(data, "-_") {
+		return base64.RawURLEncoding.DecodeString(data)
+	}
+	return base64.RawStdEncoding.DecodeString(data)
+}
+
+// truncateLines cuts text down to maxLines lines noting how many were left out.
+func truncateLines(text string, maxLines int) string {
+	lines := strings.SplitAfter(text, "\n")
+	if len(lines) <= maxLines+1 {
+		return text
+	}
+	return strings.Join(lines[:maxLines], "") + fmt.Sprintf("... [%d more lines] ...\n", len(lines)-maxLines-1)
 }
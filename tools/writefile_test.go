@@ -0,0 +1,140 @@
+package tools
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	old := "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\n"
+	new := "a\nB\nc\nd\ne\nf\ng\nh\ni\nj\nk\n"
+	expected := `--- old
++++ new
+@@ -1,3 +1,3 @@
+ a
+-b
++B
+ c
+@@ -10,1 +10,2 @@
+ j
++k
+`
+	if diff := UnifiedDiff("old", "new", old, new, 1); diff != expected {
+		t.Errorf("diff mismatch:\nExpected:\n%s\nGot:\n%s", expected, diff)
+	}
+	if stats := Stats(DiffLineSlices(SplitLines(old), SplitLines(new))); stats != (DiffStats{Added: 2, Removed: 1}) {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+	if diff := UnifiedDiff("old", "new", old, old, 3); diff != "" {
+		t.Errorf("expected no diff for identical texts, got %s", diff)
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	root := t.TempDir()
+	registry := NewRegistry(NewWorkspace(root))
+	write := func(args map[string]any) (*WriteFileResult, error) {
+		env := registry.Invoke(t.Context(), "write_file", args)
+		if !env.Ok {
+			return nil, errors.New(env.Error.Message)
+		}
+		return env.Result.(*WriteFileResult), nil
+	}
+
+	result, err := write(map[string]any{"path": "dir/notes.txt", "encoding": "plain", "contents": "one\ntwo\n", "mode": "create_only"})
+	if err != nil || !result.Created || result.Stats.Added != 2 {
+		t.Fatalf("expected the file to be created, got %+v (%v)", result, err)
+	}
+	if _, err := write(map[string]any{"path": "dir/notes.txt", "encoding": "plain", "contents": "x", "mode": "create_only"}); err == nil {
+		t.Errorf("expected create_only to fail for existing files")
+	}
+
+	full := filepath.Join(root, "dir", "notes.txt")
+	os.Chmod(full, 0600)
+	result, err = write(map[string]any{"path": "dir/notes.txt", "encoding": "plain", "contents": "three\n", "mode": "append"})
+	if err != nil || result.Created || result.Size != 14 || !strings.Contains(result.Diff, "+three") {
+		t.Errorf("unexpected append result: %+v (%v)", result, err)
+	}
+	if info, _ := os.Stat(full); info.Mode().Perm() != 0600 {
+		t.Errorf("expected permissions to be preserved, got %v", info.Mode())
+	}
+
+	result, err = write(map[string]any{"path": "image.bin", "encoding": "base64", "contents": "AAEC\n/w=="})
+	if err != nil || result.Stats != nil {
+		t.Fatalf("unexpected binary write result: %+v (%v)", result, err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(root, "image.bin")); string(data) != "\x00\x01\x02\xff" {
+		t.Errorf("expected base64 contents to be decoded, got %q", data)
+	}
+
+	env := registry.Invoke(t.Context(), "write_file", map[string]any{"path": "raw.txt", "encoding": "json", "contents": "not json"})
+	if !env.Ok || len(env.Warnings) != 1 || !strings.Contains(env.Warnings[0], "written as is") {
+		t.Errorf("expected non JSON contents to be written with a warning, got %s", env)
+	}
+
+	// A file created after the create_only check is not replaced
+	if err := CreateFileAtomic(full, []byte("late"), 0644); !errors.Is(err, os.ErrExist) {
+		t.Errorf("expected creating an existing file to fail, got %v", err)
+	}
+	if data, _ := os.ReadFile(full); string(data) != "one\ntwo\nthree\n" {
+		t.Errorf("expected the existing file to be untouched, got %q", data)
+	}
+
+	entries, _ := os.ReadDir(filepath.Join(root, "dir"))
+	if len(entries) != 1 {
+		t.Errorf("expected no temporary files to be left behind, got %v", entries)
+	}
+}
+
+func TestWriteFile_StaleWrites(t *testing.T) {
+	root := t.TempDir()
+	full := filepath.Join(root, "main.go")
+	os.WriteFile(full, []byte("package main\n"), 0644)
+	registry := NewRegistry(NewWorkspace(root))
+	ctx := t.Context()
+
+	env := registry.Invoke(ctx, "write_file", map[string]any{"path": "main.go", "encoding": "plain", "contents": "package blind\n"})
+	if !env.Ok || len(env.Warnings) != 1 || !strings.Contains(env.Warnings[0], "without being read") {
+		t.Errorf("expected a blind overwrite warning, got %s", env)
+	}
+
+	env = registry.Invoke(ctx, "read_file", map[string]any{"path": "main.go"})
+	hash := env.Result.(*ReadFileResult).Hash
+	if hash != HashContent([]byte("package blind\n")) {
+		t.Fatalf("unexpected hash from read_file: %s", env)
+	}
+
+	// A human edits the file in between
+	os.WriteFile(full, []byte("package human\n"), 0644)
+	env = registry.Invoke(ctx, "write_file", map[string]any{"path": "main.go", "encoding": "plain", "contents": "package model\n", "expected_hash": hash})
+	if env.Ok || env.Error.Code != ErrCodeStaleWrite {
+		t.Fatalf("expected a stale write error, got %s", env)
+	}
+	if stale := env.Error.Details.(*StaleWriteError); stale.CurrentContent != "package human\n" || stale.ExpectedHash != hash {
+		t.Errorf("expected the current content in the error, got %+v", stale)
+	}
+	if data, _ := os.ReadFile(full); string(data) != "package human\n" {
+		t.Errorf("expected the human's edit to survive, got %q", data)
+	}
+
+	env = registry.Invoke(ctx, "write_file", map[string]any{"path": "main.go", "encoding": "plain", "contents": "package model\n"})
+	if !env.Ok || len(env.Warnings) != 1 || !strings.Contains(env.Warnings[0], "changed since") {
+		t.Errorf("expected a changed since last read warning, got %s", env)
+	}
+	env = registry.Invoke(ctx, "write_file", map[string]any{"path": "main.go", "encoding": "plain", "contents": "package again\n",
+		"expected_hash": env.Result.(*WriteFileResult).Hash})
+	if !env.Ok || len(env.Warnings) != 0 {
+		t.Errorf("expected a clean write with a matching hash, got %s", env)
+	}
+
+	// Reads are remembered across registries (eg separate calls respond runs)
+	registry = NewRegistry(NewWorkspace(root))
+	registry.Invoke(ctx, "read_file", map[string]any{"path": "main.go"})
+	env = NewRegistry(NewWorkspace(root)).Invoke(ctx, "write_file", map[string]any{"path": "main.go", "encoding": "plain", "contents": "package later\n"})
+	if !env.Ok || len(env.Warnings) != 0 {
+		t.Errorf("expected the read by another registry to count, got %s", env)
+	}
+}